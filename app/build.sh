@@ -8,6 +8,8 @@ export AWS_PROFILE=tigris
 
 go build -o x-app.wasm ./v1/flight
 go build -o x-app-airway.wasm ./v1/airway
+go build -o x-app-converter.wasm ./converter
 
 yoke stow ./x-app.wasm oci://registry.int.xeserv.us/x-app/flight:v1
-yoke stow ./x-app-airway.wasm oci://registry.int.xeserv.us/x-app/airway:v1
\ No newline at end of file
+yoke stow ./x-app-airway.wasm oci://registry.int.xeserv.us/x-app/airway:v1
+yoke stow ./x-app-converter.wasm oci://registry.int.xeserv.us/x-app/converter:v1
\ No newline at end of file
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+	v2 "github.com/Xe/yoke-stuff/app/v2"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run answers a ConversionReview. The ATC passes the API server's review on standard input and serves the review
+// printed to standard output as the response.
+func run() error {
+	var review apiextv1.ConversionReview
+	if err := yaml.NewYAMLToJSONDecoder(os.Stdin).Decode(&review); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to decode ConversionReview: %w", err)
+	}
+	if review.Request == nil {
+		return fmt.Errorf("ConversionReview has no request")
+	}
+
+	response := convert(review.Request)
+	response.UID = review.Request.UID
+
+	review.Request = nil
+	review.Response = response
+
+	return json.NewEncoder(os.Stdout).Encode(review)
+}
+
+// convert converts every object in req to the desired version. If one of them fails, the whole request does.
+func convert(req *apiextv1.ConversionRequest) *apiextv1.ConversionResponse {
+	var fn func([]byte) ([]byte, error)
+	switch req.DesiredAPIVersion {
+	case v1.APIVersion:
+		fn = v2.ConvertToV1
+	case v2.APIVersion:
+		fn = v2.ConvertToV2
+	default:
+		return failure(metav1.StatusReasonBadRequest, fmt.Sprintf("can't convert to %s", req.DesiredAPIVersion))
+	}
+
+	converted := make([]runtime.RawExtension, len(req.Objects))
+	for i, obj := range req.Objects {
+		var meta metav1.TypeMeta
+		if err := json.Unmarshal(obj.Raw, &meta); err != nil {
+			return failure(metav1.StatusReasonBadRequest, fmt.Sprintf("failed to decode object %d: %v", i, err))
+		}
+
+		// The API server only asks for objects that are in another version, but it costs nothing to be sure.
+		if meta.APIVersion == req.DesiredAPIVersion {
+			converted[i] = obj
+			continue
+		}
+
+		data, err := fn(obj.Raw)
+		if err != nil {
+			return failure(metav1.StatusReasonInvalid, fmt.Sprintf("failed to convert object %d from %s: %v", i, meta.APIVersion, err))
+		}
+		converted[i] = runtime.RawExtension{Raw: data}
+	}
+
+	return &apiextv1.ConversionResponse{
+		Result:           metav1.Status{Status: metav1.StatusSuccess},
+		ConvertedObjects: converted,
+	}
+}
+
+func failure(reason metav1.StatusReason, message string) *apiextv1.ConversionResponse {
+	return &apiextv1.ConversionResponse{
+		Result: metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  reason,
+			Message: message,
+		},
+	}
+}
@@ -2,4 +2,4 @@
 
 set -euo pipefail
 
-yoke takeoff appairway oci://reg.xeiaso.net/x-app/airway:v1 -- --flight-url=oci://reg.xeiaso.net/x-app/flight:v1
\ No newline at end of file
+yoke takeoff appairway oci://reg.xeiaso.net/x-app/airway:v1 -- --flight-url=oci://reg.xeiaso.net/x-app/flight:v1 --converter-url=oci://reg.xeiaso.net/x-app/converter:v1
\ No newline at end of file
@@ -11,13 +11,15 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/yokecd/yoke/pkg/apis/airway/v1alpha1"
-	"github.com/yokecd/yoke/pkg/openapi"
 
 	v1 "github.com/Xe/yoke-stuff/app/v1"
+	v2 "github.com/Xe/yoke-stuff/app/v2"
+	"github.com/Xe/yoke-stuff/internal/schema"
 )
 
 var (
-	flightURL = flag.String("flight-url", "https://minio.xeserv.us/mi-static/yoke/x-app/v1.wasm.gz", "the URL to the Wasm module to load")
+	flightURL    = flag.String("flight-url", "https://minio.xeserv.us/mi-static/yoke/x-app/v1.wasm.gz", "the URL to the Wasm module to load")
+	converterURL = flag.String("converter-url", "https://minio.xeserv.us/mi-static/yoke/x-app/converter.wasm.gz", "the URL to the Wasm module that converts between App versions")
 )
 
 func main() {
@@ -37,7 +39,8 @@ func run() error {
 		Spec: v1alpha1.AirwaySpec{
 			ClusterAccess: true,
 			WasmURLs: v1alpha1.WasmURLs{
-				Flight: *flightURL,
+				Flight:    *flightURL,
+				Converter: *converterURL,
 			},
 			Template: apiextv1.CustomResourceDefinitionSpec{
 				Group: "x.within.website",
@@ -53,11 +56,56 @@ func run() error {
 						Served:  true,
 						Storage: true,
 						Schema: &apiextv1.CustomResourceValidation{
-							OpenAPIV3Schema: openapi.SchemaFrom(reflect.TypeFor[v1.App]()),
+							OpenAPIV3Schema: schema.SchemaFrom(reflect.TypeFor[v1.App]()),
 						},
+						AdditionalPrinterColumns: printerColumns(".spec.ingress.host"),
+					},
+					// v2 is converted to and from v1 by the converter, see app/v2.
+					{
+						Name:    "v2",
+						Served:  true,
+						Storage: false,
+						Schema: &apiextv1.CustomResourceValidation{
+							OpenAPIV3Schema: schema.SchemaFrom(reflect.TypeFor[v2.App]()),
+						},
+						AdditionalPrinterColumns: printerColumns(".spec.ingress.hosts[0]"),
 					},
 				},
 			},
 		},
 	})
 }
+
+// printerColumns are the columns kubectl get shows for an App version. host is the JSONPath of its ingress host.
+func printerColumns(host string) []apiextv1.CustomResourceColumnDefinition {
+	// The ATC owns the status subresource and only reports whether the flight's resources are ready, so these
+	// columns show that next to where the App is exposed.
+	return []apiextv1.CustomResourceColumnDefinition{
+		{
+			Name:     "Image",
+			Type:     "string",
+			JSONPath: ".spec.image",
+		},
+		{
+			Name:     "Host",
+			Type:     "string",
+			JSONPath: host,
+		},
+		{
+			Name:     "Status",
+			Type:     "string",
+			JSONPath: ".status.status",
+		},
+		{
+			Name:     "Message",
+			Type:     "string",
+			JSONPath: ".status.msg",
+			Priority: 1,
+		},
+		{
+			Name:     "Age",
+			Type:     "date",
+			JSONPath: ".metadata.creationTimestamp",
+		},
+	}
+}
@@ -1,14 +1,33 @@
 package v1
 
 import (
+	"cmp"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"net"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/robfig/cron/v3"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+
+	"github.com/Xe/yoke-stuff/internal/podmeta"
 )
 
 const (
@@ -20,40 +39,571 @@ const (
 type App struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
-	Spec              AppSpec `json:"spec"`
+	Spec              AppSpec `json:"spec" description:"The desired state of the App."`
 }
 
 // Our Backend Specification
 type AppSpec struct {
-	AutoUpdate       bool            `json:"autoUpdate,omitempty" yaml:"autoUpdate,omitempty"`
-	Image            string          `json:"image" yaml:"image"`
-	ImagePullSecrets []string        `json:"imagePullSecrets,omitempty" yaml:"imagePullSecrets,omitempty"`
-	LogLevel         string          `json:"logLevel,omitempty" yaml:"logLevel,omitempty"`
-	Replicas         int32           `json:"replicas,omitempty" yaml:"replicas,omitempty"`
-	Port             int             `json:"port,omitempty" yaml:"port,omitempty"`
-	RunAsRoot        bool            `json:"runAsRoot,omitempty" yaml:"runAsRoot,omitempty"`
-	Env              []corev1.EnvVar `json:"env,omitempty" yaml:"env,omitempty"`
+	AutoUpdate        *AutoUpdate     `json:"autoUpdate,omitempty" yaml:"autoUpdate,omitempty" description:"Settings for automatically updating the App with Keel. true is the same as enabling it with the default policy, trigger, and poll schedule."`
+	Image             string          `json:"image" yaml:"image" description:"The Docker/OCI image for the App." example:"ghcr.io/xe/x/stickers:latest"`
+	ContainerName     string          `json:"containerName,omitempty" yaml:"containerName,omitempty" description:"The name of the App's container. Defaults to the App name, or app when the App name isn't a valid container name." example:"app"`
+	ImagePullPolicy   string          `json:"imagePullPolicy,omitempty" yaml:"imagePullPolicy,omitempty" description:"When the App's containers pull their image: Always (default), IfNotPresent, or Never. IfNotPresent and Never use the image the node already has." Enum:"Always,IfNotPresent,Never"`
+	PinDigest         bool            `json:"pinDigest,omitempty" yaml:"pinDigest,omitempty" description:"If true, run the digest the App's pods pulled for image instead of the tag, until image changes. Needs the flight to be allowed to look up the App's Deployment, Endpoints, and Pods."`
+	ImagePullSecrets  []string        `json:"imagePullSecrets,omitempty" yaml:"imagePullSecrets,omitempty" description:"The names of any ImagePullSecrets needed to pull the Docker/OCI image."`
+	StrictReferences  bool            `json:"strictReferences,omitempty" yaml:"strictReferences,omitempty" description:"If true, fail rendering when a Secret or ConfigMap the App references does not exist in the cluster."`
+	NameSuffix        string          `json:"nameSuffix,omitempty" yaml:"nameSuffix,omitempty" description:"If set, appended to the names, selector, and ingress host of everything the App creates, so a preview can run next to the real App in the same namespace." example:"pr-42"`
+	LogLevel          string          `json:"logLevel,omitempty" yaml:"logLevel,omitempty" description:"The log/slog level for the App, exposed as SLOG_LEVEL. Defaults to info."`
+	Workload          string          `json:"workload,omitempty" yaml:"workload,omitempty" description:"The kind of workload to run the App as: deployment (default) or statefulset." Enum:"deployment,statefulset"`
+	Replicas          *int32          `json:"replicas,omitempty" yaml:"replicas,omitempty" description:"The number of replicas that should be deployed for the App. Defaults to 1, and 0 scales the App down." Minimum:"0"`
+	Suspend           bool            `json:"suspend,omitempty" yaml:"suspend,omitempty" description:"If true, scale the App to zero while keeping everything else, such as its storage, secrets, and ingress. Unsetting it brings back replicas."`
+	Port              int             `json:"port,omitempty" yaml:"port,omitempty" description:"The port the App is listening on for HTTP traffic. Defaults to 3000." example:"3000"`
+	Protocol          string          `json:"protocol,omitempty" yaml:"protocol,omitempty" description:"The protocol of the App port: TCP (default), UDP, or both." Enum:"TCP,UDP,both"`
+	RunAsRoot         bool            `json:"runAsRoot,omitempty" yaml:"runAsRoot,omitempty" description:"If true, run the App's containers as root without any security hardening."`
+	PriorityClassName string          `json:"priorityClassName,omitempty" yaml:"priorityClassName,omitempty" description:"The PriorityClass for the App's pods, which controls the order pods are evicted under node pressure." example:"infra-critical"`
+	Env               []corev1.EnvVar `json:"env,omitempty" yaml:"env,omitempty" description:"Additional environment variables for the App. Do not put secret values here."`
+	EnvFromConfigMaps []string        `json:"envFromConfigMaps,omitempty" yaml:"envFromConfigMaps,omitempty" description:"The names of existing ConfigMaps whose keys are set as environment variables." example:"[\"shared-config\"]"`
+	EnvFromSecrets    []string        `json:"envFromSecrets,omitempty" yaml:"envFromSecrets,omitempty" description:"The names of existing Secrets, not managed by the App, whose keys are set as environment variables." example:"[\"smtp-credentials\"]"`
+
+	DeploymentAnnotations map[string]string `json:"deploymentAnnotations,omitempty" yaml:"deploymentAnnotations,omitempty" description:"Additional annotations added to the App's Deployment or StatefulSet. These win over the ones the App sets itself, such as Keel's." example:"{\"reloader.stakater.com/auto\":\"true\"}"`
+	PodAnnotations        map[string]string `json:"podAnnotations,omitempty" yaml:"podAnnotations,omitempty" description:"Additional annotations added to the App's pods, such as prometheus.io/scrape or linkerd.io/inject." example:"{\"prometheus.io/scrape\":\"true\"}"`
+
+	SecurityContext *SecurityContext `json:"securityContext,omitempty" yaml:"securityContext,omitempty" description:"The user and groups the App runs as. The rest of the hardening stays in place."`
+
+	TerminationGracePeriodSeconds *int64            `json:"terminationGracePeriodSeconds,omitempty" yaml:"terminationGracePeriodSeconds,omitempty" description:"How long the App's pods get to shut down after SIGTERM before they are killed. Defaults to 30 seconds." example:"120"`
+	GracefulShutdown              *GracefulShutdown `json:"gracefulShutdown,omitempty" yaml:"gracefulShutdown,omitempty" description:"Settings for waiting for endpoints to be updated before the App is told to shut down."`
+
+	DNSPolicy string               `json:"dnsPolicy,omitempty" yaml:"dnsPolicy,omitempty" description:"The DNS policy of the App's pods: ClusterFirst (default), ClusterFirstWithHostNet, Default, or None." Enum:"ClusterFirst,ClusterFirstWithHostNet,Default,None"`
+	DNSConfig *corev1.PodDNSConfig `json:"dnsConfig,omitempty" yaml:"dnsConfig,omitempty" description:"Extra nameservers, search domains, and resolver options for the App's pods, such as ndots."`
+
+	HostNetwork           bool  `json:"hostNetwork,omitempty" yaml:"hostNetwork,omitempty" description:"If true, run the App's pods in the node's network namespace so it binds the node's own interfaces. dnsPolicy defaults to ClusterFirstWithHostNet, and only one pod runs per node."`
+	ShareProcessNamespace *bool `json:"shareProcessNamespace,omitempty" yaml:"shareProcessNamespace,omitempty" description:"If true, the containers of the App's pods see each other's processes, so a sidecar can signal the App."`
+
+	RevisionHistoryLimit    *int32    `json:"revisionHistoryLimit,omitempty" yaml:"revisionHistoryLimit,omitempty" description:"How many old ReplicaSets (or StatefulSet revisions) to keep for rolling back. Defaults to 3, 0 keeps none." example:"3"`
+	ProgressDeadlineSeconds *int32    `json:"progressDeadlineSeconds,omitempty" yaml:"progressDeadlineSeconds,omitempty" description:"How long a rollout may make no progress before the Deployment is marked as failed. Defaults to 600." example:"1200"`
+	MinReadySeconds         *int32    `json:"minReadySeconds,omitempty" yaml:"minReadySeconds,omitempty" description:"How long a new pod has to be ready without crashing before it counts as available, which slows down rollouts of broken versions. Defaults to 0." example:"10" Minimum:"0"`
+	Strategy                *Strategy `json:"strategy,omitempty" yaml:"strategy,omitempty" description:"How many pods a rolling update may add or take away at a time."`
+	Canary                  *Canary   `json:"canary,omitempty" yaml:"canary,omitempty" description:"Settings for running one pod of another image next to the App, which gets a share of the App's traffic."`
+
+	Size           string          `json:"size,omitempty" yaml:"size,omitempty" description:"A preset for resources: small, medium, or large. Anything set in resources wins over the preset." Enum:"small,medium,large"`
+	Resources      *Resources      `json:"resources,omitempty" yaml:"resources,omitempty" description:"The compute resources the App's containers request and are limited to."`
+	ResourcePolicy *ResourcePolicy `json:"resourcePolicy,omitempty" yaml:"resourcePolicy,omitempty" description:"Platform rules the App's resources have to follow."`
+
+	GPU              *GPU   `json:"gpu,omitempty" yaml:"gpu,omitempty" description:"GPUs for the App's containers, a shorthand for setting them in resources."`
+	RuntimeClassName string `json:"runtimeClassName,omitempty" yaml:"runtimeClassName,omitempty" description:"The RuntimeClass the App's pods run with, such as gvisor to sandbox untrusted Apps or nvidia for the NVIDIA container runtime." example:"gvisor"`
+
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty" yaml:"topologySpreadConstraints,omitempty" description:"How to spread the App's pods across the cluster. If a constraint has no labelSelector, it selects the App's pods."`
+	SpreadAcrossZones         bool                              `json:"spreadAcrossZones,omitempty" yaml:"spreadAcrossZones,omitempty" description:"If true, prefer spreading the App's pods evenly across zones (maxSkew 1 on topology.kubernetes.io/zone)."`
+
+	Healthcheck    *Healthcheck    `json:"healthcheck,omitempty" yaml:"healthcheck,omitempty" description:"Liveness and readiness probe settings."`
+	Ingress        *Ingress        `json:"ingress,omitempty" yaml:"ingress,omitempty" description:"Settings for exposing the App to the public Internet over HTTP."`
+	DNS            *ExternalDNS    `json:"dns,omitempty" yaml:"dns,omitempty" description:"Settings for publishing the App's hostname with external-dns."`
+	Onion          *Onion          `json:"onion,omitempty" yaml:"onion,omitempty" description:"Settings for exposing the App as a Tor hidden service."`
+	Expose         string          `json:"expose,omitempty" yaml:"expose,omitempty" description:"How the App is reached from outside the cluster: ingress (default), which uses the ingress settings, or tailscale, which only puts the App on a tailnet with a Tailscale sidecar and creates no Ingress." Enum:"ingress,tailscale"`
+	Tailscale      *Tailscale      `json:"tailscale,omitempty" yaml:"tailscale,omitempty" description:"Settings for the Tailscale sidecar of expose: tailscale."`
+	Storage        *Storage        `json:"storage,omitempty" yaml:"storage,omitempty" description:"A persistent volume mounted into the App."`
+	Role           *Role           `json:"role,omitempty" yaml:"role,omitempty" description:"RBAC rules granted to the App's ServiceAccount."`
+	ServiceAccount *ServiceAccount `json:"serviceAccount,omitempty" yaml:"serviceAccount,omitempty" description:"Settings for the App's ServiceAccount."`
+	Anubis         *Anubis         `json:"anubis,omitempty" yaml:"anubis,omitempty" description:"Settings for protecting the App with Anubis."`
+	Service        *Service        `json:"service,omitempty" yaml:"service,omitempty" description:"Settings for the App's Service."`
+	Mesh           string          `json:"mesh,omitempty" yaml:"mesh,omitempty" description:"The service mesh to add the App's pods to: linkerd or istio. The Ingress and Service are set up to send traffic through the mesh." Enum:"linkerd,istio"`
+
+	Crons         []Cron         `json:"crons,omitempty" yaml:"crons,omitempty" description:"Periodic jobs that run with the App's image, environment, and secrets."`
+	RestartPolicy *RestartPolicy `json:"restartPolicy,omitempty" yaml:"restartPolicy,omitempty" description:"Settings for periodically restarting the App's pods."`
+	Alerts        *Alerts        `json:"alerts,omitempty" yaml:"alerts,omitempty" description:"Prometheus alerts for the App, created as a PrometheusRule. Needs the Prometheus Operator's CRDs in the cluster."`
+	Bootstrap     *Bootstrap     `json:"bootstrap,omitempty" yaml:"bootstrap,omitempty" description:"A command run once after the App is deployed, such as creating an admin user."`
+
+	Volumes []Volume `json:"volumes,omitempty" yaml:"volumes,omitempty" description:"Additional persistent volumes mounted into the App."`
+
+	Scratch []ScratchVolume `json:"scratch,omitempty" yaml:"scratch,omitempty" description:"Ephemeral emptyDir volumes mounted into the App, such as /tmp for a read-only root filesystem."`
+
+	Secrets    []Secret    `json:"secrets,omitempty" yaml:"secrets,omitempty" description:"Secrets synced from 1Password into the App."`
+	ConfigMaps []ConfigMap `json:"configMaps,omitempty" yaml:"configmaps,omitempty" description:"ConfigMaps created for the App and mounted as folders."`
+
+	ExtraVolumes      []corev1.Volume      `json:"extraVolumes,omitempty" yaml:"extraVolumes,omitempty" description:"Volumes added to the App's pods as they are, for what the other settings can't express, such as hostPath or CSI volumes."`
+	ExtraVolumeMounts []corev1.VolumeMount `json:"extraVolumeMounts,omitempty" yaml:"extraVolumeMounts,omitempty" description:"Volume mounts added to the App's container as they are. Each has to mount one of extraVolumes or a volume the App creates itself."`
+}
+
+// Valid checks the settings that would otherwise only fail once the generated objects reach the API server. Every
+// problem is reported at once.
+func (s AppSpec) Valid() error {
+	var errs []error
+	if s.Image == "" {
+		errs = append(errs, fmt.Errorf("image is required"))
+	}
+	// A port of 0 means the default.
+	if s.Port < 0 || s.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be between 1 and 65535, got %d", s.Port))
+	}
+	if s.Replicas != nil && *s.Replicas < 0 {
+		errs = append(errs, fmt.Errorf("replicas can't be negative, got %d", *s.Replicas))
+	}
+	if s.RevisionHistoryLimit != nil && *s.RevisionHistoryLimit < 0 {
+		errs = append(errs, fmt.Errorf("revisionHistoryLimit can't be negative, got %d", *s.RevisionHistoryLimit))
+	}
+	if s.MinReadySeconds != nil && *s.MinReadySeconds < 0 {
+		errs = append(errs, fmt.Errorf("minReadySeconds can't be negative, got %d", *s.MinReadySeconds))
+	}
+	if s.ProgressDeadlineSeconds != nil && *s.ProgressDeadlineSeconds <= 0 {
+		errs = append(errs, fmt.Errorf("progressDeadlineSeconds must be positive, got %d", *s.ProgressDeadlineSeconds))
+	}
+	if s.TerminationGracePeriodSeconds != nil && *s.TerminationGracePeriodSeconds < 0 {
+		errs = append(errs, fmt.Errorf("terminationGracePeriodSeconds can't be negative, got %d", *s.TerminationGracePeriodSeconds))
+	}
+	if s.Healthcheck != nil && (s.Healthcheck.Port < 0 || s.Healthcheck.Port > 65535) {
+		errs = append(errs, fmt.Errorf("healthcheck.port must be between 1 and 65535, got %d", s.Healthcheck.Port))
+	}
+	if s.Resources != nil {
+		errs = append(errs, s.Resources.violations()...)
+	}
+	if s.ResourcePolicy != nil {
+		errs = append(errs, s.ResourcePolicy.violations(s.Resources)...)
+	}
+	for _, name := range s.EnvFromConfigMaps {
+		if problems := validation.IsDNS1123Subdomain(name); len(problems) != 0 {
+			errs = append(errs, fmt.Errorf("invalid envFromConfigMaps name %q: %s", name, strings.Join(problems, ", ")))
+		}
+	}
+	for _, name := range s.EnvFromSecrets {
+		if problems := validation.IsDNS1123Subdomain(name); len(problems) != 0 {
+			errs = append(errs, fmt.Errorf("invalid envFromSecrets name %q: %s", name, strings.Join(problems, ", ")))
+		}
+	}
+	if name := s.ContainerName; name != "" {
+		if problems := validation.IsDNS1123Label(name); len(problems) != 0 {
+			errs = append(errs, fmt.Errorf("invalid containerName %q: %s", name, strings.Join(problems, ", ")))
+		}
+	}
+	if s.PriorityClassName != "" {
+		if problems := validation.IsDNS1123Subdomain(s.PriorityClassName); len(problems) != 0 {
+			errs = append(errs, fmt.Errorf("invalid priorityClassName %q: %s", s.PriorityClassName, strings.Join(problems, ", ")))
+		}
+	}
+	switch s.DNSPolicy {
+	case "", string(corev1.DNSClusterFirst), string(corev1.DNSClusterFirstWithHostNet), string(corev1.DNSDefault):
+		// all is good
+	case string(corev1.DNSNone):
+		if s.DNSConfig == nil || len(s.DNSConfig.Nameservers) == 0 {
+			errs = append(errs, fmt.Errorf("dnsPolicy None requires at least one dnsConfig.nameservers entry"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown dnsPolicy %q, must be one of ClusterFirst, ClusterFirstWithHostNet, Default, or None", s.DNSPolicy))
+	}
+	switch s.ImagePullPolicy {
+	case "", "Always", "IfNotPresent", "Never":
+		// all is good
+	default:
+		errs = append(errs, fmt.Errorf("unknown imagePullPolicy %q, must be one of Always, IfNotPresent, or Never", s.ImagePullPolicy))
+	}
+	if err := apivalidation.ValidateAnnotations(s.DeploymentAnnotations, field.NewPath("deploymentAnnotations")).ToAggregate(); err != nil {
+		errs = append(errs, err)
+	}
+	// The App has no podLabels or commonLabels, its labels are the app.kubernetes.io ones it sets itself.
+	if err := podmeta.Validate(s.PodAnnotations, nil, nil); err != nil {
+		errs = append(errs, err)
+	}
+	if s.Service != nil {
+		if err := apivalidation.ValidateAnnotations(s.Service.Annotations, field.NewPath("service", "annotations")).ToAggregate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if s.Alerts != nil {
+		if err := metav1validation.ValidateLabels(s.Alerts.Labels, field.NewPath("alerts", "labels")).ToAggregate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("spec is invalid: %v", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+type SecurityContext struct {
+	RunAsUser  *int64 `json:"runAsUser,omitempty" yaml:"runAsUser,omitempty" description:"The UID the App's containers run as. Defaults to 1000 and can't be 0." example:"65532"`
+	RunAsGroup *int64 `json:"runAsGroup,omitempty" yaml:"runAsGroup,omitempty" description:"The GID the App's containers run as. Defaults to 1000." example:"65532"`
+	FSGroup    *int64 `json:"fsGroup,omitempty" yaml:"fsGroup,omitempty" description:"The group that owns the App's volumes. Defaults to 1000." example:"65532"`
+
+	ReadOnlyRootFilesystem bool `json:"readOnlyRootFilesystem,omitempty" yaml:"readOnlyRootFilesystem,omitempty" description:"If true, mount the container's root filesystem read-only. /tmp gets an emptyDir, use scratch for other writable paths."`
+}
+
+func (s *SecurityContext) UnmarshalJSON(data []byte) error {
+	type SecurityContextAlt SecurityContext
+	if err := json.Unmarshal(data, (*SecurityContextAlt)(s)); err != nil {
+		return err
+	}
+	if s.RunAsUser != nil && *s.RunAsUser <= 0 {
+		return fmt.Errorf("securityContext.runAsUser must be a non-root UID, got %d", *s.RunAsUser)
+	}
+	if s.RunAsGroup != nil && *s.RunAsGroup < 0 {
+		return fmt.Errorf("securityContext.runAsGroup can't be negative, got %d", *s.RunAsGroup)
+	}
+	if s.FSGroup != nil && *s.FSGroup < 0 {
+		return fmt.Errorf("securityContext.fsGroup can't be negative, got %d", *s.FSGroup)
+	}
+	return nil
+}
+
+// TmpVolume reports if the App's pods get an emptyDir at /tmp. Most programs expect to be able to write there, so
+// Apps with a read-only root filesystem get one unless they mount something there themselves.
+func (s AppSpec) TmpVolume() bool {
+	if s.SecurityContext == nil || !s.SecurityContext.ReadOnlyRootFilesystem {
+		return false
+	}
+	for _, v := range s.Scratch {
+		if path.Clean(v.Path) == "/tmp" {
+			return false
+		}
+	}
+	for _, m := range s.ExtraVolumeMounts {
+		if path.Clean(m.MountPath) == "/tmp" {
+			return false
+		}
+	}
+	return true
+}
+
+type Resources struct {
+	Requests corev1.ResourceList `json:"requests,omitempty" yaml:"requests,omitempty" description:"The resources the App's containers are guaranteed, such as cpu and memory." example:"{\"cpu\": \"250m\", \"memory\": \"256Mi\"}"`
+	Limits   corev1.ResourceList `json:"limits,omitempty" yaml:"limits,omitempty" description:"The most of each resource the App's containers may use." example:"{\"memory\": \"512Mi\"}"`
+}
+
+// OpenAPISchema describes quantities the way Kubernetes does. The generated schema would treat resource.Quantity as
+// an empty object and reject "250m".
+func (*Resources) OpenAPISchema() *apiextv1.JSONSchemaProps {
+	quantities := apiextv1.JSONSchemaProps{
+		Type: "object",
+		AdditionalProperties: &apiextv1.JSONSchemaPropsOrBool{
+			Allows: true,
+			Schema: &apiextv1.JSONSchemaProps{
+				XIntOrString: true,
+				AnyOf: []apiextv1.JSONSchemaProps{
+					{Type: "integer"},
+					{Type: "string"},
+				},
+			},
+		},
+	}
+
+	return &apiextv1.JSONSchemaProps{
+		Type: "object",
+		Properties: apiextv1.JSONSchemaDefinitions{
+			"requests": quantities,
+			"limits":   quantities,
+		},
+	}
+}
+
+// violations reports requests that are more than their limits, and extended resources that aren't set as limits,
+// which the API server would reject.
+func (r Resources) violations() []error {
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(r.Requests)) {
+		request := r.Requests[name]
+		limit, ok := r.Limits[name]
+		switch {
+		case IsExtendedResource(name) && (!ok || request.Cmp(limit) != 0):
+			errs = append(errs, fmt.Errorf("resources.limits.%s has to be set and equal resources.requests.%s, extended resources can't be overcommitted", name, name))
+		case ok && request.Cmp(limit) > 0:
+			errs = append(errs, fmt.Errorf("resources.requests.%s (%s) can't be more than resources.limits.%s (%s)", name, request.String(), name, limit.String()))
+		}
+	}
+	return errs
+}
+
+type GPU struct {
+	Count        int64  `json:"count" yaml:"count" description:"The number of GPUs each pod gets." example:"1" Minimum:"1"`
+	ResourceName string `json:"resourceName,omitempty" yaml:"resourceName,omitempty" description:"The extended resource the GPUs are advertised as. Defaults to nvidia.com/gpu." example:"amd.com/gpu"`
+}
+
+func (g *GPU) UnmarshalJSON(data []byte) error {
+	type GPUAlt GPU
+	if err := json.Unmarshal(data, (*GPUAlt)(g)); err != nil {
+		return err
+	}
+	if g.Count < 1 {
+		return fmt.Errorf("gpu.count must be at least 1, got %d", g.Count)
+	}
+	if g.ResourceName == "" {
+		g.ResourceName = "nvidia.com/gpu"
+	}
+	if !IsExtendedResource(corev1.ResourceName(g.ResourceName)) {
+		return fmt.Errorf("gpu.resourceName must be an extended resource such as nvidia.com/gpu, got %q", g.ResourceName)
+	}
+	return nil
+}
+
+// IsExtendedResource reports if name is an extended resource, such as a GPU advertised by a device plugin. The
+// scheduler can't overcommit them, so they are only set as limits or with requests equal to their limits.
+func IsExtendedResource(name corev1.ResourceName) bool {
+	domain, _, ok := strings.Cut(string(name), "/")
+	return ok && domain != "kubernetes.io" && !strings.HasSuffix(domain, ".kubernetes.io")
+}
+
+// withGPU returns r with the GPUs set as both their request and their limit.
+func (r *Resources) withGPU(gpu GPU) *Resources {
+	result := &Resources{}
+	if r != nil {
+		result.Requests = maps.Clone(r.Requests)
+		result.Limits = maps.Clone(r.Limits)
+	}
+	if result.Requests == nil {
+		result.Requests = corev1.ResourceList{}
+	}
+	if result.Limits == nil {
+		result.Limits = corev1.ResourceList{}
+	}
+
+	count := *resource.NewQuantity(gpu.Count, resource.DecimalSI)
+	result.Requests[corev1.ResourceName(gpu.ResourceName)] = count
+	result.Limits[corev1.ResourceName(gpu.ResourceName)] = count
+	return result
+}
+
+// sizes are the presets for AppSpec.Size.
+var sizes = map[string]Resources{
+	"small": {
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m"), corev1.ResourceMemory: resource.MustParse("64Mi")},
+		Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m"), corev1.ResourceMemory: resource.MustParse("256Mi")},
+	},
+	"medium": {
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m"), corev1.ResourceMemory: resource.MustParse("256Mi")},
+		Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+	},
+	"large": {
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+		Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2"), corev1.ResourceMemory: resource.MustParse("4Gi")},
+	},
+}
+
+// sized returns the resources of the size preset with r's requests and limits on top.
+func (r *Resources) sized(size string) *Resources {
+	preset := sizes[size]
+	result := &Resources{
+		Requests: maps.Clone(preset.Requests),
+		Limits:   maps.Clone(preset.Limits),
+	}
+	if r != nil {
+		maps.Copy(result.Requests, r.Requests)
+		maps.Copy(result.Limits, r.Limits)
+	}
+	return result
+}
+
+type ResourcePolicy struct {
+	MaxLimitRequestRatio float64 `json:"maxLimitRequestRatio,omitempty" yaml:"maxLimitRequestRatio,omitempty" description:"If set, every limit can be at most this many times its request, and every request needs a limit." example:"2"`
+	RequireMemoryLimit   bool    `json:"requireMemoryLimit,omitempty" yaml:"requireMemoryLimit,omitempty" description:"If true, resources.limits.memory must be set."`
+	Quota                string  `json:"quota,omitempty" yaml:"quota,omitempty" description:"The ResourceQuota in the App's namespace to compare the App's requests against. Going over it is only a warning." example:"compute"`
+}
+
+func (p *ResourcePolicy) UnmarshalJSON(data []byte) error {
+	type ResourcePolicyAlt ResourcePolicy
+	if err := json.Unmarshal(data, (*ResourcePolicyAlt)(p)); err != nil {
+		return err
+	}
+	if p.MaxLimitRequestRatio != 0 && p.MaxLimitRequestRatio < 1 {
+		return fmt.Errorf("resourcePolicy.maxLimitRequestRatio must be at least 1, got %g", p.MaxLimitRequestRatio)
+	}
+	if p.Quota != "" {
+		if errs := validation.IsDNS1123Subdomain(p.Quota); len(errs) != 0 {
+			return fmt.Errorf("resourcePolicy.quota %q is not a valid name: %s", p.Quota, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// violations reports every way resources breaks the policy. A limit without a request is fine: Kubernetes
+// defaults the request to the limit.
+func (p ResourcePolicy) violations(resources *Resources) []error {
+	if resources == nil {
+		resources = &Resources{}
+	}
+
+	var errs []error
+	if _, ok := resources.Limits[corev1.ResourceMemory]; p.RequireMemoryLimit && !ok {
+		errs = append(errs, fmt.Errorf("resources.limits.memory is required by resourcePolicy.requireMemoryLimit"))
+	}
+
+	if p.MaxLimitRequestRatio == 0 {
+		return errs
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(resources.Requests)) {
+		request := resources.Requests[name]
+		limit, ok := resources.Limits[name]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("resources.limits.%s is required by resourcePolicy.maxLimitRequestRatio because resources.requests.%s is set", name, name))
+		case request.IsZero():
+			errs = append(errs, fmt.Errorf("resources.requests.%s can't be zero with resourcePolicy.maxLimitRequestRatio", name))
+		case limit.AsApproximateFloat64() > p.MaxLimitRequestRatio*request.AsApproximateFloat64():
+			errs = append(errs, fmt.Errorf("resources.limits.%s (%s) is more than %g× resources.requests.%s (%s)", name, limit.String(), p.MaxLimitRequestRatio, name, request.String()))
+		}
+	}
+	return errs
+}
+
+type AutoUpdate struct {
+	Enabled      bool   `json:"enabled" yaml:"enabled" description:"If true, automatically update the App with Keel."`
+	Policy       string `json:"policy,omitempty" yaml:"policy,omitempty" description:"Which new image tags Keel updates to: all (default), major, minor, patch, force, never, or a glob: or regexp: pattern." example:"patch"`
+	Trigger      string `json:"trigger,omitempty" yaml:"trigger,omitempty" description:"How Keel finds out about new images. Defaults to all." example:"poll"`
+	PollSchedule string `json:"pollSchedule,omitempty" yaml:"pollSchedule,omitempty" description:"How often Keel polls the registry for new images. Defaults to @hourly." example:"@every 10m"`
+}
+
+// OpenAPISchema allows both the bool and the object form in the CRD, which a structural schema can only do by
+// leaving the field untyped. UnmarshalJSON does the validation.
+func (*AutoUpdate) OpenAPISchema() *apiextv1.JSONSchemaProps {
+	return &apiextv1.JSONSchemaProps{XPreserveUnknownFields: ptr.To(true)}
+}
+
+func (a *AutoUpdate) UnmarshalJSON(data []byte) error {
+	var enabled bool
+	if err := json.Unmarshal(data, &enabled); err == nil {
+		*a = AutoUpdate{Enabled: enabled}
+	} else {
+		type AutoUpdateAlt AutoUpdate
+		if err := json.Unmarshal(data, (*AutoUpdateAlt)(a)); err != nil {
+			return fmt.Errorf("autoUpdate must be a bool or an object: %w", err)
+		}
+	}
+
+	a.Policy = cmp.Or(a.Policy, "all")
+	a.Trigger = cmp.Or(a.Trigger, "all")
+	a.PollSchedule = cmp.Or(a.PollSchedule, "@hourly")
+
+	// Keel ignores policies it doesn't know, which silently turns updates off.
+	switch {
+	case a.Policy == "all", a.Policy == "major", a.Policy == "minor", a.Policy == "patch", a.Policy == "force", a.Policy == "never":
+		// all is good
+	case strings.HasPrefix(a.Policy, "glob:") && len(a.Policy) > len("glob:"):
+		// all is good
+	case strings.HasPrefix(a.Policy, "regexp:"):
+		if _, err := regexp.Compile(strings.TrimPrefix(a.Policy, "regexp:")); err != nil {
+			return fmt.Errorf("autoUpdate: invalid policy %q: %w", a.Policy, err)
+		}
+	default:
+		return fmt.Errorf("autoUpdate: unknown policy %q, must be all, major, minor, patch, force, never, glob:<pattern>, or regexp:<pattern>", a.Policy)
+	}
+	if _, err := cron.ParseStandard(a.PollSchedule); err != nil {
+		return fmt.Errorf("autoUpdate: invalid pollSchedule %q: %w", a.PollSchedule, err)
+	}
+	return nil
+}
+
+type GracefulShutdown struct {
+	Enabled      bool  `json:"enabled" yaml:"enabled" description:"If true, sleep before the App gets SIGTERM so that Services and ingress controllers stop sending it requests first."`
+	DelaySeconds int32 `json:"delaySeconds,omitempty" yaml:"delaySeconds,omitempty" description:"How long to sleep before SIGTERM. Defaults to 5." example:"10"`
+}
+
+func (g *GracefulShutdown) UnmarshalJSON(data []byte) error {
+	type GracefulShutdownAlt GracefulShutdown
+	if err := json.Unmarshal(data, (*GracefulShutdownAlt)(g)); err != nil {
+		return err
+	}
+	if g.DelaySeconds < 0 {
+		return fmt.Errorf("gracefulShutdown.delaySeconds can't be negative, got %d", g.DelaySeconds)
+	}
+	g.DelaySeconds = cmp.Or(g.DelaySeconds, 5)
+	return nil
+}
 
-	// Resources *corev1.ResourceRequirements `json:"resources,omitempty" yaml:"resources,omitempty"`
+type Canary struct {
+	Enabled bool   `json:"enabled" yaml:"enabled" description:"If true, run a <app>-canary Deployment with one pod of image behind the App's Service."`
+	Image   string `json:"image,omitempty" yaml:"image,omitempty" description:"The image the canary runs. Required when the canary is enabled." example:"ghcr.io/xe/stickers:v1.3.0"`
+}
+
+func (c *Canary) UnmarshalJSON(data []byte) error {
+	type CanaryAlt Canary
+	if err := json.Unmarshal(data, (*CanaryAlt)(c)); err != nil {
+		return err
+	}
+	if c.Enabled && c.Image == "" {
+		return fmt.Errorf("canary.image is required when the canary is enabled")
+	}
+	return nil
+}
 
-	Healthcheck *Healthcheck `json:"healthcheck,omitempty" yaml:"healthcheck,omitempty"`
-	Ingress     *Ingress     `json:"ingress,omitempty" yaml:"ingress,omitempty"`
-	Onion       *Onion       `json:"onion,omitempty" yaml:"onion,omitempty"`
-	Storage     *Storage     `json:"storage,omitempty" yaml:"storage,omitempty"`
-	Role        *Role        `json:"role,omitempty" yaml:"role,omitempty"`
-	Anubis      *Anubis      `json:"anubis,omitempty" yaml:"anubis,omitempty"`
+type Strategy struct {
+	Type           string              `json:"type,omitempty" yaml:"type,omitempty" description:"How to replace old pods: RollingUpdate, or Recreate to stop every old pod first. Defaults to Recreate when the App has ReadWriteOnce storage and RollingUpdate otherwise." Enum:"RollingUpdate,Recreate"`
+	MaxSurge       *intstr.IntOrString `json:"maxSurge,omitempty" yaml:"maxSurge,omitempty" description:"How many pods above replicas a rolling update may create, as a number or a percentage. Defaults to 25%." example:"0"`
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty" yaml:"maxUnavailable,omitempty" description:"How many pods below replicas a rolling update may go, as a number or a percentage. Defaults to 25%." example:"1"`
+}
 
-	Volumes []Volume `json:"volumes,omitempty" yaml:"volumes,omitempty"`
+// OpenAPISchema describes both fields as int-or-string. The generated schema would treat intstr.IntOrString as an
+// object.
+func (*Strategy) OpenAPISchema() *apiextv1.JSONSchemaProps {
+	intOrString := apiextv1.JSONSchemaProps{
+		XIntOrString: true,
+		AnyOf: []apiextv1.JSONSchemaProps{
+			{Type: "integer"},
+			{Type: "string"},
+		},
+	}
 
-	Secrets    []Secret    `json:"secrets,omitempty" yaml:"secrets,omitempty"`
-	ConfigMaps []ConfigMap `json:"configMaps,omitempty" yaml:"configmaps,omitempty"`
+	return &apiextv1.JSONSchemaProps{
+		Type: "object",
+		Properties: apiextv1.JSONSchemaDefinitions{
+			"type": {
+				Type: "string",
+				Enum: []apiextv1.JSON{
+					{Raw: []byte(`"RollingUpdate"`)},
+					{Raw: []byte(`"Recreate"`)},
+				},
+			},
+			"maxSurge":       intOrString,
+			"maxUnavailable": intOrString,
+		},
+	}
+}
+
+func (s *Strategy) UnmarshalJSON(data []byte) error {
+	type StrategyAlt Strategy
+	if err := json.Unmarshal(data, (*StrategyAlt)(s)); err != nil {
+		return err
+	}
+
+	tuned := s.MaxSurge != nil || s.MaxUnavailable != nil
+	switch s.Type {
+	case "":
+		if !tuned {
+			return nil
+		}
+		// Tuning the rolling update asks for one.
+		s.Type = "RollingUpdate"
+	case "RollingUpdate":
+		// all is good
+	case "Recreate":
+		if tuned {
+			return fmt.Errorf("strategy.maxSurge and strategy.maxUnavailable can only be set with type RollingUpdate")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown strategy.type %q, must be RollingUpdate or Recreate", s.Type)
+	}
+
+	// Kubernetes' default for fields that aren't set.
+	defaultValue := intstr.FromString("25%")
+
+	// Percentages are checked against 100 pods, which is enough to tell zero from anything else.
+	maxSurge, err := intstr.GetScaledValueFromIntOrPercent(cmp.Or(s.MaxSurge, &defaultValue), 100, true)
+	if err != nil || maxSurge < 0 {
+		return fmt.Errorf("strategy.maxSurge must be a non-negative number or percentage, got %s", s.MaxSurge)
+	}
+	maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(cmp.Or(s.MaxUnavailable, &defaultValue), 100, false)
+	if err != nil || maxUnavailable < 0 {
+		return fmt.Errorf("strategy.maxUnavailable must be a non-negative number or percentage, got %s", s.MaxUnavailable)
+	}
+	if maxSurge == 0 && maxUnavailable == 0 {
+		return fmt.Errorf("strategy.maxSurge and strategy.maxUnavailable can't both be zero, the rollout could never make progress")
+	}
+	return nil
 }
 
 type Healthcheck struct {
-	Enabled bool   `json:"enabled" yaml:"enabled"`
-	Path    string `json:"path,omitempty" yaml:"path,omitempty"`
-	Port    int    `json:"port,omitempty" yaml:"port,omitempty"`
-	Kind    string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	Enabled bool              `json:"enabled" yaml:"enabled" description:"If true, configure health checking for this App."`
+	Path    string            `json:"path,omitempty" yaml:"path,omitempty" description:"The HTTP path to check. Defaults to /."`
+	Port    int               `json:"port,omitempty" yaml:"port,omitempty" description:"The port to check. Defaults to the App port."`
+	Kind    string            `json:"kind,omitempty" yaml:"kind,omitempty" description:"The kind of health check: http (default) or grpc."`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty" description:"The HTTP headers the probes send, such as Host or Authorization. Defaults to X-Kubernetes: is kinda okay." example:"{\"Host\": \"stickers.within.website\"}"`
 }
 
 func (h *Healthcheck) UnmarshalJSON(data []byte) error {
@@ -72,17 +622,166 @@ func (h *Healthcheck) UnmarshalJSON(data []byte) error {
 	default:
 		return fmt.Errorf("Healthcheck: unknown kind %q", h.Kind)
 	}
+	if len(h.Headers) != 0 && h.Kind != "http" {
+		return fmt.Errorf("Healthcheck: headers only work with http health checks")
+	}
+	for _, name := range slices.Sorted(maps.Keys(h.Headers)) {
+		if errs := validation.IsHTTPHeaderName(name); len(errs) != 0 {
+			return fmt.Errorf("Healthcheck: invalid header name %q: %s", name, strings.Join(errs, ", "))
+		}
+	}
 	return nil
 }
 
 type Ingress struct {
-	Enabled         bool              `json:"enabled" yaml:"enabled"`
-	Kind            string            `json:"kind,omitempty" yaml:"kind,omitempty"`
-	Host            string            `json:"host" yaml:"host"`
-	ClusterIssuer   string            `json:"clusterIssuer,omitempty" yaml:"clusterIssuer,omitempty"`
-	ClassName       string            `json:"className,omitempty" yaml:"className,omitempty"`
-	EnableCoreRules bool              `json:"enableCoreRules,omitempty" yaml:"enableCoreRules,omitempty"`
-	Annotations     map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	Enabled         bool              `json:"enabled" yaml:"enabled" description:"If true, create an HTTP Ingress for this App."`
+	Kind            string            `json:"kind,omitempty" yaml:"kind,omitempty" description:"The kind of traffic the App serves, set to grpc for gRPC backends."`
+	Host            string            `json:"host" yaml:"host" description:"The HTTP hostname for the Ingress." example:"stickers.within.website"`
+	TLS             *bool             `json:"tls,omitempty" yaml:"tls,omitempty" description:"If false, serve the App over plain HTTP without a certificate, for clusters without cert-manager. Defaults to true."`
+	TLSSecretName   string            `json:"tlsSecretName,omitempty" yaml:"tlsSecretName,omitempty" description:"If set, use this existing certificate Secret, such as a shared wildcard certificate, instead of having cert-manager issue one." example:"within-website-wildcard-tls"`
+	ClusterIssuer   string            `json:"clusterIssuer,omitempty" yaml:"clusterIssuer,omitempty" description:"The cert-manager ClusterIssuer for the certificate. Defaults to letsencrypt-prod. Can't be used with tls: false or tlsSecretName."`
+	ClassName       string            `json:"className,omitempty" yaml:"className,omitempty" description:"The ingress class the Ingress should use. Defaults to nginx."`
+	EnableCoreRules bool              `json:"enableCoreRules,omitempty" yaml:"enableCoreRules,omitempty" description:"If true, enable ModSecurity with the OWASP core rule set."`
+	Annotations     map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty" description:"Additional annotations added to the Ingress."`
+	Gateway         *GatewayRef       `json:"gateway,omitempty" yaml:"gateway,omitempty" description:"If set, attach an HTTPRoute (or a GRPCRoute for gRPC Apps) to this Gateway API Gateway instead of creating an Ingress."`
+	Allowlist       []string          `json:"allowlist,omitempty" yaml:"allowlist,omitempty" description:"If set, only these CIDRs can reach the App, everyone else is refused. Only supported with ingress-nginx and Traefik." example:"[\"10.0.0.0/8\", \"192.168.1.0/24\"]"`
+	BasicAuth       *BasicAuth        `json:"basicAuth,omitempty" yaml:"basicAuth,omitempty" description:"If set, require HTTP basic auth for the App. Only supported with ingress-nginx."`
+	RateLimit       *RateLimit        `json:"rateLimit,omitempty" yaml:"rateLimit,omitempty" description:"If set, limit how fast each client can make requests. Only supported with ingress-nginx."`
+	Paths           []IngressPath     `json:"paths,omitempty" yaml:"paths,omitempty" description:"The paths the Ingress routes. Defaults to sending everything under / to the App."`
+	DefaultBackend  *IngressBackend   `json:"defaultBackend,omitempty" yaml:"defaultBackend,omitempty" description:"If set, where the Ingress sends requests that don't match any of its hosts and paths."`
+	RedirectFrom    []string          `json:"redirectFrom,omitempty" yaml:"redirectFrom,omitempty" description:"Hostnames that are permanently redirected to the Ingress host, such as the www subdomain. Only supported with ingress-nginx." example:"[\"www.within.website\"]"`
+}
+
+type IngressPath struct {
+	Path     string `json:"path" yaml:"path" description:"The path to match. Must start with /." example:"/.well-known/matrix/server"`
+	PathType string `json:"pathType,omitempty" yaml:"pathType,omitempty" description:"How the path is matched. Defaults to Prefix." Enum:"Prefix,Exact,ImplementationSpecific"`
+	Service  string `json:"service,omitempty" yaml:"service,omitempty" description:"The Service in the App's namespace to send these requests to. Defaults to the App's own Service." example:"synapse"`
+	Port     int32  `json:"port,omitempty" yaml:"port,omitempty" description:"The port of service to send these requests to. Required with service." example:"8008" Minimum:"0"`
+}
+
+func (p *IngressPath) UnmarshalJSON(data []byte) error {
+	type IngressPathAlt IngressPath
+	if err := json.Unmarshal(data, (*IngressPathAlt)(p)); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(p.Path, "/") {
+		return fmt.Errorf("ingress.paths: path %q must start with /", p.Path)
+	}
+	switch p.PathType {
+	case "":
+		p.PathType = "Prefix"
+	case "Prefix", "Exact", "ImplementationSpecific":
+		// all is good
+	default:
+		return fmt.Errorf("ingress.paths: unknown pathType %q for %s, must be one of Prefix, Exact, or ImplementationSpecific", p.PathType, p.Path)
+	}
+	if err := validateBackend(p.Service, p.Port, false); err != nil {
+		return fmt.Errorf("ingress.paths: %s: %w", p.Path, err)
+	}
+	return nil
+}
+
+type IngressBackend struct {
+	Service string `json:"service" yaml:"service" description:"The Service in the App's namespace to send the requests to." example:"default-http-backend"`
+	Port    int32  `json:"port" yaml:"port" description:"The port of service to send the requests to." example:"80" Minimum:"1"`
+}
+
+func (b *IngressBackend) UnmarshalJSON(data []byte) error {
+	type IngressBackendAlt IngressBackend
+	if err := json.Unmarshal(data, (*IngressBackendAlt)(b)); err != nil {
+		return err
+	}
+	if err := validateBackend(b.Service, b.Port, true); err != nil {
+		return fmt.Errorf("ingress.defaultBackend: %w", err)
+	}
+	return nil
+}
+
+// validateBackend checks the Service and port an Ingress sends requests to. Without a Service the requests go to the
+// App's own Service, unless the Service is required.
+func validateBackend(service string, port int32, required bool) error {
+	if service == "" {
+		if required {
+			return fmt.Errorf("service is required")
+		}
+		if port != 0 {
+			return fmt.Errorf("port needs service")
+		}
+		return nil
+	}
+	if errs := validation.IsDNS1035Label(service); len(errs) != 0 {
+		return fmt.Errorf("invalid service %q: %s", service, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsValidPortNum(int(port)); len(errs) != 0 {
+		return fmt.Errorf("invalid port %d for service %s: %s", port, service, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+type RateLimit struct {
+	RequestsPerSecond int `json:"requestsPerSecond,omitempty" yaml:"requestsPerSecond,omitempty" description:"The number of requests per second each client IP can make." example:"10" Minimum:"0"`
+	BurstMultiplier   int `json:"burstMultiplier,omitempty" yaml:"burstMultiplier,omitempty" description:"How many times requestsPerSecond a client can burst to. Defaults to ingress-nginx's default of 5." example:"3" Minimum:"0"`
+	Connections       int `json:"connections,omitempty" yaml:"connections,omitempty" description:"The number of concurrent connections each client IP can have open." example:"20" Minimum:"0"`
+}
+
+func (r *RateLimit) UnmarshalJSON(data []byte) error {
+	type RateLimitAlt RateLimit
+	if err := json.Unmarshal(data, (*RateLimitAlt)(r)); err != nil {
+		return err
+	}
+	if r.RequestsPerSecond < 0 || r.BurstMultiplier < 0 || r.Connections < 0 {
+		return fmt.Errorf("ingress.rateLimit: limits can't be negative")
+	}
+	if r.BurstMultiplier != 0 && r.RequestsPerSecond == 0 {
+		return fmt.Errorf("ingress.rateLimit: burstMultiplier needs requestsPerSecond")
+	}
+	return nil
+}
+
+type BasicAuth struct {
+	ItemPath string `json:"itemPath" yaml:"itemPath" description:"The 1Password item path of the htpasswd file. The item needs an auth field with one user:hash line per user." example:"vaults/Kubernetes/items/staging-htpasswd"`
+	Realm    string `json:"realm,omitempty" yaml:"realm,omitempty" description:"The realm shown in the browser's login prompt. Defaults to Authentication Required."`
+}
+
+func (b *BasicAuth) UnmarshalJSON(data []byte) error {
+	type BasicAuthAlt BasicAuth
+	if err := json.Unmarshal(data, (*BasicAuthAlt)(b)); err != nil {
+		return err
+	}
+	if b.ItemPath == "" {
+		return fmt.Errorf("ingress.basicAuth: itemPath is required")
+	}
+	if b.Realm == "" {
+		b.Realm = "Authentication Required"
+	}
+	return nil
+}
+
+type GatewayRef struct {
+	Name        string `json:"name" yaml:"name" description:"The name of the Gateway." example:"public"`
+	Namespace   string `json:"namespace,omitempty" yaml:"namespace,omitempty" description:"The namespace of the Gateway. Defaults to the App's namespace." example:"gateway-system"`
+	SectionName string `json:"sectionName,omitempty" yaml:"sectionName,omitempty" description:"If set, only attach to this listener of the Gateway." example:"https"`
+}
+
+func (g *GatewayRef) UnmarshalJSON(data []byte) error {
+	type GatewayRefAlt GatewayRef
+	if err := json.Unmarshal(data, (*GatewayRefAlt)(g)); err != nil {
+		return err
+	}
+	if errs := validation.IsDNS1123Subdomain(g.Name); len(errs) != 0 {
+		return fmt.Errorf("ingress.gateway: invalid name %q: %s", g.Name, strings.Join(errs, ", "))
+	}
+	if g.Namespace != "" {
+		if errs := validation.IsDNS1123Label(g.Namespace); len(errs) != 0 {
+			return fmt.Errorf("ingress.gateway: invalid namespace %q: %s", g.Namespace, strings.Join(errs, ", "))
+		}
+	}
+	if g.SectionName != "" {
+		if errs := validation.IsDNS1123Subdomain(g.SectionName); len(errs) != 0 {
+			return fmt.Errorf("ingress.gateway: invalid sectionName %q: %s", g.SectionName, strings.Join(errs, ", "))
+		}
+	}
+	return nil
 }
 
 func (i *Ingress) UnmarshalJSON(data []byte) error {
@@ -93,20 +792,136 @@ func (i *Ingress) UnmarshalJSON(data []byte) error {
 	if i.Enabled && i.Host == "" {
 		return fmt.Errorf("host is required when ingress is enabled")
 	}
-	if i.Enabled && i.ClusterIssuer == "" {
+	switch {
+	case i.TLS != nil && !*i.TLS:
+		if i.ClusterIssuer != "" {
+			return fmt.Errorf("ingress.clusterIssuer can't be used with tls: false")
+		}
+		if i.TLSSecretName != "" {
+			return fmt.Errorf("ingress.tlsSecretName can't be used with tls: false")
+		}
+	case i.TLSSecretName != "":
+		if errs := validation.IsDNS1123Subdomain(i.TLSSecretName); len(errs) != 0 {
+			return fmt.Errorf("ingress: invalid tlsSecretName %q: %s", i.TLSSecretName, strings.Join(errs, ", "))
+		}
+		// The certificate comes from the Secret, so there is nothing for cert-manager to issue.
+		if i.ClusterIssuer != "" {
+			return fmt.Errorf("ingress.clusterIssuer can't be used with tlsSecretName")
+		}
+	case i.Enabled && i.ClusterIssuer == "":
 		i.ClusterIssuer = "letsencrypt-prod"
 	}
+	for _, cidr := range i.Allowlist {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("ingress.allowlist: invalid CIDR %q: %w", cidr, err)
+		}
+	}
+	for idx, host := range i.RedirectFrom {
+		if errs := validation.IsDNS1123Subdomain(host); len(errs) != 0 {
+			return fmt.Errorf("ingress.redirectFrom: invalid host %q: %s", host, strings.Join(errs, ", "))
+		}
+		if host == i.Host || slices.Contains(i.RedirectFrom[:idx], host) {
+			return fmt.Errorf("ingress.redirectFrom: host %s is listed more than once", host)
+		}
+	}
+	seen := map[string]bool{}
+	for _, p := range i.Paths {
+		key := p.PathType + " " + p.Path
+		if seen[key] {
+			return fmt.Errorf("ingress.paths: %s path %s is listed more than once", p.PathType, p.Path)
+		}
+		seen[key] = true
+	}
 	if i.Enabled && i.ClassName == "" {
 		i.ClassName = "nginx"
 	}
 	return nil
 }
 
+type ExternalDNS struct {
+	Enabled  bool   `json:"enabled" yaml:"enabled" description:"If true, have external-dns create DNS records for the App."`
+	Hostname string `json:"hostname,omitempty" yaml:"hostname,omitempty" description:"The hostname to publish. Defaults to ingress.host and the hosts in ingress.redirectFrom, and is required without an Ingress." example:"stickers.within.website"`
+	TTL      int64  `json:"ttl,omitempty" yaml:"ttl,omitempty" description:"The TTL of the DNS records in seconds. Defaults to external-dns's own default." example:"300"`
+	Target   string `json:"target,omitempty" yaml:"target,omitempty" description:"If set, what the records point to instead of the load balancer's address, such as a CNAME target or a comma-separated list of IPs." example:"ingress.within.website"`
+}
+
+func (d *ExternalDNS) UnmarshalJSON(data []byte) error {
+	type ExternalDNSAlt ExternalDNS
+	if err := json.Unmarshal(data, (*ExternalDNSAlt)(d)); err != nil {
+		return err
+	}
+	if d.Hostname != "" {
+		if errs := validation.IsDNS1123Subdomain(d.Hostname); len(errs) != 0 {
+			return fmt.Errorf("dns: invalid hostname %q: %s", d.Hostname, strings.Join(errs, ", "))
+		}
+	}
+	if d.TTL < 0 {
+		return fmt.Errorf("dns.ttl can't be negative, got %d", d.TTL)
+	}
+	return nil
+}
+
+type Service struct {
+	Type                string            `json:"type,omitempty" yaml:"type,omitempty" description:"The Service type: ClusterIP (default), NodePort, or LoadBalancer."`
+	NodePort            int32             `json:"nodePort,omitempty" yaml:"nodePort,omitempty" description:"The node port for the HTTP port. Only valid when type is NodePort."`
+	Annotations         map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty" description:"Additional annotations added to the Service. They replace the ones the App sets with the same keys."`
+	Headless            bool              `json:"headless,omitempty" yaml:"headless,omitempty" description:"If true, the App's Service is headless (clusterIP: None)."`
+	AlsoHeadless        bool              `json:"alsoHeadless,omitempty" yaml:"alsoHeadless,omitempty" description:"If true, create an additional <name>-headless Service next to the regular one."`
+	TrafficDistribution string            `json:"trafficDistribution,omitempty" yaml:"trafficDistribution,omitempty" description:"How traffic to the Service is distributed. PreferClose routes to pods in the same zone when possible." Enum:"PreferClose"`
+	TopologyAwareHints  bool              `json:"topologyAwareHints,omitempty" yaml:"topologyAwareHints,omitempty" description:"If true, enable topology aware routing with the service.kubernetes.io/topology-mode annotation, for clusters that predate trafficDistribution."`
+}
+
+func (s *Service) UnmarshalJSON(data []byte) error {
+	type ServiceAlt Service
+	if err := json.Unmarshal(data, (*ServiceAlt)(s)); err != nil {
+		return err
+	}
+	switch s.Type {
+	case "":
+		s.Type = "ClusterIP"
+	case "ClusterIP", "NodePort", "LoadBalancer":
+		// all is good
+	default:
+		return fmt.Errorf("Service: unknown type %q", s.Type)
+	}
+	if s.NodePort != 0 {
+		if s.Type != "NodePort" {
+			return fmt.Errorf("nodePort can only be set when type is NodePort")
+		}
+		if s.NodePort < 30000 || s.NodePort > 32767 {
+			return fmt.Errorf("nodePort must be between 30000 and 32767, got %d", s.NodePort)
+		}
+	}
+	if s.Headless && s.Type != "ClusterIP" {
+		return fmt.Errorf("headless services must be of type ClusterIP")
+	}
+	if s.Headless && s.AlsoHeadless {
+		return fmt.Errorf("cannot set headless and alsoHeadless at the same time")
+	}
+	switch s.TrafficDistribution {
+	case "", corev1.ServiceTrafficDistributionPreferClose:
+		// all is good
+	default:
+		return fmt.Errorf("Service: unknown trafficDistribution %q, must be PreferClose", s.TrafficDistribution)
+	}
+	if s.TrafficDistribution != "" && s.TopologyAwareHints {
+		// The annotation takes precedence over the field, so setting both is never what was meant.
+		return fmt.Errorf("cannot set trafficDistribution and topologyAwareHints at the same time")
+	}
+	return nil
+}
+
 type Secret struct {
-	Name        string `json:"name" yaml:"name"`
-	ItemPath    string `json:"itemPath" yaml:"itemPath"`
-	Environment bool   `json:"environment,omitempty" yaml:"environment,omitempty"` // If true, set the contents of the secret as an environment variable.
-	Folder      bool   `json:"folder,omitempty" yaml:"folder,omitempty"`           // If true, set each value in the secret as a file in a folder.
+	Name        string            `json:"name" yaml:"name" description:"The name of the secret in Kubernetes with the App name prepended."`
+	ItemPath    string            `json:"itemPath" yaml:"itemPath" description:"The 1Password item path of the secret data."`
+	Environment bool              `json:"environment,omitempty" yaml:"environment,omitempty" description:"If true, set the contents of the secret as environment variables."`
+	Folder      bool              `json:"folder,omitempty" yaml:"folder,omitempty" description:"If true, mount each value in the secret as a file in mountPath."`
+	MountPath   string            `json:"mountPath,omitempty" yaml:"mountPath,omitempty" description:"Where to mount the secret when folder is true. Defaults to /run/secrets/<name>." example:"/etc/myapp/certs"`
+	Items       []SecretItem      `json:"items,omitempty" yaml:"items,omitempty" description:"The keys of the secret to mount when folder is true, and the files to mount them as. Defaults to every key, each in a file named after it."`
+	EnvPrefix   string            `json:"envPrefix,omitempty" yaml:"envPrefix,omitempty" description:"A prefix added to every environment variable from this secret. Only valid with environment." example:"TIGRIS_"`
+	EnvMap      map[string]string `json:"envMap,omitempty" yaml:"envMap,omitempty" description:"Environment variables to set from individual keys of the secret, as a map of variable name to secret key. Cannot be used with environment." example:"{\"DATABASE_URL\": \"connection-string\"}"`
+	Optional    bool              `json:"optional,omitempty" yaml:"optional,omitempty" description:"If true, pods start even if the secret hasn't been synced from 1Password yet, instead of waiting for it. They only pick the values up when they restart, and until then run without them, so only use this when the App copes with them missing."`
+	Type        string            `json:"type,omitempty" yaml:"type,omitempty" description:"The kind of secret: opaque (default) or docker-registry. docker-registry secrets are added to the App's imagePullSecrets." Enum:"opaque,docker-registry"`
 }
 
 func (s *Secret) UnmarshalJSON(data []byte) error {
@@ -120,14 +935,233 @@ func (s *Secret) UnmarshalJSON(data []byte) error {
 	if s.Environment && s.Folder {
 		return fmt.Errorf("cannot set environment and folder at the same time")
 	}
+	if s.Folder {
+		// The name is also the name of the secret's volume.
+		if errs := validation.IsDNS1123Label(s.Name); len(errs) != 0 {
+			return fmt.Errorf("invalid folder secret name %q: %s", s.Name, strings.Join(errs, ", "))
+		}
+		if s.MountPath == "" {
+			s.MountPath = "/run/secrets/" + s.Name
+		}
+		if !path.IsAbs(s.MountPath) {
+			return fmt.Errorf("mountPath of secret %s must be an absolute path, got %q", s.Name, s.MountPath)
+		}
+		s.MountPath = path.Clean(s.MountPath)
+		paths := map[string]bool{}
+		for _, item := range s.Items {
+			if paths[item.Path] {
+				return fmt.Errorf("secret %s mounts more than one key at %s", s.Name, item.Path)
+			}
+			paths[item.Path] = true
+		}
+	} else {
+		if s.MountPath != "" {
+			return fmt.Errorf("mountPath of secret %s can only be used with folder", s.Name)
+		}
+		if len(s.Items) != 0 {
+			return fmt.Errorf("items of secret %s can only be used with folder", s.Name)
+		}
+	}
+	switch s.Type {
+	case "":
+		s.Type = "opaque"
+	case "opaque":
+		// all is good
+	case "docker-registry":
+		if s.Environment || s.Folder || len(s.EnvMap) != 0 {
+			return fmt.Errorf("docker-registry secrets can't be used with environment, folder, or envMap")
+		}
+		// Image pull secrets can't be optional, the kubelet just pulls without the missing ones.
+		if s.Optional {
+			return fmt.Errorf("docker-registry secrets can't be optional")
+		}
+	default:
+		return fmt.Errorf("unknown secret type %q, must be one of opaque or docker-registry", s.Type)
+	}
+	if s.Environment && len(s.EnvMap) != 0 {
+		return fmt.Errorf("cannot set environment and envMap at the same time")
+	}
+	for envName, key := range s.EnvMap {
+		if errs := validation.IsEnvVarName(envName); len(errs) != 0 {
+			return fmt.Errorf("invalid envMap variable name %q: %s", envName, strings.Join(errs, ", "))
+		}
+		if key == "" {
+			return fmt.Errorf("envMap variable %s must name a secret key", envName)
+		}
+	}
+	if s.EnvPrefix != "" {
+		if !s.Environment {
+			return fmt.Errorf("envPrefix can only be set when environment is true")
+		}
+		if errs := validation.IsEnvVarName(s.EnvPrefix); len(errs) != 0 {
+			return fmt.Errorf("invalid envPrefix %q: %s", s.EnvPrefix, strings.Join(errs, ", "))
+		}
+	}
 	return nil
 }
 
 type Onion struct {
-	Enabled            bool `json:"enabled" yaml:"enabled"`
-	NonAnonymous       bool `json:"nonAnonymous,omitempty" yaml:"nonAnonymous,omitempty"`
-	Haproxy            bool `json:"haproxy,omitempty" yaml:"haproxy,omitempty"`
-	ProofOfWorkDefense bool `json:"proofOfWorkDefense,omitempty" yaml:"proofOfWorkDefense,omitempty"`
+	Enabled               bool  `json:"enabled" yaml:"enabled" description:"If true, create an OnionService pointing to the App."`
+	Version               int32 `json:"version,omitempty" yaml:"version,omitempty" description:"The onion service protocol version. Only version 3 is supported."`
+	NonAnonymous          bool  `json:"nonAnonymous,omitempty" yaml:"nonAnonymous,omitempty" description:"If true, set up a single hop non-anonymous hidden service. This is an opsec risk."`
+	Haproxy               bool  `json:"haproxy,omitempty" yaml:"haproxy,omitempty" description:"If true, annotate requests with the HAProxy PROXY protocol to identify tor circuits."`
+	MaxStreams            int   `json:"maxStreams,omitempty" yaml:"maxStreams,omitempty" description:"The maximum number of simultaneous streams per rendezvous circuit."`
+	NumIntroductionPoints int   `json:"numIntroductionPoints,omitempty" yaml:"numIntroductionPoints,omitempty" description:"The number of introduction points the onion service publishes."`
+
+	ProofOfWorkDefense *ProofOfWorkDefense `json:"proofOfWorkDefense,omitempty" yaml:"proofOfWorkDefense,omitempty" description:"Settings for requiring clients to pass a proof of work challenge before they can connect. A bare true or false sets enabled."`
+
+	Rules []OnionRule `json:"rules,omitempty" yaml:"rules,omitempty" description:"The ports the onion service publishes. Defaults to publishing port 80 as the App's HTTP port."`
+
+	PrivateKeySecret *OnionPrivateKeySecret `json:"privateKeySecret,omitempty" yaml:"privateKeySecret,omitempty" description:"An existing Secret with the onion service's private key, so the .onion address stays the same when the OnionService is recreated."`
+}
+
+// ProofOfWorkDefense configures tor's proof of work defense against introduction floods. It used to be a bool, so
+// a bare true or false is still accepted and sets Enabled.
+type ProofOfWorkDefense struct {
+	Enabled    bool  `json:"enabled" yaml:"enabled" description:"If true, require clients to pass a proof of work challenge before they can connect."`
+	QueueRate  int32 `json:"queueRate,omitempty" yaml:"queueRate,omitempty" description:"The number of queued introduction requests handled per second (HiddenServicePoWQueueRate). Defaults to 1." example:"250"`
+	QueueBurst int32 `json:"queueBurst,omitempty" yaml:"queueBurst,omitempty" description:"The number of queued introduction requests that can be handled in a burst (HiddenServicePoWQueueBurst). Defaults to 10." example:"2500"`
+}
+
+// OpenAPISchema allows both the bool and the object form in the CRD, which a structural schema can only do by
+// leaving the field untyped. UnmarshalJSON does the validation.
+func (*ProofOfWorkDefense) OpenAPISchema() *apiextv1.JSONSchemaProps {
+	return &apiextv1.JSONSchemaProps{XPreserveUnknownFields: ptr.To(true)}
+}
+
+func (p *ProofOfWorkDefense) UnmarshalJSON(data []byte) error {
+	var enabled bool
+	if err := json.Unmarshal(data, &enabled); err == nil {
+		*p = ProofOfWorkDefense{Enabled: enabled}
+		return nil
+	}
+
+	type ProofOfWorkDefenseAlt ProofOfWorkDefense
+	if err := json.Unmarshal(data, (*ProofOfWorkDefenseAlt)(p)); err != nil {
+		return fmt.Errorf("Onion: proofOfWorkDefense must be a bool or an object: %w", err)
+	}
+	if p.QueueRate < 0 {
+		return fmt.Errorf("Onion: proofOfWorkDefense.queueRate can't be negative, got %d", p.QueueRate)
+	}
+	if p.QueueBurst < 0 {
+		return fmt.Errorf("Onion: proofOfWorkDefense.queueBurst can't be negative, got %d", p.QueueBurst)
+	}
+	return nil
+}
+
+type OnionPrivateKeySecret struct {
+	Name string `json:"name" yaml:"name" description:"The name of the Secret in the App's namespace." example:"mi-onion-key"`
+	Key  string `json:"key,omitempty" yaml:"key,omitempty" description:"The key in the Secret that holds the raw hs_ed25519_secret_key file. If unset, the Secret must have the privateKeyFile, publicKeyFile, and onionAddress keys the tor controller generates." example:"hs_ed25519_secret_key"`
+}
+
+func (s *OnionPrivateKeySecret) UnmarshalJSON(data []byte) error {
+	type OnionPrivateKeySecretAlt OnionPrivateKeySecret
+	if err := json.Unmarshal(data, (*OnionPrivateKeySecretAlt)(s)); err != nil {
+		return err
+	}
+	if s.Name == "" {
+		return fmt.Errorf("Onion: privateKeySecret needs a name")
+	}
+	if errs := validation.IsDNS1123Subdomain(s.Name); len(errs) > 0 {
+		return fmt.Errorf("Onion: invalid privateKeySecret name %q: %s", s.Name, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+type Tailscale struct {
+	AuthKeySecret string `json:"authKeySecret" yaml:"authKeySecret" description:"The name of the App secret, from secrets, with the tailnet auth key in its authkey field." example:"tailscale"`
+	Hostname      string `json:"hostname,omitempty" yaml:"hostname,omitempty" description:"The machine name of the App on the tailnet. Defaults to the App name." example:"grafana"`
+	State         string `json:"state,omitempty" yaml:"state,omitempty" description:"Where the sidecar keeps its tailnet state: emptyDir (default), which joins the tailnet again whenever a pod starts, or storage, a tailscale folder on the App's storage volume." Enum:"emptyDir,storage"`
+	Image         string `json:"image,omitempty" yaml:"image,omitempty" description:"The image of the Tailscale sidecar. Defaults to ghcr.io/tailscale/tailscale:stable." example:"ghcr.io/tailscale/tailscale:v1.80.3"`
+}
+
+func (t *Tailscale) UnmarshalJSON(data []byte) error {
+	type TailscaleAlt Tailscale
+	if err := json.Unmarshal(data, (*TailscaleAlt)(t)); err != nil {
+		return err
+	}
+	if t.AuthKeySecret == "" {
+		return fmt.Errorf("tailscale.authKeySecret is required")
+	}
+	if t.Hostname != "" {
+		if errs := validation.IsDNS1123Label(t.Hostname); len(errs) != 0 {
+			return fmt.Errorf("tailscale: invalid hostname %q: %s", t.Hostname, strings.Join(errs, ", "))
+		}
+	}
+	switch t.State {
+	case "":
+		t.State = "emptyDir"
+	case "emptyDir", "storage":
+		// all is good
+	default:
+		return fmt.Errorf("tailscale: unknown state %q, must be emptyDir or storage", t.State)
+	}
+	if t.Image == "" {
+		t.Image = "ghcr.io/tailscale/tailscale:stable"
+	}
+	return nil
+}
+
+// checkTailscale makes sure the Tailscale sidecar has what it needs. The auth key comes from one of the App's
+// secrets, so it is synced from 1Password like any other.
+func (s AppSpec) checkTailscale() error {
+	switch s.Expose {
+	case "", "ingress":
+		if s.Tailscale != nil {
+			return fmt.Errorf("tailscale needs expose: tailscale")
+		}
+		return nil
+	case "tailscale":
+		// checked below
+	default:
+		return fmt.Errorf("unknown expose %q, must be ingress or tailscale", s.Expose)
+	}
+	if s.Tailscale == nil {
+		return fmt.Errorf("expose: tailscale needs tailscale.authKeySecret")
+	}
+	if !slices.ContainsFunc(s.Secrets, func(sec Secret) bool { return sec.Name == s.Tailscale.AuthKeySecret }) {
+		return fmt.Errorf("tailscale.authKeySecret %s is not one of the App's secrets", s.Tailscale.AuthKeySecret)
+	}
+	if s.Tailscale.State == "storage" && (s.Storage == nil || !s.Storage.Enabled) {
+		return fmt.Errorf("tailscale.state storage needs storage to be enabled")
+	}
+	if s.Service != nil && s.Service.Type == "LoadBalancer" {
+		return fmt.Errorf("service.type LoadBalancer exposes the App directly, it cannot be combined with expose: tailscale")
+	}
+	for _, cm := range s.ConfigMaps {
+		// The serve config of the sidecar is the ConfigMap tailscale.
+		if cm.Name == "tailscale" {
+			return fmt.Errorf("configMap tailscale conflicts with the serve config of expose: tailscale")
+		}
+	}
+	return nil
+}
+
+type OnionRule struct {
+	PublicPort     int32  `json:"publicPort" yaml:"publicPort" description:"The port published on the onion address." example:"22"`
+	TargetPort     int32  `json:"targetPort,omitempty" yaml:"targetPort,omitempty" description:"The container port to send traffic to. Cannot be used with targetPortName." example:"2222"`
+	TargetPortName string `json:"targetPortName,omitempty" yaml:"targetPortName,omitempty" description:"The name of the App's Service port to send traffic to. Cannot be used with targetPort." Enum:"http"`
+}
+
+func (r *OnionRule) UnmarshalJSON(data []byte) error {
+	type OnionRuleAlt OnionRule
+	if err := json.Unmarshal(data, (*OnionRuleAlt)(r)); err != nil {
+		return err
+	}
+	if r.PublicPort < 1 || r.PublicPort > 65535 {
+		return fmt.Errorf("Onion: publicPort must be between 1 and 65535, got %d", r.PublicPort)
+	}
+	switch {
+	case r.TargetPort != 0 && r.TargetPortName != "":
+		return fmt.Errorf("Onion: rule for port %d can't set both targetPort and targetPortName", r.PublicPort)
+	case r.TargetPort == 0 && r.TargetPortName == "":
+		return fmt.Errorf("Onion: rule for port %d needs targetPort or targetPortName", r.PublicPort)
+	case r.TargetPort < 0 || r.TargetPort > 65535:
+		return fmt.Errorf("Onion: targetPort must be between 1 and 65535, got %d", r.TargetPort)
+	case r.TargetPortName != "" && r.TargetPortName != "http":
+		return fmt.Errorf("Onion: unknown targetPortName %q, must be http", r.TargetPortName)
+	}
+	return nil
 }
 
 func (o *Onion) UnmarshalJSON(data []byte) error {
@@ -135,14 +1169,71 @@ func (o *Onion) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, (*OnionAlt)(o)); err != nil {
 		return err
 	}
+	switch o.Version {
+	case 0:
+		o.Version = 3
+	case 3:
+		// all is good
+	default:
+		return fmt.Errorf("Onion: unsupported version %d, only version 3 is supported", o.Version)
+	}
+	if o.MaxStreams < 0 || o.MaxStreams > 65535 {
+		return fmt.Errorf("Onion: maxStreams must be between 0 and 65535, got %d", o.MaxStreams)
+	}
+	if o.NumIntroductionPoints != 0 && (o.NumIntroductionPoints < 1 || o.NumIntroductionPoints > 20) {
+		return fmt.Errorf("Onion: numIntroductionPoints must be between 1 and 20, got %d", o.NumIntroductionPoints)
+	}
+	publicPorts := map[int32]bool{}
+	for _, rule := range o.Rules {
+		if publicPorts[rule.PublicPort] {
+			return fmt.Errorf("Onion: publicPort %d is published more than once", rule.PublicPort)
+		}
+		publicPorts[rule.PublicPort] = true
+	}
+	return nil
+}
+
+type ScratchVolume struct {
+	Name      string `json:"name" yaml:"name" description:"The name of the volume."`
+	Path      string `json:"path" yaml:"path" description:"Where to mount the volume in the App pods." example:"/tmp"`
+	SizeLimit string `json:"sizeLimit,omitempty" yaml:"sizeLimit,omitempty" description:"The most the volume can hold before the pod is evicted." example:"512Mi"`
+	Medium    string `json:"medium,omitempty" yaml:"medium,omitempty" description:"Where the volume is stored: the node's disk (default) or Memory for a tmpfs that counts against the App's memory." Enum:"Memory"`
+}
+
+func (v *ScratchVolume) UnmarshalJSON(data []byte) error {
+	type ScratchVolumeAlt ScratchVolume
+	if err := json.Unmarshal(data, (*ScratchVolumeAlt)(v)); err != nil {
+		return err
+	}
+	if v.Name == "" {
+		return fmt.Errorf("name is required for scratch volumes")
+	}
+	if errs := validation.IsDNS1123Label(v.Name); len(errs) != 0 {
+		return fmt.Errorf("invalid scratch volume name %q: %s", v.Name, strings.Join(errs, ", "))
+	}
+	if v.Path == "" {
+		return fmt.Errorf("path is required for scratch volumes")
+	}
+	if v.SizeLimit != "" {
+		if _, err := resource.ParseQuantity(v.SizeLimit); err != nil {
+			return fmt.Errorf("invalid sizeLimit for scratch volume %s: %v", v.Name, err)
+		}
+	}
+	switch corev1.StorageMedium(v.Medium) {
+	case corev1.StorageMediumDefault, corev1.StorageMediumMemory:
+		// all is good
+	default:
+		return fmt.Errorf("unknown medium %q for scratch volume %s, must be Memory or unset", v.Medium, v.Name)
+	}
 	return nil
 }
 
 type Volume struct {
-	Name         string  `json:"name" yaml:"name"`
-	Path         string  `json:"path" yaml:"path"`
-	Size         string  `json:"size" yaml:"size"`
-	StorageClass *string `json:"storageClass,omitempty" yaml:"storageClass,omitempty"`
+	Name         string  `json:"name" yaml:"name" description:"The name of the volume, the PVC is named <app>-<name>."`
+	Path         string  `json:"path" yaml:"path" description:"Where to mount the volume in the App pods."`
+	Size         string  `json:"size" yaml:"size" description:"How much storage to allocate." example:"4Gi"`
+	StorageClass *string `json:"storageClass,omitempty" yaml:"storageClass,omitempty" description:"The Kubernetes storage class to use for the volume."`
+	AccessMode   string  `json:"accessMode,omitempty" yaml:"accessMode,omitempty" description:"The access mode of the volume. Defaults to ReadWriteOnce." Enum:"ReadWriteOnce,ReadOnlyMany,ReadWriteMany,ReadWriteOncePod"`
 }
 
 func (v *Volume) UnmarshalJSON(data []byte) error {
@@ -153,6 +1244,9 @@ func (v *Volume) UnmarshalJSON(data []byte) error {
 	if v.Name == "" {
 		return fmt.Errorf("name is required for volumes")
 	}
+	if errs := validation.IsDNS1123Label(v.Name); len(errs) != 0 {
+		return fmt.Errorf("invalid volume name %q: %s", v.Name, strings.Join(errs, ", "))
+	}
 	if v.Path == "" {
 		return fmt.Errorf("path is required for volumes")
 	}
@@ -165,14 +1259,51 @@ func (v *Volume) UnmarshalJSON(data []byte) error {
 		return fmt.Errorf("invalid size: %v", err)
 	}
 
+	switch corev1.PersistentVolumeAccessMode(v.AccessMode) {
+	case "":
+		v.AccessMode = string(corev1.ReadWriteOnce)
+	case corev1.ReadWriteOnce, corev1.ReadOnlyMany, corev1.ReadWriteMany, corev1.ReadWriteOncePod:
+		// all is good
+	default:
+		return fmt.Errorf("unknown accessMode %q for volume %s", v.AccessMode, v.Name)
+	}
+
+	return nil
+}
+
+// SecretItem mounts one key of a folder secret as a file, like corev1.KeyToPath.
+type SecretItem struct {
+	Key  string `json:"key" yaml:"key" description:"The key of the secret to mount." example:"private key"`
+	Path string `json:"path" yaml:"path" description:"The file to mount the key as, relative to the secret's mountPath." example:"tls.key"`
+	Mode *int32 `json:"mode,omitempty" yaml:"mode,omitempty" description:"The permission bits of the file, such as 0400. Defaults to 0644." example:"256" Minimum:"0"`
+}
+
+func (i *SecretItem) UnmarshalJSON(data []byte) error {
+	type SecretItemAlt SecretItem
+	if err := json.Unmarshal(data, (*SecretItemAlt)(i)); err != nil {
+		return err
+	}
+	if i.Key == "" {
+		return fmt.Errorf("key is required for secret items")
+	}
+	if i.Path == "" {
+		return fmt.Errorf("path is required for secret item %s", i.Key)
+	}
+	// The kubelet refuses paths that could escape the volume.
+	if path.IsAbs(i.Path) || slices.Contains(strings.Split(i.Path, "/"), "..") {
+		return fmt.Errorf("path of secret item %s must be relative and can't contain '..', got %q", i.Key, i.Path)
+	}
+	if i.Mode != nil && (*i.Mode < 0 || *i.Mode > 0777) {
+		return fmt.Errorf("mode of secret item %s must be between 0 and 0777, got %#o", i.Key, *i.Mode)
+	}
 	return nil
 }
 
 type Storage struct {
-	Enabled      bool    `json:"enabled" yaml:"enabled"`
-	Path         string  `json:"path" yaml:"path"`
-	Size         string  `json:"size" yaml:"size"`
-	StorageClass *string `json:"storageClass,omitempty" yaml:"storageClass,omitempty"`
+	Enabled      bool    `json:"enabled" yaml:"enabled" description:"If true, create persistent storage for this App."`
+	Path         string  `json:"path" yaml:"path" description:"Where to mount the storage in the App pods."`
+	Size         string  `json:"size" yaml:"size" description:"How much storage to allocate." example:"4Gi"`
+	StorageClass *string `json:"storageClass,omitempty" yaml:"storageClass,omitempty" description:"The Kubernetes storage class to use for the storage."`
 }
 
 func (s *Storage) UnmarshalJSON(data []byte) error {
@@ -196,12 +1327,164 @@ func (s *Storage) UnmarshalJSON(data []byte) error {
 }
 
 type Role struct {
-	Enabled bool                `json:"enabled" yaml:"enabled"`
-	Rules   []rbacv1.PolicyRule `json:"rules,omitempty" yaml:"rules,omitempty"`
+	Enabled      bool                `json:"enabled" yaml:"enabled" description:"Not used, the rules are granted whenever role is set."`
+	Rules        []rbacv1.PolicyRule `json:"rules,omitempty" yaml:"rules,omitempty" description:"The RBAC rules bound to the App's ServiceAccount."`
+	Cluster      bool                `json:"cluster,omitempty" yaml:"cluster,omitempty" description:"If true, grant rules cluster-wide with a ClusterRole and ClusterRoleBinding instead of a Role and RoleBinding."`
+	ClusterRules []rbacv1.PolicyRule `json:"clusterRules,omitempty" yaml:"clusterRules,omitempty" description:"RBAC rules granted cluster-wide, alongside the namespaced rules."`
+}
+
+func (r *Role) UnmarshalJSON(data []byte) error {
+	type RoleAlt Role
+	if err := json.Unmarshal(data, (*RoleAlt)(r)); err != nil {
+		return err
+	}
+	if len(r.Rules) == 0 && len(r.ClusterRules) == 0 {
+		return fmt.Errorf("role needs at least one rule in rules or clusterRules")
+	}
+	if r.Cluster && len(r.ClusterRules) != 0 {
+		return fmt.Errorf("cannot set cluster and clusterRules at the same time, put the rules in rules instead")
+	}
+	return nil
+}
+
+// NamespacedRules are the rules granted with a Role in the App's namespace.
+func (r Role) NamespacedRules() []rbacv1.PolicyRule {
+	if r.Cluster {
+		return nil
+	}
+	return r.Rules
+}
+
+// ClusterWideRules are the rules granted with a ClusterRole.
+func (r Role) ClusterWideRules() []rbacv1.PolicyRule {
+	if r.Cluster {
+		return r.Rules
+	}
+	return r.ClusterRules
+}
+
+// podVolumes are the names of the volumes the App's pods get from its other settings.
+func (s AppSpec) podVolumes() map[string]bool {
+	result := map[string]bool{}
+	if s.Storage != nil && s.Storage.Enabled {
+		result["storage"] = true
+	}
+	for _, v := range s.Volumes {
+		result["pvc-"+v.Name] = true
+	}
+	for _, v := range s.Scratch {
+		result["scratch-"+v.Name] = true
+	}
+	for _, sec := range s.Secrets {
+		if sec.Folder {
+			result["secret-"+sec.Name] = true
+		}
+	}
+	for _, cm := range s.ConfigMaps {
+		result["cm-"+cm.Name] = true
+	}
+	if s.ServiceAccount != nil && s.ServiceAccount.TokenProjection != nil {
+		result["sa-token"] = true
+	}
+	if s.TmpVolume() {
+		result["tmp"] = true
+	}
+	if s.Expose == "tailscale" && s.Tailscale != nil {
+		result["tailscale-serve"] = true
+		if s.Tailscale.State == "emptyDir" {
+			result["tailscale-state"] = true
+		}
+	}
+	return result
+}
+
+// checkExtraVolumes makes sure every extra volume mount has a volume to mount. A dangling mount leaves the pods
+// stuck without a clear reason.
+func (s AppSpec) checkExtraVolumes() error {
+	volumes := s.podVolumes()
+	for _, v := range s.ExtraVolumes {
+		if errs := validation.IsDNS1123Label(v.Name); len(errs) != 0 {
+			return fmt.Errorf("invalid extraVolumes name %q: %s", v.Name, strings.Join(errs, ", "))
+		}
+		if volumes[v.Name] {
+			return fmt.Errorf("extra volume %s is defined more than once or conflicts with a volume of the App", v.Name)
+		}
+		volumes[v.Name] = true
+	}
+	for _, m := range s.ExtraVolumeMounts {
+		if !volumes[m.Name] {
+			return fmt.Errorf("extra volume mount at %s mounts volume %q, which is not in extraVolumes or a volume of the App", m.MountPath, m.Name)
+		}
+		if !path.IsAbs(m.MountPath) {
+			return fmt.Errorf("extra volume mount of %s must have an absolute mountPath, got %q", m.Name, m.MountPath)
+		}
+	}
+	return nil
+}
+
+type ServiceAccount struct {
+	Name            string            `json:"name,omitempty" yaml:"name,omitempty" description:"The name of the App's ServiceAccount. Defaults to the App name." example:"stickers-api"`
+	Annotations     map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty" description:"Additional annotations added to the ServiceAccount, such as for cloud workload identity." example:"{\"eks.amazonaws.com/role-arn\": \"arn:aws:iam::111122223333:role/stickers\"}"`
+	AutomountToken  *bool             `json:"automountToken,omitempty" yaml:"automountToken,omitempty" description:"If true, mount a token for the App's ServiceAccount into its pods. Defaults to true when role is set and tokenProjection isn't set, and false otherwise."`
+	TokenProjection *TokenProjection  `json:"tokenProjection,omitempty" yaml:"tokenProjection,omitempty" description:"If set, mount a ServiceAccount token for another audience, such as an external service that trusts the cluster's tokens."`
+}
+
+func (s *ServiceAccount) UnmarshalJSON(data []byte) error {
+	type ServiceAccountAlt ServiceAccount
+	if err := json.Unmarshal(data, (*ServiceAccountAlt)(s)); err != nil {
+		return err
+	}
+	if s.Name != "" {
+		if errs := validation.IsDNS1123Subdomain(s.Name); len(errs) != 0 {
+			return fmt.Errorf("invalid serviceAccount.name %q: %s", s.Name, strings.Join(errs, ", "))
+		}
+	}
+	return apivalidation.ValidateAnnotations(s.Annotations, field.NewPath("serviceAccount", "annotations")).ToAggregate()
+}
+
+type TokenProjection struct {
+	Audience          string `json:"audience" yaml:"audience" description:"The audience of the token, which the service checking it expects." example:"vault"`
+	ExpirationSeconds *int64 `json:"expirationSeconds,omitempty" yaml:"expirationSeconds,omitempty" description:"How long the token is valid for. The kubelet renews it before it expires. Defaults to 3600." example:"3600" Minimum:"600"`
+	MountPath         string `json:"mountPath,omitempty" yaml:"mountPath,omitempty" description:"Where to mount the token in the App pods, as the file token in that folder. Defaults to /var/run/secrets/tokens." example:"/var/run/secrets/vault"`
+}
+
+func (t *TokenProjection) UnmarshalJSON(data []byte) error {
+	type TokenProjectionAlt TokenProjection
+	if err := json.Unmarshal(data, (*TokenProjectionAlt)(t)); err != nil {
+		return err
+	}
+	if t.Audience == "" {
+		return fmt.Errorf("serviceAccount.tokenProjection.audience is required")
+	}
+	// The API server refuses tokens that are valid for less than ten minutes.
+	if t.ExpirationSeconds != nil && *t.ExpirationSeconds < 600 {
+		return fmt.Errorf("serviceAccount.tokenProjection.expirationSeconds must be at least 600, got %d", *t.ExpirationSeconds)
+	}
+	if t.MountPath == "" {
+		t.MountPath = "/var/run/secrets/tokens"
+	}
+	if !path.IsAbs(t.MountPath) {
+		return fmt.Errorf("serviceAccount.tokenProjection.mountPath must be an absolute path, got %q", t.MountPath)
+	}
+	t.MountPath = path.Clean(t.MountPath)
+	return nil
+}
+
+// AutomountToken reports if the App's pods get a token for its ServiceAccount. Without RBAC rules the token can't
+// do anything useful, so it is only mounted for Apps with a role unless they ask for it. Apps with a projected
+// token don't get the default one as well unless they ask for it.
+func (s AppSpec) AutomountToken() bool {
+	if s.ServiceAccount != nil && s.ServiceAccount.AutomountToken != nil {
+		return *s.ServiceAccount.AutomountToken
+	}
+	if s.ServiceAccount != nil && s.ServiceAccount.TokenProjection != nil {
+		return false
+	}
+	return s.Role != nil
 }
 
 type Anubis struct {
-	Enabled  bool `json:"enabled" yaml:"enabled"`
+	Enabled  bool `json:"enabled" yaml:"enabled" description:"If true, protect this App with Anubis."`
 	Settings struct {
 		Difficulty     int  `json:"difficulty"`
 		ServeRobotsTxt bool `json:"serveRobotsTXT"`
@@ -209,9 +1492,129 @@ type Anubis struct {
 }
 
 type ConfigMap struct {
-	Name   string            `json:"name" yaml:"name"`
-	Data   map[string]string `json:"data" yaml:"data"`
-	Folder string            `json:"folder" yaml:"folder"`
+	Name   string            `json:"name" yaml:"name" description:"The name of the ConfigMap."`
+	Data   map[string]string `json:"data" yaml:"data" description:"The files in the ConfigMap, keyed by file name."`
+	Folder string            `json:"folder" yaml:"folder" description:"Where to mount the ConfigMap in the App pods."`
+}
+
+type Cron struct {
+	Name                       string   `json:"name" yaml:"name" description:"The name of the job, the CronJob is named <app>-<name>."`
+	Schedule                   string   `json:"schedule" yaml:"schedule" description:"The cron schedule of the job." example:"0 4 * * *"`
+	Command                    []string `json:"command,omitempty" yaml:"command,omitempty" description:"The command to run, overriding the image entrypoint."`
+	Args                       []string `json:"args,omitempty" yaml:"args,omitempty" description:"Arguments passed to the command."`
+	ConcurrencyPolicy          string   `json:"concurrencyPolicy,omitempty" yaml:"concurrencyPolicy,omitempty" description:"What to do when a run is still going when the next one is due: Allow (default), Forbid, or Replace." Enum:"Allow,Forbid,Replace"`
+	SuccessfulJobsHistoryLimit *int32   `json:"successfulJobsHistoryLimit,omitempty" yaml:"successfulJobsHistoryLimit,omitempty" description:"How many successful jobs to keep around. Defaults to 3."`
+}
+
+func (c *Cron) UnmarshalJSON(data []byte) error {
+	type CronAlt Cron
+	if err := json.Unmarshal(data, (*CronAlt)(c)); err != nil {
+		return err
+	}
+	if errs := validation.IsDNS1123Label(c.Name); len(errs) != 0 {
+		return fmt.Errorf("invalid cron name %q: %s", c.Name, strings.Join(errs, ", "))
+	}
+	if _, err := cron.ParseStandard(c.Schedule); err != nil {
+		return fmt.Errorf("cron %s: invalid schedule %q: %w", c.Name, c.Schedule, err)
+	}
+	switch c.ConcurrencyPolicy {
+	case "", "Allow", "Forbid", "Replace":
+		// all is good
+	default:
+		return fmt.Errorf("cron %s: unknown concurrencyPolicy %q, must be one of Allow, Forbid, or Replace", c.Name, c.ConcurrencyPolicy)
+	}
+	if c.SuccessfulJobsHistoryLimit != nil && *c.SuccessfulJobsHistoryLimit < 0 {
+		return fmt.Errorf("cron %s: successfulJobsHistoryLimit must not be negative", c.Name)
+	}
+	return nil
+}
+
+type Alerts struct {
+	Defaults bool              `json:"defaults,omitempty" yaml:"defaults,omitempty" description:"If true, add the standard alerts for the App's pods restarting in a loop and its workload having fewer available replicas than it should. They need kube-state-metrics."`
+	Rules    []Alert           `json:"rules,omitempty" yaml:"rules,omitempty" description:"Alerts of the App's own."`
+	Labels   map[string]string `json:"labels,omitempty" yaml:"labels,omitempty" description:"Additional labels added to the PrometheusRule, such as the ones the ruleSelector of Prometheus matches." example:"{\"release\": \"kube-prometheus-stack\"}"`
+}
+
+type Alert struct {
+	Name     string `json:"name" yaml:"name" description:"The name of the alert." example:"StickersHighErrorRate"`
+	Expr     string `json:"expr" yaml:"expr" description:"The PromQL expression the alert fires for." example:"sum(rate(http_requests_total{job=\"stickers\",code=~\"5..\"}[5m])) > 1"`
+	For      string `json:"for,omitempty" yaml:"for,omitempty" description:"How long expr has to hold before the alert fires, as a Prometheus duration. Defaults to firing right away." example:"10m"`
+	Severity string `json:"severity,omitempty" yaml:"severity,omitempty" description:"The severity label of the alert: critical, warning (default), or info." Enum:"critical,warning,info"`
+	Summary  string `json:"summary,omitempty" yaml:"summary,omitempty" description:"The summary annotation of the alert. It can use Prometheus templates such as {{ $labels.pod }}." example:"Stickers is failing requests"`
+}
+
+var (
+	alertNameRe        = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	prometheusDuration = regexp.MustCompile(`^(([0-9]+)y)?(([0-9]+)w)?(([0-9]+)d)?(([0-9]+)h)?(([0-9]+)m)?(([0-9]+)s)?(([0-9]+)ms)?$`)
+)
+
+func (a *Alert) UnmarshalJSON(data []byte) error {
+	type AlertAlt Alert
+	if err := json.Unmarshal(data, (*AlertAlt)(a)); err != nil {
+		return err
+	}
+	if !alertNameRe.MatchString(a.Name) {
+		return fmt.Errorf("alerts: invalid alert name %q, must be letters, digits, and underscores", a.Name)
+	}
+	if strings.TrimSpace(a.Expr) == "" {
+		return fmt.Errorf("alert %s: expr is required", a.Name)
+	}
+	if a.For != "" && !prometheusDuration.MatchString(a.For) {
+		return fmt.Errorf("alert %s: invalid for %q, must be a Prometheus duration such as 5m or 1h30m", a.Name, a.For)
+	}
+	switch a.Severity {
+	case "":
+		a.Severity = "warning"
+	case "critical", "warning", "info":
+		// all is good
+	default:
+		return fmt.Errorf("alert %s: unknown severity %q, must be one of critical, warning, or info", a.Name, a.Severity)
+	}
+	return nil
+}
+
+type Bootstrap struct {
+	Command      []string `json:"command" yaml:"command" description:"The command to run, overriding the image entrypoint." example:"[\"/app/manage\", \"create-admin\"]"`
+	Args         []string `json:"args,omitempty" yaml:"args,omitempty" description:"Arguments passed to the command."`
+	BackoffLimit *int32   `json:"backoffLimit,omitempty" yaml:"backoffLimit,omitempty" description:"How many times to retry the command before giving up. Defaults to 6."`
+	RunPolicy    string   `json:"runPolicy,omitempty" yaml:"runPolicy,omitempty" description:"When to run the command: once (default) runs it a single time per command, onSpecChange runs it again whenever the App changes." Enum:"once,onSpecChange"`
+}
+
+func (b *Bootstrap) UnmarshalJSON(data []byte) error {
+	type BootstrapAlt Bootstrap
+	if err := json.Unmarshal(data, (*BootstrapAlt)(b)); err != nil {
+		return err
+	}
+	if len(b.Command) == 0 {
+		return fmt.Errorf("bootstrap: command is required")
+	}
+	if b.BackoffLimit != nil && *b.BackoffLimit < 0 {
+		return fmt.Errorf("bootstrap: backoffLimit must not be negative")
+	}
+	switch b.RunPolicy {
+	case "":
+		b.RunPolicy = "once"
+	case "once", "onSpecChange":
+		// all is good
+	default:
+		return fmt.Errorf("bootstrap: unknown runPolicy %q, must be one of once or onSpecChange", b.RunPolicy)
+	}
+	return nil
+}
+
+type RestartPolicy struct {
+	MaxPodLifetime metav1.Duration `json:"maxPodLifetime" yaml:"maxPodLifetime" description:"The longest a pod may run before the App is restarted. Rounded down to a cron schedule, must be at least 1h." example:"24h"`
+}
+
+func (r *RestartPolicy) UnmarshalJSON(data []byte) error {
+	type RestartPolicyAlt RestartPolicy
+	if err := json.Unmarshal(data, (*RestartPolicyAlt)(r)); err != nil {
+		return err
+	}
+	if r.MaxPodLifetime.Duration < time.Hour {
+		return fmt.Errorf("maxPodLifetime must be at least 1h, got %s", r.MaxPodLifetime.Duration)
+	}
+	return nil
 }
 
 func (cm ConfigMap) GenName() string {
@@ -249,8 +1652,225 @@ func (app *App) UnmarshalJSON(data []byte) error {
 	if app.Kind != KindApp {
 		return fmt.Errorf("unexpected kind: expected %s but got %s", KindApp, app.Kind)
 	}
-	if app.Spec.Replicas == 0 {
-		app.Spec.Replicas = 1
+	if app.Spec.Size != "" {
+		if _, ok := sizes[app.Spec.Size]; !ok {
+			return fmt.Errorf("unknown size %q, must be one of small, medium, or large", app.Spec.Size)
+		}
+		// Expanded before validating, so that resourcePolicy checks what the App will actually get.
+		app.Spec.Resources = app.Spec.Resources.sized(app.Spec.Size)
+	}
+	if gpu := app.Spec.GPU; gpu != nil {
+		if app.Spec.Resources != nil {
+			name := corev1.ResourceName(gpu.ResourceName)
+			_, requested := app.Spec.Resources.Requests[name]
+			_, limited := app.Spec.Resources.Limits[name]
+			if requested || limited {
+				return fmt.Errorf("gpu and resources can't both set %s", name)
+			}
+		}
+		app.Spec.Resources = app.Spec.Resources.withGPU(*gpu)
+	}
+	if name := app.Spec.RuntimeClassName; name != "" {
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) != 0 {
+			return fmt.Errorf("invalid runtimeClassName %q: %s", name, strings.Join(errs, ", "))
+		}
+	}
+	if err := app.Spec.Valid(); err != nil {
+		return err
+	}
+	if app.Spec.RunAsRoot && app.Spec.SecurityContext != nil && app.Spec.SecurityContext.ReadOnlyRootFilesystem {
+		// runAsRoot drops the whole security context, so the root filesystem would quietly stay writable.
+		return fmt.Errorf("securityContext.readOnlyRootFilesystem cannot be used with runAsRoot, which turns off the container hardening")
+	}
+	if app.Spec.RunAsRoot && app.Spec.SecurityContext != nil {
+		return fmt.Errorf("securityContext cannot be used with runAsRoot")
+	}
+	volumes := map[string]bool{}
+	if app.Spec.Storage != nil && app.Spec.Storage.Enabled {
+		// The single storage volume is the PVC <app>-storage.
+		volumes["storage"] = true
+	}
+	for _, v := range app.Spec.Volumes {
+		if volumes[v.Name] {
+			return fmt.Errorf("volume %s is defined more than once", v.Name)
+		}
+		volumes[v.Name] = true
+	}
+	mountPaths := map[string]string{}
+	if app.Spec.Storage != nil && app.Spec.Storage.Enabled {
+		mountPaths[path.Clean(app.Spec.Storage.Path)] = "storage"
+	}
+	for _, sec := range app.Spec.Secrets {
+		if !sec.Folder {
+			continue
+		}
+		if other, ok := mountPaths[sec.MountPath]; ok {
+			return fmt.Errorf("secret %s is mounted at %s, which is already used by %s", sec.Name, sec.MountPath, other)
+		}
+		mountPaths[sec.MountPath] = "secret " + sec.Name
+	}
+	if sa := app.Spec.ServiceAccount; sa != nil && sa.TokenProjection != nil {
+		if other, ok := mountPaths[sa.TokenProjection.MountPath]; ok {
+			return fmt.Errorf("serviceAccount.tokenProjection is mounted at %s, which is already used by %s", sa.TokenProjection.MountPath, other)
+		}
+	}
+	scratch := map[string]bool{}
+	for _, v := range app.Spec.Scratch {
+		if scratch[v.Name] {
+			return fmt.Errorf("scratch volume %s is defined more than once", v.Name)
+		}
+		scratch[v.Name] = true
+	}
+	if err := app.Spec.checkTailscale(); err != nil {
+		return err
+	}
+	if app.Spec.Expose == "tailscale" && cmp.Or(app.Spec.ContainerName, app.Name) == "tailscale" {
+		return fmt.Errorf("the App's container can't be named tailscale with expose: tailscale, set containerName")
+	}
+	if err := app.Spec.checkExtraVolumes(); err != nil {
+		return err
+	}
+	if sa := app.Spec.ServiceAccount; sa != nil && app.Spec.RestartPolicy != nil && sa.Name == app.Name+"-restart" {
+		// The scheduled restart CronJob has its own ServiceAccount named <app>-restart.
+		return fmt.Errorf("serviceAccount.name %s conflicts with the ServiceAccount of restartPolicy", sa.Name)
+	}
+	crons := map[string]bool{}
+	if app.Spec.RestartPolicy != nil {
+		// The scheduled restart CronJob is named <app>-restart.
+		crons["restart"] = true
+	}
+	if app.Spec.Bootstrap != nil {
+		// Bootstrap Jobs are named <app>-bootstrap-<hash>, which could collide with the Jobs of a cron named bootstrap.
+		crons["bootstrap"] = true
+	}
+	for _, c := range app.Spec.Crons {
+		if crons[c.Name] {
+			return fmt.Errorf("cron %s is defined more than once", c.Name)
+		}
+		crons[c.Name] = true
+	}
+	switch app.Spec.Mesh {
+	case "", "linkerd", "istio":
+		// all is good
+	default:
+		return fmt.Errorf("unknown mesh %q, must be one of linkerd or istio", app.Spec.Mesh)
+	}
+	if app.Spec.HostNetwork && app.Spec.Mesh != "" {
+		// The sidecar would rewrite the node's iptables rules instead of the pod's.
+		return fmt.Errorf("hostNetwork cannot be used with mesh")
+	}
+	if app.Spec.ImagePullPolicy == "" {
+		app.Spec.ImagePullPolicy = "Always"
+	}
+	switch app.Spec.Workload {
+	case "":
+		app.Spec.Workload = "deployment"
+	case "deployment", "statefulset":
+		// all is good
+	default:
+		return fmt.Errorf("unknown workload %q, must be one of deployment or statefulset", app.Spec.Workload)
+	}
+	if app.Spec.Canary != nil && app.Spec.Canary.Enabled && app.Spec.Workload != "deployment" {
+		return fmt.Errorf("canary needs workload deployment, got %s", app.Spec.Workload)
+	}
+	if app.Spec.Onion != nil {
+		for _, rule := range app.Spec.Onion.Rules {
+			// Port 80 on the Service is the HTTP port, which forwards to the App port.
+			if rule.TargetPort == 80 && app.Spec.Port != 80 {
+				return fmt.Errorf("onion rule for port %d can't target container port 80, the Service uses port 80 for the App port", rule.PublicPort)
+			}
+		}
+	}
+	switch app.Spec.Protocol {
+	case "":
+		app.Spec.Protocol = "TCP"
+	case "TCP", "both":
+		// all is good
+	case "UDP":
+		// HTTP routing needs a TCP port to point at.
+		if app.Spec.Ingress != nil && app.Spec.Ingress.Enabled {
+			return fmt.Errorf("ingress requires protocol TCP or both, got UDP")
+		}
+		if app.Spec.Onion != nil && app.Spec.Onion.Enabled {
+			return fmt.Errorf("onion requires protocol TCP or both, got UDP")
+		}
+	default:
+		return fmt.Errorf("unknown protocol %q, must be one of TCP, UDP, or both", app.Spec.Protocol)
+	}
+	if ingress := app.Spec.Ingress; ingress != nil && len(ingress.Allowlist) != 0 && ingress.Gateway != nil {
+		return fmt.Errorf("ingress.allowlist needs ingress-nginx or Traefik, it can't be used with ingress.gateway")
+	}
+	if ingress := app.Spec.Ingress; ingress != nil && ingress.Gateway != nil && (len(ingress.Paths) != 0 || ingress.DefaultBackend != nil) {
+		return fmt.Errorf("ingress.paths and ingress.defaultBackend can't be used with ingress.gateway")
+	}
+	if ingress := app.Spec.Ingress; ingress != nil && ingress.Gateway != nil && len(ingress.RedirectFrom) != 0 {
+		return fmt.Errorf("ingress.redirectFrom needs ingress-nginx, it can't be used with ingress.gateway")
+	}
+	if ingress := app.Spec.Ingress; ingress != nil && ingress.BasicAuth != nil {
+		if ingress.Gateway != nil {
+			return fmt.Errorf("ingress.basicAuth needs ingress-nginx, it can't be used with ingress.gateway")
+		}
+		// The htpasswd secret is synced to <app>-basic-auth.
+		for _, sec := range app.Spec.Secrets {
+			if sec.Name == "basic-auth" {
+				return fmt.Errorf("secret basic-auth conflicts with the secret of ingress.basicAuth")
+			}
+		}
+	}
+	if app.Spec.Service != nil && app.Spec.Service.Type == "LoadBalancer" && app.Spec.Ingress != nil && app.Spec.Ingress.Enabled {
+		return fmt.Errorf("service.type LoadBalancer exposes the App directly, it cannot be combined with ingress")
+	}
+	if app.Spec.DNS != nil && app.Spec.DNS.Enabled {
+		ingress := app.Spec.Ingress != nil && app.Spec.Ingress.Enabled
+		loadBalancer := app.Spec.Service != nil && app.Spec.Service.Type == "LoadBalancer"
+		switch {
+		case !ingress && !loadBalancer:
+			return fmt.Errorf("dns needs ingress or service.type LoadBalancer, there is nothing to point records at")
+		case !ingress && app.Spec.DNS.Hostname == "":
+			return fmt.Errorf("dns.hostname is required without an ingress")
+		}
+	}
+	if app.Spec.Service != nil && app.Spec.Service.Headless {
+		// Ingress controllers and tor-controller route to the Service's cluster IP.
+		if app.Spec.Ingress != nil && app.Spec.Ingress.Enabled {
+			return fmt.Errorf("service.headless cannot be used with ingress, use service.alsoHeadless instead")
+		}
+		if app.Spec.Onion != nil && app.Spec.Onion.Enabled {
+			return fmt.Errorf("service.headless cannot be used with onion, use service.alsoHeadless instead")
+		}
+	}
+	if suffix := app.Spec.NameSuffix; suffix != "" {
+		if errs := validation.IsDNS1123Label(suffix); len(errs) != 0 {
+			return fmt.Errorf("invalid nameSuffix %q: %s", suffix, strings.Join(errs, ", "))
+		}
+		// The suffixed name is the selector label value and the Service name, and the longest Service is the
+		// headless one.
+		name := app.Name + "-" + suffix
+		if (app.Spec.Service != nil && app.Spec.Service.AlsoHeadless) || app.Spec.Workload == "statefulset" {
+			name += "-headless"
+		}
+		if len(name) > validation.DNS1123LabelMaxLength {
+			return fmt.Errorf("nameSuffix %q makes the name %s longer than %d characters", suffix, name, validation.DNS1123LabelMaxLength)
+		}
+		// A wildcard can't take a suffix, *-pr-42.example.com isn't a valid host.
+		if app.Spec.Ingress != nil && app.Spec.Ingress.Enabled && strings.HasPrefix(app.Spec.Ingress.Host, "*.") {
+			return fmt.Errorf("nameSuffix can't be used with the wildcard ingress host %s", app.Spec.Ingress.Host)
+		}
+		if app.Spec.Ingress != nil && app.Spec.Ingress.Enabled {
+			// The hosts in redirectFrom are suffixed like the host, so the preview doesn't take their redirects.
+			for _, host := range append([]string{app.Spec.Ingress.Host}, app.Spec.Ingress.RedirectFrom...) {
+				label, _, _ := strings.Cut(host, ".")
+				if len(label)+len("-")+len(suffix) > validation.DNS1123LabelMaxLength {
+					return fmt.Errorf("nameSuffix %q makes the first label of ingress host %s longer than %d characters", suffix, host, validation.DNS1123LabelMaxLength)
+				}
+			}
+		}
+		if app.Spec.DNS != nil && app.Spec.DNS.Hostname != "" {
+			label, _, _ := strings.Cut(app.Spec.DNS.Hostname, ".")
+			if len(label)+len("-")+len(suffix) > validation.DNS1123LabelMaxLength {
+				return fmt.Errorf("nameSuffix %q makes the first label of dns hostname %s longer than %d characters", suffix, app.Spec.DNS.Hostname, validation.DNS1123LabelMaxLength)
+			}
+		}
 	}
 	return nil
 }
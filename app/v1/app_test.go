@@ -0,0 +1,552 @@
+package v1
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/utils/ptr"
+)
+
+// decode decodes an App from the YAML of its spec.
+func decode(spec string) (App, error) {
+	manifest := "apiVersion: x.within.website/v1\nkind: App\nmetadata:\n  name: stickers\nspec:\n" + indent(spec)
+
+	var app App
+	err := yaml.NewYAMLToJSONDecoder(strings.NewReader(manifest)).Decode(&app)
+	return app, err
+}
+
+// indent indents every line of s by two spaces, so that it can go under spec:.
+func indent(s string) string {
+	lines := strings.Split(strings.Trim(s, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = "  " + line
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestRole(t *testing.T) {
+	for _, tt := range []struct {
+		name, spec string
+		wantErr    string
+		wantToken  bool
+	}{
+		{
+			name: "no role",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+`,
+		},
+		{
+			name: "enabled",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+role:
+  enabled: true
+  rules:
+    - apiGroups: [""]
+      resources: ["configmaps"]
+      verbs: ["get"]
+`,
+			wantToken: true,
+		},
+		{
+			// enabled isn't used, like before the token depended on the role.
+			name: "enabled: false",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+role:
+  enabled: false
+  rules:
+    - apiGroups: [""]
+      resources: ["configmaps"]
+      verbs: ["get"]
+`,
+			wantToken: true,
+		},
+		{
+			name: "without rules",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+role:
+  enabled: false
+`,
+			wantErr: "role needs at least one rule",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app, err := decode(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to decode App: %v", err)
+			}
+
+			if got := app.Spec.AutomountToken(); got != tt.wantToken {
+				t.Errorf("AutomountToken() = %t, want %t", got, tt.wantToken)
+			}
+		})
+	}
+}
+
+func TestValid(t *testing.T) {
+	for _, tt := range []struct {
+		name, spec string
+		wantErr    string
+	}{
+		{
+			name: "valid",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+port: 8080
+replicas: 0
+healthcheck:
+  enabled: true
+  port: 9000
+`,
+		},
+		{
+			name:    "no image",
+			spec:    `port: 8080`,
+			wantErr: "image is required",
+		},
+		{
+			name: "port too large",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+port: 99999
+`,
+			wantErr: "port must be between 1 and 65535, got 99999",
+		},
+		{
+			name: "negative port",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+port: -1
+`,
+			wantErr: "port must be between 1 and 65535, got -1",
+		},
+		{
+			name: "negative replicas",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+replicas: -1
+`,
+			wantErr: "replicas can't be negative, got -1",
+		},
+		{
+			name: "healthcheck port too large",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+healthcheck:
+  enabled: true
+  port: 65536
+`,
+			wantErr: "healthcheck.port must be between 1 and 65535, got 65536",
+		},
+		{
+			name: "negative revisionHistoryLimit",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+revisionHistoryLimit: -1
+`,
+			wantErr: "revisionHistoryLimit can't be negative, got -1",
+		},
+		{
+			name: "zero progressDeadlineSeconds",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+progressDeadlineSeconds: 0
+`,
+			wantErr: "progressDeadlineSeconds must be positive, got 0",
+		},
+		{
+			name: "request over limit",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+resources:
+  requests:
+    memory: 1Gi
+  limits:
+    memory: 512Mi
+`,
+			wantErr: "resources.requests.memory (1Gi) can't be more than resources.limits.memory (512Mi)",
+		},
+		{
+			name: "invalid envFromConfigMaps name",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+envFromConfigMaps: [Shared_Config]
+`,
+			wantErr: `invalid envFromConfigMaps name "Shared_Config"`,
+		},
+		{
+			name: "invalid envFromSecrets name",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+envFromSecrets: [smtp credentials]
+`,
+			wantErr: `invalid envFromSecrets name "smtp credentials"`,
+		},
+		{
+			name: "unknown dnsPolicy",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+dnsPolicy: ClusterLast
+`,
+			wantErr: `unknown dnsPolicy "ClusterLast"`,
+		},
+		{
+			name: "dnsPolicy None without nameservers",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+dnsPolicy: None
+`,
+			wantErr: "dnsPolicy None requires at least one dnsConfig.nameservers entry",
+		},
+		{
+			name: "unknown imagePullPolicy",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+imagePullPolicy: Sometimes
+`,
+			wantErr: `unknown imagePullPolicy "Sometimes"`,
+		},
+		{
+			name: "invalid priorityClassName",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+priorityClassName: High_Priority
+`,
+			wantErr: `invalid priorityClassName "High_Priority"`,
+		},
+		{
+			name: "invalid containerName",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+containerName: Web_Server
+`,
+			wantErr: `invalid containerName "Web_Server"`,
+		},
+		{
+			name: "invalid pod annotation",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+podAnnotations:
+  "not a key": "value"
+`,
+			wantErr: "podAnnotations",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decode(tt.spec)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("failed to decode App: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), "spec is invalid: ") {
+				t.Errorf("got error %q, want it to say the spec is invalid", err)
+			}
+		})
+	}
+}
+
+func TestValidReportsEveryProblem(t *testing.T) {
+	spec := AppSpec{
+		Port:        99999,
+		Replicas:    ptr.To[int32](-2),
+		Healthcheck: &Healthcheck{Enabled: true, Port: 70000},
+	}
+
+	want := "spec is invalid: image is required\n" +
+		"port must be between 1 and 65535, got 99999\n" +
+		"replicas can't be negative, got -2\n" +
+		"healthcheck.port must be between 1 and 65535, got 70000"
+	if err := spec.Valid(); err == nil || err.Error() != want {
+		t.Errorf("got error:\n%v\nwant:\n%s", err, want)
+	}
+}
+
+func TestServiceTrafficDistribution(t *testing.T) {
+	for _, tt := range []struct {
+		name, spec string
+		wantErr    string
+	}{
+		{
+			name: "PreferClose",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+service:
+  trafficDistribution: PreferClose
+`,
+		},
+		{
+			name: "unknown",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+service:
+  trafficDistribution: PreferSameZone
+`,
+			wantErr: `Service: unknown trafficDistribution "PreferSameZone", must be PreferClose`,
+		},
+		{
+			name: "with topologyAwareHints",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+service:
+  trafficDistribution: PreferClose
+  topologyAwareHints: true
+`,
+			wantErr: "cannot set trafficDistribution and topologyAwareHints at the same time",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decode(tt.spec)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("failed to decode App: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNameSuffix(t *testing.T) {
+	for _, tt := range []struct {
+		name, spec string
+		wantErr    string
+	}{
+		{
+			name: "valid",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+nameSuffix: pr-42
+ingress:
+  enabled: true
+  host: stickers.within.website
+`,
+		},
+		{
+			name: "not a DNS label",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+nameSuffix: PR_42
+`,
+			wantErr: `invalid nameSuffix "PR_42"`,
+		},
+		{
+			name: "name too long",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+nameSuffix: ` + strings.Repeat("a", 55) + `
+`,
+			wantErr: "longer than 63 characters",
+		},
+		{
+			name: "ingress host label too long",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+nameSuffix: pr-42
+ingress:
+  enabled: true
+  host: ` + strings.Repeat("a", 60) + `.within.website
+`,
+			wantErr: "makes the first label of ingress host",
+		},
+		{
+			name: "redirectFrom host label too long",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+nameSuffix: pr-42
+ingress:
+  enabled: true
+  host: stickers.within.website
+  redirectFrom:
+    - ` + strings.Repeat("a", 60) + `.within.website
+`,
+			wantErr: "makes the first label of ingress host " + strings.Repeat("a", 60) + ".within.website",
+		},
+		{
+			name: "wildcard ingress host",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+nameSuffix: pr-42
+ingress:
+  enabled: true
+  host: "*.within.website"
+`,
+			wantErr: "nameSuffix can't be used with the wildcard ingress host *.within.website",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decode(tt.spec)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("failed to decode App: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// resources parses a Resources from requests and limits given as "name=quantity".
+func resources(requests, limits []string) *Resources {
+	parse := func(entries []string) corev1.ResourceList {
+		result := corev1.ResourceList{}
+		for _, entry := range entries {
+			name, quantity, _ := strings.Cut(entry, "=")
+			result[corev1.ResourceName(name)] = resource.MustParse(quantity)
+		}
+		return result
+	}
+	return &Resources{Requests: parse(requests), Limits: parse(limits)}
+}
+
+// errorStrings returns the messages of errs.
+func errorStrings(errs []error) []string {
+	var result []string
+	for _, err := range errs {
+		result = append(result, err.Error())
+	}
+	return result
+}
+
+func TestResourcesViolations(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		resources *Resources
+		want      []string
+	}{
+		{
+			name:      "requests within limits",
+			resources: resources([]string{"cpu=250m", "memory=256Mi"}, []string{"cpu=1", "memory=256Mi"}),
+		},
+		{
+			name:      "request without a limit",
+			resources: resources([]string{"cpu=250m"}, nil),
+		},
+		{
+			name:      "requests over limits",
+			resources: resources([]string{"cpu=2", "memory=1Gi"}, []string{"cpu=1", "memory=512Mi"}),
+			want: []string{
+				"resources.requests.cpu (2) can't be more than resources.limits.cpu (1)",
+				"resources.requests.memory (1Gi) can't be more than resources.limits.memory (512Mi)",
+			},
+		},
+		{
+			name:      "extended resource without a limit",
+			resources: resources([]string{"nvidia.com/gpu=1"}, nil),
+			want:      []string{"resources.limits.nvidia.com/gpu has to be set and equal resources.requests.nvidia.com/gpu, extended resources can't be overcommitted"},
+		},
+		{
+			name:      "extended resource overcommitted",
+			resources: resources([]string{"nvidia.com/gpu=1"}, []string{"nvidia.com/gpu=2"}),
+			want:      []string{"resources.limits.nvidia.com/gpu has to be set and equal resources.requests.nvidia.com/gpu, extended resources can't be overcommitted"},
+		},
+		{
+			name:      "extended resource equal",
+			resources: resources([]string{"nvidia.com/gpu=1"}, []string{"nvidia.com/gpu=1"}),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorStrings(tt.resources.violations()); !slices.Equal(got, tt.want) {
+				t.Errorf("got violations %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourcePolicyViolations(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		policy    ResourcePolicy
+		resources *Resources
+		want      []string
+	}{
+		{
+			name:   "no policy",
+			policy: ResourcePolicy{},
+		},
+		{
+			name:   "memory limit required without resources",
+			policy: ResourcePolicy{RequireMemoryLimit: true},
+			want:   []string{"resources.limits.memory is required by resourcePolicy.requireMemoryLimit"},
+		},
+		{
+			name:      "memory limit required and set",
+			policy:    ResourcePolicy{RequireMemoryLimit: true},
+			resources: resources(nil, []string{"memory=512Mi"}),
+		},
+		{
+			name:      "memory limit required but only requested",
+			policy:    ResourcePolicy{RequireMemoryLimit: true},
+			resources: resources([]string{"memory=512Mi"}, nil),
+			want:      []string{"resources.limits.memory is required by resourcePolicy.requireMemoryLimit"},
+		},
+		{
+			name:      "within the ratio",
+			policy:    ResourcePolicy{MaxLimitRequestRatio: 2},
+			resources: resources([]string{"cpu=500m", "memory=256Mi"}, []string{"cpu=1", "memory=512Mi"}),
+		},
+		{
+			name:      "limit without a request",
+			policy:    ResourcePolicy{MaxLimitRequestRatio: 2},
+			resources: resources(nil, []string{"memory=512Mi"}),
+		},
+		{
+			name:      "over the ratio",
+			policy:    ResourcePolicy{MaxLimitRequestRatio: 2},
+			resources: resources([]string{"cpu=250m", "memory=256Mi"}, []string{"cpu=1", "memory=512Mi"}),
+			want:      []string{"resources.limits.cpu (1) is more than 2× resources.requests.cpu (250m)"},
+		},
+		{
+			name:      "request without a limit",
+			policy:    ResourcePolicy{MaxLimitRequestRatio: 2},
+			resources: resources([]string{"cpu=250m"}, nil),
+			want:      []string{"resources.limits.cpu is required by resourcePolicy.maxLimitRequestRatio because resources.requests.cpu is set"},
+		},
+		{
+			name:      "zero request",
+			policy:    ResourcePolicy{MaxLimitRequestRatio: 2},
+			resources: resources([]string{"cpu=0"}, []string{"cpu=1"}),
+			want:      []string{"resources.requests.cpu can't be zero with resourcePolicy.maxLimitRequestRatio"},
+		},
+		{
+			name:      "every failure",
+			policy:    ResourcePolicy{MaxLimitRequestRatio: 1.5, RequireMemoryLimit: true},
+			resources: resources([]string{"cpu=250m", "memory=256Mi"}, []string{"cpu=1"}),
+			want: []string{
+				"resources.limits.memory is required by resourcePolicy.requireMemoryLimit",
+				"resources.limits.cpu (1) is more than 1.5× resources.requests.cpu (250m)",
+				"resources.limits.memory is required by resourcePolicy.maxLimitRequestRatio because resources.requests.memory is set",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorStrings(tt.policy.violations(tt.resources)); !slices.Equal(got, tt.want) {
+				t.Errorf("got violations %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package generate
+
+import (
+	"fmt"
+	"maps"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+)
+
+// defaultAlerts are the alerts of alerts.defaults. They go by the kube-state-metrics series of the App's pods and
+// workload. The pod pattern matches the pods of the App's Deployment or StatefulSet, but not those of its cron jobs
+// or of another App whose name starts with this one.
+func defaultAlerts(app v1.App) []v1.Alert {
+	pods := app.Name + "-[a-z0-9]+-[a-z0-9]{5}"
+	replicas := fmt.Sprintf(`kube_deployment_status_replicas_available{namespace=%q, deployment=%q} < kube_deployment_spec_replicas{namespace=%q, deployment=%q}`, app.Namespace, app.Name, app.Namespace, app.Name)
+	if app.Spec.Workload == "statefulset" {
+		pods = app.Name + "-[0-9]+"
+		replicas = fmt.Sprintf(`kube_statefulset_status_replicas_ready{namespace=%q, statefulset=%q} < kube_statefulset_replicas{namespace=%q, statefulset=%q}`, app.Namespace, app.Name, app.Namespace, app.Name)
+	}
+
+	return []v1.Alert{
+		{
+			Name:     "AppPodCrashLooping",
+			Expr:     fmt.Sprintf(`max_over_time(kube_pod_container_status_waiting_reason{reason="CrashLoopBackOff", namespace=%q, pod=~%q, container=%q}[5m]) >= 1`, app.Namespace, pods, containerName(app)),
+			For:      "15m",
+			Severity: "warning",
+			Summary:  fmt.Sprintf("Pod {{ $labels.pod }} of App %s is restarting in a loop.", app.Name),
+		},
+		{
+			Name:     "AppReplicasMismatch",
+			Expr:     replicas,
+			For:      "15m",
+			Severity: "warning",
+			Summary:  fmt.Sprintf("App %s has had fewer available replicas than it should for 15 minutes.", app.Name),
+		},
+	}
+}
+
+// CreatePrometheusRule creates the PrometheusRule with the App's alerts, in one group named after the App. Every
+// alert gets the App's namespace and name as labels, so they can be routed per App.
+func CreatePrometheusRule(app v1.App) *unstructured.Unstructured {
+	var alerts []v1.Alert
+	if app.Spec.Alerts.Defaults {
+		alerts = append(alerts, defaultAlerts(app)...)
+	}
+	alerts = append(alerts, app.Spec.Alerts.Rules...)
+
+	rules := make([]any, len(alerts))
+	for i, alert := range alerts {
+		rule := map[string]any{
+			"alert": alert.Name,
+			"expr":  alert.Expr,
+			"labels": map[string]any{
+				"severity":  alert.Severity,
+				"namespace": app.Namespace,
+				"app":       app.Name,
+			},
+		}
+		if alert.For != "" {
+			rule["for"] = alert.For
+		}
+		if alert.Summary != "" {
+			rule["annotations"] = map[string]any{
+				"summary": alert.Summary,
+			}
+		}
+		rules[i] = rule
+	}
+
+	result := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "monitoring.coreos.com/v1",
+			"kind":       "PrometheusRule",
+			"spec": map[string]any{
+				"groups": []any{
+					map[string]any{
+						"name":  app.Name,
+						"rules": rules,
+					},
+				},
+			},
+		},
+	}
+
+	labels := maps.Clone(app.Labels)
+	maps.Copy(labels, app.Spec.Alerts.Labels)
+
+	result.SetName(app.Name)
+	result.SetNamespace(app.Namespace)
+	result.SetLabels(labels)
+	return result
+}
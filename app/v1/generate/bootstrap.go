@@ -0,0 +1,90 @@
+package generate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+	"github.com/Xe/yoke-stuff/internal/renderreport"
+	"github.com/yokecd/yoke/pkg/flight/wasi/k8s"
+)
+
+// CreateBootstrapJob runs the App's bootstrap command as a Job.
+//
+// A Job's pod template can't be changed once it exists, so the Job is named after a hash of what decides when it
+// runs. With runPolicy once that is only the command: the Job keeps its name, and with it its completed run,
+// until the command changes. The template of a Job that already exists is reused so that unrelated changes to
+// the App (a new image tag, another secret) don't run into the immutable field. With runPolicy onSpecChange the
+// hash also covers the App's generation and the rendered pod template, so every change to the App gets a fresh
+// Job. A Job that can't be looked up is recorded in report.
+func CreateBootstrapJob(app v1.App, report *renderreport.Report) (*batchv1.Job, error) {
+	bootstrap := app.Spec.Bootstrap
+	template := jobPodTemplate(app, "bootstrap", bootstrap.Command, bootstrap.Args)
+	labels := template.Labels
+
+	var key any = struct {
+		Command []string `json:"command"`
+		Args    []string `json:"args"`
+	}{bootstrap.Command, bootstrap.Args}
+
+	if bootstrap.RunPolicy == "onSpecChange" {
+		key = struct {
+			Generation int64                  `json:"generation"`
+			Template   corev1.PodTemplateSpec `json:"template"`
+		}{app.Generation, template}
+	}
+
+	hash, err := bootstrapHash(key)
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s-bootstrap-%s", app.Name, hash)
+
+	if bootstrap.RunPolicy == "once" {
+		existing, err := lookupJob(app.Namespace, name)
+		switch {
+		case err == nil:
+			template = existing.Spec.Template
+		case k8s.IsErrNotFound(err):
+			// First run.
+		case isLookupDenied(err):
+			report.Warn("BootstrapJobUnchecked", "can't look up bootstrap job, changes to the App may fail to apply until the command changes", "app", app.Name, "job", name, "err", err)
+		default:
+			return nil, fmt.Errorf("failed to look up bootstrap job %s: %w", name, err)
+		}
+	}
+
+	return &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: batchv1.SchemeGroupVersion.Identifier(),
+			Kind:       "Job",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: app.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: bootstrap.BackoffLimit,
+			Template:     template,
+		},
+	}, nil
+}
+
+// bootstrapHash is the first 10 hex characters of the SHA-256 of key as JSON. encoding/json sorts map keys, so
+// the same key always hashes the same.
+func bootstrapHash(key any) (string, error) {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode bootstrap job key: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:10], nil
+}
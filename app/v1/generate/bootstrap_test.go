@@ -0,0 +1,190 @@
+package generate
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+	"github.com/Xe/yoke-stuff/internal/renderreport"
+	"github.com/Xe/yoke-stuff/internal/stub"
+	"github.com/yokecd/yoke/pkg/flight/wasi/k8s"
+)
+
+// bootstrapApp decodes an App with a bootstrap command and the given runPolicy.
+func bootstrapApp(t *testing.T, runPolicy string) v1.App {
+	t.Helper()
+
+	return decode(t, `
+apiVersion: x.within.website/v1
+kind: App
+metadata:
+  name: stickers
+  namespace: default
+  generation: 1
+spec:
+  image: ghcr.io/xe/x/stickers:v1
+  bootstrap:
+    command: ["/app/manage", "create-admin"]
+    runPolicy: `+runPolicy+`
+`)
+}
+
+// bootstrapJob renders the bootstrap Job of app, failing the test on errors.
+func bootstrapJob(t *testing.T, app v1.App) *batchv1.Job {
+	t.Helper()
+
+	job, err := CreateBootstrapJob(app, nil)
+	if err != nil {
+		t.Fatalf("CreateBootstrapJob() failed: %v", err)
+	}
+	return job
+}
+
+func TestBootstrapHash(t *testing.T) {
+	a, err := bootstrapHash(map[string]int{"a": 1, "b": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{10}$`).MatchString(a) {
+		t.Errorf("bootstrapHash() = %q, want 10 hex characters", a)
+	}
+
+	b, _ := bootstrapHash(map[string]int{"b": 2, "a": 1})
+	if a != b {
+		t.Errorf("the same map hashed to %q and %q", a, b)
+	}
+
+	if c, _ := bootstrapHash(map[string]int{"a": 1, "b": 3}); a == c {
+		t.Errorf("different keys both hashed to %q", a)
+	}
+
+	if _, err := bootstrapHash(func() {}); err == nil {
+		t.Error("bootstrapHash() of a value JSON can't encode didn't fail")
+	}
+}
+
+func TestBootstrapJobOnce(t *testing.T) {
+	stub.Set(t, &lookupJob, func(namespace, name string) (*batchv1.Job, error) {
+		return nil, k8s.ErrorNotFound("not found")
+	})
+
+	app := bootstrapApp(t, "once")
+	job := bootstrapJob(t, app)
+
+	if !regexp.MustCompile(`^stickers-bootstrap-[0-9a-f]{10}$`).MatchString(job.Name) {
+		t.Errorf("job is named %q, want stickers-bootstrap-<hash>", job.Name)
+	}
+	if errs := validation.IsDNS1123Subdomain(job.Name); len(errs) != 0 {
+		t.Errorf("job name %q is invalid: %v", job.Name, errs)
+	}
+
+	// Nothing but the command decides the name, so the completed Job is kept.
+	same := app
+	same.Generation = 7
+	same.Spec.Image = "ghcr.io/xe/x/stickers:v2"
+	if got := bootstrapJob(t, same).Name; got != job.Name {
+		t.Errorf("a new generation and image renamed the job from %s to %s", job.Name, got)
+	}
+
+	for name, change := range map[string]func(*v1.App){
+		"command": func(app *v1.App) { app.Spec.Bootstrap.Command = []string{"/app/manage", "migrate"} },
+		"args":    func(app *v1.App) { app.Spec.Bootstrap.Args = []string{"--admin", "mimi"} },
+	} {
+		changed := app
+		bootstrap := *app.Spec.Bootstrap
+		changed.Spec.Bootstrap = &bootstrap
+		change(&changed)
+
+		if got := bootstrapJob(t, changed).Name; got == job.Name {
+			t.Errorf("changing the %s kept the job name %s", name, got)
+		}
+	}
+}
+
+func TestBootstrapJobOnceReusesExistingTemplate(t *testing.T) {
+	app := bootstrapApp(t, "once")
+	name := bootstrapJob(t, app).Name
+
+	existing := corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "bootstrap", Image: "ghcr.io/xe/x/stickers:v1"}},
+		},
+	}
+	stub.Set(t, &lookupJob, func(namespace, jobName string) (*batchv1.Job, error) {
+		if namespace != "default" || jobName != name {
+			t.Errorf("looked up job %s/%s, want default/%s", namespace, jobName, name)
+		}
+		return &batchv1.Job{Spec: batchv1.JobSpec{Template: existing}}, nil
+	})
+
+	// A new image would change the template, which can't be changed on an existing Job.
+	app.Spec.Image = "ghcr.io/xe/x/stickers:v2"
+	job := bootstrapJob(t, app)
+	if job.Name != name {
+		t.Errorf("job is named %s, want %s", job.Name, name)
+	}
+	if got := job.Spec.Template.Spec.Containers[0].Image; got != "ghcr.io/xe/x/stickers:v1" {
+		t.Errorf("job runs %s, want the template of the existing job", got)
+	}
+}
+
+func TestBootstrapJobOnceLookupErrors(t *testing.T) {
+	app := bootstrapApp(t, "once")
+
+	t.Run("forbidden", func(t *testing.T) {
+		stub.Set(t, &lookupJob, func(namespace, name string) (*batchv1.Job, error) {
+			return nil, k8s.ErrorForbidden("forbidden")
+		})
+
+		report := &renderreport.Report{}
+		if _, err := CreateBootstrapJob(app, report); err != nil {
+			t.Fatalf("CreateBootstrapJob() failed: %v", err)
+		}
+		if len(report.Decisions) != 1 || report.Decisions[0].Reason != "BootstrapJobUnchecked" {
+			t.Errorf("got decisions %+v, want BootstrapJobUnchecked", report.Decisions)
+		}
+	})
+
+	t.Run("other", func(t *testing.T) {
+		stub.Set(t, &lookupJob, func(namespace, name string) (*batchv1.Job, error) {
+			return nil, errors.New("connection refused")
+		})
+
+		_, err := CreateBootstrapJob(app, nil)
+		if err == nil || !strings.Contains(err.Error(), "connection refused") {
+			t.Errorf("got error %v, want the lookup error", err)
+		}
+	})
+}
+
+func TestBootstrapJobOnSpecChange(t *testing.T) {
+	stub.Set(t, &lookupJob, func(namespace, name string) (*batchv1.Job, error) {
+		t.Errorf("looked up job %s/%s, onSpecChange never reuses a job", namespace, name)
+		return nil, k8s.ErrorNotFound("not found")
+	})
+
+	app := bootstrapApp(t, "onSpecChange")
+	name := bootstrapJob(t, app).Name
+
+	if got := bootstrapJob(t, app).Name; got != name {
+		t.Errorf("rendering the same App twice named the job %s and %s", name, got)
+	}
+
+	newGeneration := app
+	newGeneration.Generation = 2
+	if got := bootstrapJob(t, newGeneration).Name; got == name {
+		t.Errorf("a new generation kept the job name %s", got)
+	}
+
+	newImage := app
+	newImage.Spec.Image = "ghcr.io/xe/x/stickers:v2"
+	if got := bootstrapJob(t, newImage).Name; got == name {
+		t.Errorf("a new image kept the job name %s", got)
+	}
+}
@@ -0,0 +1,66 @@
+package generate
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+	"github.com/Xe/yoke-stuff/internal/renderreport"
+	"github.com/yokecd/yoke/pkg/flight/wasi/k8s"
+)
+
+// SecretChecksumAnnotationPrefix is the prefix of the pod template annotations that hold the checksum of each
+// of the App's 1Password secrets.
+const SecretChecksumAnnotationPrefix = "x.within.website/secret-checksum-"
+
+// secretChecksums returns a pod template annotation with the checksum of the contents of each of the App's
+// 1Password secrets. When an item changes in 1Password, the operator updates the Secret, the next render changes
+// the annotation, and the pods are rolled to pick up the new contents. Secrets that don't exist yet, as on the
+// first render, get no annotation. docker-registry secrets are skipped, running pods never read them.
+func secretChecksums(app v1.App, report *renderreport.Report) (map[string]string, error) {
+	result := map[string]string{}
+
+	// Sorted so that the render report doesn't depend on the order the secrets are listed in.
+	secrets := slices.SortedFunc(slices.Values(app.Spec.Secrets), func(a, b v1.Secret) int { return cmp.Compare(a.Name, b.Name) })
+	for _, sec := range secrets {
+		if sec.Type == "docker-registry" {
+			continue
+		}
+
+		key := SecretChecksumAnnotationPrefix + sec.Name
+		if errs := validation.IsQualifiedName(key); len(errs) != 0 {
+			report.Warn("SecretChecksumSkipped", "secret name is too long for a checksum annotation, pods won't restart when it changes", "app", app.Name, "secret", sec.Name)
+			continue
+		}
+
+		name := fmt.Sprintf("%s-%s", app.Name, sec.Name)
+		secret, err := lookupSecret(app.Namespace, name)
+		switch {
+		case err == nil:
+		case k8s.IsErrNotFound(err):
+			report.Info("SecretChecksumSkipped", "secret does not exist yet, not adding its checksum", "app", app.Name, "secret", name)
+			continue
+		case isLookupDenied(err):
+			report.Warn("SecretChecksumSkipped", "can't look up secret, pods won't restart when it changes", "app", app.Name, "secret", name, "err", err)
+			continue
+		default:
+			return nil, fmt.Errorf("failed to look up secret %s: %w", name, err)
+		}
+
+		// encoding/json sorts map keys, so the same data always hashes the same.
+		data, err := json.Marshal(secret.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode secret %s: %w", name, err)
+		}
+		sum := sha256.Sum256(data)
+		result[key] = hex.EncodeToString(sum[:])
+	}
+
+	return result, nil
+}
@@ -0,0 +1,97 @@
+package generate
+
+import (
+	"maps"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+)
+
+// CreateCronJob runs a periodic job with the same pod template as the App, minus the parts that only make
+// sense for a long-running server.
+func CreateCronJob(app v1.App, cron v1.Cron) *batchv1.CronJob {
+	template := jobPodTemplate(app, cron.Name, cron.Command, cron.Args)
+	labels := template.Labels
+
+	return &batchv1.CronJob{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: batchv1.SchemeGroupVersion.Identifier(),
+			Kind:       "CronJob",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      app.Name + "-" + cron.Name,
+			Namespace: app.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.CronJobSpec{
+			Schedule:                   cron.Schedule,
+			ConcurrencyPolicy:          batchv1.ConcurrencyPolicy(cron.ConcurrencyPolicy),
+			SuccessfulJobsHistoryLimit: cron.SuccessfulJobsHistoryLimit,
+			FailedJobsHistoryLimit:     ptr.To[int32](1),
+			JobTemplate: batchv1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: batchv1.JobSpec{
+					Template: template,
+				},
+			},
+		},
+	}
+}
+
+// jobPodTemplate is the App's pod template for running a one-off command. It keeps the image, environment,
+// secrets, and volumes, and drops the parts that only make sense for a long-running server (ports and probes).
+func jobPodTemplate(app v1.App, name string, command, args []string) corev1.PodTemplateSpec {
+	// Like the mesh proxy, the Tailscale sidecar keeps running after the command exits.
+	app.Spec.Expose = ""
+	template := CreateDeployment(app).Spec.Template
+
+	// Keep job pods out of the App's Service by dropping the selector labels.
+	labels := maps.Clone(app.Labels)
+	for k := range selector(app) {
+		delete(labels, k)
+	}
+	template.Labels = labels
+	// The mesh proxy keeps running after the command exits, which would keep the Job from ever completing.
+	setMeshInjection(app, &template, false)
+
+	container := &template.Spec.Containers[0]
+	container.Name = name
+	container.Command = command
+	container.Args = args
+	container.Ports = nil
+	container.LivenessProbe = nil
+	container.ReadinessProbe = nil
+	container.Lifecycle = nil
+	template.Spec.RestartPolicy = corev1.RestartPolicyOnFailure
+	// Spread constraints select the App's pods, which job pods are not.
+	template.Spec.TopologySpreadConstraints = nil
+	// Job pods don't serve anything, so they stay off the node's network and don't need a node to themselves.
+	template.Spec.HostNetwork = false
+	template.Spec.DNSPolicy = corev1.DNSPolicy(app.Spec.DNSPolicy)
+	template.Spec.Affinity = nil
+
+	// In statefulset mode the storage PVC belongs to the StatefulSet's pods.
+	if app.Spec.Workload == "statefulset" {
+		var volumes []corev1.Volume
+		for _, volume := range template.Spec.Volumes {
+			if volume.Name != "storage" {
+				volumes = append(volumes, volume)
+			}
+		}
+		template.Spec.Volumes = volumes
+
+		var mounts []corev1.VolumeMount
+		for _, mount := range container.VolumeMounts {
+			if mount.Name != "storage" {
+				mounts = append(mounts, mount)
+			}
+		}
+		container.VolumeMounts = mounts
+	}
+
+	return template
+}
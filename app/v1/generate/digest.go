@@ -0,0 +1,143 @@
+package generate
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+	"github.com/Xe/yoke-stuff/internal/renderreport"
+	"github.com/yokecd/yoke/pkg/flight/wasi/k8s"
+)
+
+// pinnedImage is the image the App runs with pinDigest. The tag in spec.image is resolved to the digest the App's
+// pods pulled for it, and that digest is kept until spec.image changes, so a tag that moves in the registry doesn't
+// change what runs. The first render with a new image uses the tag, since nothing has pulled it yet, and the render
+// after the pods are running pins it.
+func pinnedImage(app v1.App, report *renderreport.Report) string {
+	image := app.Spec.Image
+	if strings.Contains(image, "@") {
+		// Already pinned in the App itself.
+		return image
+	}
+
+	current, err := currentImage(app)
+	switch {
+	case err == nil:
+	case k8s.IsErrNotFound(err):
+		report.Info("PinDigest", "the App isn't deployed yet, using the tag until it is", "app", app.Name, "image", image)
+		return image
+	case isLookupDenied(err):
+		report.Warn("PinDigest", "not allowed to look up the App's workload, check the Airway's clusterAccess and RBAC, using the tag", "app", app.Name, "image", image, "err", err)
+		return image
+	default:
+		report.Warn("PinDigest", "failed to look up the App's workload, using the tag", "app", app.Name, "image", image, "err", err)
+		return image
+	}
+
+	if strings.HasPrefix(current, image+"@sha256:") {
+		return current
+	}
+	if current != image {
+		report.Info("PinDigest", "the image changed, using the tag until the new pods are running", "app", app.Name, "image", image)
+		return image
+	}
+
+	digest, err := runningDigest(app)
+	if err != nil {
+		if isLookupDenied(err) {
+			report.Warn("PinDigest", "not allowed to look up the App's pods, check the Airway's clusterAccess and RBAC, using the tag", "app", app.Name, "image", image, "err", err)
+		} else {
+			report.Warn("PinDigest", "failed to look up the App's pods, using the tag", "app", app.Name, "image", image, "err", err)
+		}
+		return image
+	}
+	if digest == "" {
+		report.Info("PinDigest", "no ready pod runs the image yet, using the tag", "app", app.Name, "image", image)
+		return image
+	}
+
+	report.Info("PinDigest", "pinning the image to the digest its pods run", "app", app.Name, "image", image, "digest", digest)
+	return image + "@" + digest
+}
+
+// currentImage returns the image of the App's container in its Deployment or StatefulSet in the cluster.
+func currentImage(app v1.App) (string, error) {
+	var spec corev1.PodSpec
+	if app.Spec.Workload == "statefulset" {
+		sts, err := lookupStatefulSet(app.Namespace, app.Name)
+		if err != nil {
+			return "", err
+		}
+		spec = sts.Spec.Template.Spec
+	} else {
+		deployment, err := lookupDeployment(app.Namespace, app.Name)
+		if err != nil {
+			return "", err
+		}
+		spec = deployment.Spec.Template.Spec
+	}
+
+	for _, container := range spec.Containers {
+		if container.Name == containerName(app) {
+			return container.Image, nil
+		}
+	}
+	return "", nil
+}
+
+// runningDigest returns the digest of spec.image that a ready pod of the App pulled, or an empty string if no ready
+// pod runs it. Pods are found through the Endpoints of the App's Service, since lookups can't list them.
+func runningDigest(app v1.App) (string, error) {
+	endpoints, err := lookupEndpoints(app.Namespace, app.Name)
+	if err != nil {
+		if k8s.IsErrNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			if address.TargetRef == nil || address.TargetRef.Kind != "Pod" {
+				continue
+			}
+
+			pod, err := lookupPod(app.Namespace, address.TargetRef.Name)
+			if err != nil {
+				if k8s.IsErrNotFound(err) {
+					continue
+				}
+				return "", err
+			}
+
+			if digest := podDigest(app, pod); digest != "" {
+				return digest, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// podDigest returns the digest the App's container in pod was pulled with, if the pod runs spec.image. Pods left
+// over from a rollout can run an older image under the same Service.
+func podDigest(app v1.App, pod *corev1.Pod) string {
+	name := containerName(app)
+
+	for _, container := range pod.Spec.Containers {
+		if container.Name == name && container.Image != app.Spec.Image {
+			return ""
+		}
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != name {
+			continue
+		}
+		// Runtimes that only know the local image ID report it without a repository and digest.
+		if _, digest, ok := strings.Cut(status.ImageID, "@"); ok && strings.HasPrefix(digest, "sha256:") {
+			return digest
+		}
+	}
+	return ""
+}
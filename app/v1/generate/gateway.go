@@ -0,0 +1,185 @@
+package generate
+
+import (
+	"maps"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+	"github.com/Xe/yoke-stuff/internal/renderreport"
+
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	certmanagermetav1 "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1beta1 "sigs.k8s.io/gateway-api/apis/v1beta1"
+)
+
+// CreateRoutes exposes the App through a Gateway API Gateway instead of an Ingress. The Gateway is managed
+// elsewhere, so the App brings its own route and its own Certificate, which cert-manager's ingress-shim would
+// otherwise have created from the Ingress annotation. The Gateway's HTTPS listener has to reference the
+// certificate's Secret. When the Gateway lives in another namespace, a ReferenceGrant lets it. With ingress.tls:
+// false there is no certificate, and the route should attach to a plain HTTP listener. With ingress.tlsSecretName
+// the Secret already exists, so only the ReferenceGrant is created. Settings the Gateway ignores are recorded in
+// report.
+func CreateRoutes(app v1.App, report *renderreport.Report) ([]any, error) {
+	tls := ingressTLS(app)
+	if tls && app.Spec.Ingress.TLSSecretName == "" {
+		if err := checkIngressClusterIssuer(app, report); err != nil {
+			return nil, err
+		}
+	}
+
+	if app.Spec.Ingress.EnableCoreRules {
+		report.Warn("IgnoredCoreRules", "enableCoreRules needs ingress-nginx, ignoring it", "app", app.Name, "gateway", app.Spec.Ingress.Gateway.Name)
+	}
+	if app.Spec.Ingress.RateLimit != nil {
+		report.Warn("IgnoredRateLimit", "rateLimit needs ingress-nginx, ignoring it", "app", app.Name, "gateway", app.Spec.Ingress.Gateway.Name)
+	}
+	if app.Spec.Onion != nil && app.Spec.Onion.Enabled {
+		report.Warn("NoOnionLocation", "the Onion-Location header needs ingress-nginx, not advertising the onion service", "app", app.Name, "gateway", app.Spec.Ingress.Gateway.Name)
+	}
+
+	result := []any{createRoute(app)}
+	if !tls {
+		return result, nil
+	}
+
+	if app.Spec.Ingress.TLSSecretName == "" {
+		result = append(result, createCertificate(app))
+	}
+	if namespace := app.Spec.Ingress.Gateway.Namespace; namespace != "" && namespace != app.Namespace {
+		result = append(result, createCertificateReferenceGrant(app))
+	}
+
+	return result, nil
+}
+
+// createRoute creates a GRPCRoute for gRPC Apps and an HTTPRoute for everything else, pointing at the HTTP port
+// of the App's Service.
+func createRoute(app v1.App) any {
+	gateway := app.Spec.Ingress.Gateway
+
+	parent := gatewayv1.ParentReference{
+		Name: gatewayv1.ObjectName(gateway.Name),
+	}
+	if gateway.Namespace != "" {
+		parent.Namespace = ptr.To(gatewayv1.Namespace(gateway.Namespace))
+	}
+	if gateway.SectionName != "" {
+		parent.SectionName = ptr.To(gatewayv1.SectionName(gateway.SectionName))
+	}
+
+	common := gatewayv1.CommonRouteSpec{
+		ParentRefs: []gatewayv1.ParentReference{parent},
+	}
+	hostnames := []gatewayv1.Hostname{gatewayv1.Hostname(app.Spec.Ingress.Host)}
+	backend := gatewayv1.BackendRef{
+		BackendObjectReference: gatewayv1.BackendObjectReference{
+			Name: gatewayv1.ObjectName(app.Name),
+			Port: ptr.To[gatewayv1.PortNumber](80),
+		},
+	}
+
+	meta := metav1.ObjectMeta{
+		Name:        app.Name,
+		Namespace:   app.Namespace,
+		Labels:      app.Labels,
+		Annotations: map[string]string{},
+	}
+	maps.Copy(meta.Annotations, externalDNSAnnotations(app))
+	maps.Copy(meta.Annotations, app.Spec.Ingress.Annotations)
+
+	if app.Spec.Ingress.Kind == "grpc" {
+		return &gatewayv1.GRPCRoute{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: gatewayv1.GroupVersion.String(),
+				Kind:       "GRPCRoute",
+			},
+			ObjectMeta: meta,
+			Spec: gatewayv1.GRPCRouteSpec{
+				CommonRouteSpec: common,
+				Hostnames:       hostnames,
+				Rules: []gatewayv1.GRPCRouteRule{
+					{
+						BackendRefs: []gatewayv1.GRPCBackendRef{{BackendRef: backend}},
+					},
+				},
+			},
+		}
+	}
+
+	return &gatewayv1.HTTPRoute{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: gatewayv1.GroupVersion.String(),
+			Kind:       "HTTPRoute",
+		},
+		ObjectMeta: meta,
+		Spec: gatewayv1.HTTPRouteSpec{
+			CommonRouteSpec: common,
+			Hostnames:       hostnames,
+			Rules: []gatewayv1.HTTPRouteRule{
+				{
+					BackendRefs: []gatewayv1.HTTPBackendRef{{BackendRef: backend}},
+				},
+			},
+		},
+	}
+}
+
+// createCertificate requests the certificate that an Ingress would have gotten from ingress-shim, in the same
+// Secret.
+func createCertificate(app v1.App) *certmanagerv1.Certificate {
+	return &certmanagerv1.Certificate{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: certmanagerv1.SchemeGroupVersion.Identifier(),
+			Kind:       "Certificate",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      app.Name,
+			Namespace: app.Namespace,
+			Labels:    app.Labels,
+		},
+		Spec: certmanagerv1.CertificateSpec{
+			SecretName: mkTLSSecretName(app),
+			DNSNames:   []string{app.Spec.Ingress.Host},
+			IssuerRef: certmanagermetav1.ObjectReference{
+				Name:  app.Spec.Ingress.ClusterIssuer,
+				Kind:  "ClusterIssuer",
+				Group: "cert-manager.io",
+			},
+		},
+	}
+}
+
+// createCertificateReferenceGrant lets Gateways in the Gateway's namespace read the certificate's Secret.
+func createCertificateReferenceGrant(app v1.App) *gatewayv1beta1.ReferenceGrant {
+	return &gatewayv1beta1.ReferenceGrant{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: gatewayv1beta1.GroupVersion.String(),
+			Kind:       "ReferenceGrant",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      app.Name + "-gateway-tls",
+			Namespace: app.Namespace,
+			Labels:    app.Labels,
+		},
+		Spec: gatewayv1beta1.ReferenceGrantSpec{
+			From: []gatewayv1beta1.ReferenceGrantFrom{
+				{
+					Group:     gatewayv1.GroupName,
+					Kind:      "Gateway",
+					Namespace: gatewayv1.Namespace(app.Spec.Ingress.Gateway.Namespace),
+				},
+			},
+			To: []gatewayv1beta1.ReferenceGrantTo{
+				{
+					Group: corev1.GroupName,
+					Kind:  "Secret",
+					Name:  ptr.To(gatewayv1.ObjectName(mkTLSSecretName(app))),
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,1854 @@
+// Package generate renders the Kubernetes objects of an App. It is what the App flight runs, importable so that
+// other flights and tools can render an App, or parts of one, without shelling out to the flight.
+//
+// Generate renders the whole App. The Create functions render one object each, and expect an App that has been
+// through the same defaults as in Generate: a port, and labels that include the App's selector.
+package generate
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/utils/ptr"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+	"github.com/Xe/yoke-stuff/internal/podmeta"
+	"github.com/Xe/yoke-stuff/internal/renderreport"
+	"github.com/yokecd/yoke/pkg/flight/wasi/k8s"
+
+	onepasswordv1 "github.com/1Password/onepassword-operator/api/v1"
+	onionv1alpha2 "github.com/bugfest/tor-controller/apis/tor/v1alpha2"
+)
+
+// Generate renders everything the App needs, the same way the flight does. Its render report is written to the
+// Deployment or StatefulSet.
+func Generate(app v1.App) ([]any, error) {
+	// Collects the decisions of this render, so that concurrent renders each get their own.
+	report := &renderreport.Report{}
+
+	// Configure some sane defaults
+	app.Spec.Port = cmp.Or(app.Spec.Port, 3000)
+
+	instance := app.Name
+
+	if app.Spec.NameSuffix != "" {
+		applyNameSuffix(&app)
+	}
+
+	// Make sure that our labels include our custom selector. The labels are copied so that the caller's App is
+	// left alone.
+	app.Labels = maps.Clone(app.Labels)
+	if app.Labels == nil {
+		app.Labels = map[string]string{}
+	}
+	maps.Copy(app.Labels, selector(app))
+	maps.Copy(app.Labels, standardLabels(app, instance))
+
+	if app.Spec.StrictReferences {
+		if err := checkReferences(app, report); err != nil {
+			return nil, err
+		}
+		if err := checkEnvCollisions(app, report); err != nil {
+			return nil, err
+		}
+	}
+
+	if app.Spec.ResourcePolicy != nil && app.Spec.ResourcePolicy.Quota != "" {
+		if err := checkQuota(app, report); err != nil {
+			return nil, err
+		}
+	}
+
+	// After the labels, so that the version label still comes from the tag.
+	if app.Spec.PinDigest {
+		app.Spec.Image = pinnedImage(app, report)
+	}
+
+	var result []any
+
+	for _, sec := range app.Spec.Secrets {
+		result = append(result, component("secrets", CreateOnepasswordSecret(app, sec))...)
+	}
+
+	var configmaps []any
+	for _, cm := range app.Spec.ConfigMaps {
+		configmaps = append(configmaps, component("config", CreateConfigMap(app, cm))...)
+	}
+	if len(configmaps) != 0 {
+		result = append(result, configmaps...)
+	}
+
+	var pvcs []any
+	for _, pvc := range app.Spec.Volumes {
+		pvcs = append(pvcs, component("storage", CreatePVC(app, pvc))...)
+	}
+	if len(pvcs) != 0 {
+		result = append(result, pvcs...)
+	}
+
+	// Switching workload kinds drops the old Deployment or StatefulSet from the output and yoke prunes it.
+	// The storage PVC is not carried over: a StatefulSet claims storage-<name>-<ordinal> from its
+	// volumeClaimTemplates instead of <name>-storage, so data has to be copied over by hand.
+	if app.Spec.Suspend {
+		report.Info("Suspended", "the App is suspended, scaling it to zero", "app", app.Name, "replicas", ptr.Deref(app.Spec.Replicas, 1))
+	}
+
+	var workload *metav1.ObjectMeta
+	var template *corev1.PodTemplateSpec
+	if app.Spec.Workload == "statefulset" {
+		statefulSet := CreateStatefulSet(app)
+		workload = &statefulSet.ObjectMeta
+		template = &statefulSet.Spec.Template
+		result = append(result, component("server", statefulSet)...)
+	} else {
+		deployment := CreateDeployment(app)
+		workload = &deployment.ObjectMeta
+		template = &deployment.Spec.Template
+		result = append(result, component("server", deployment)...)
+
+		if volumes := singleAttachVolumes(app); len(volumes) != 0 && deployment.Spec.Strategy.Type == appsv1.RollingUpdateDeploymentStrategyType {
+			report.Warn("RollingUpdateWithSingleAttachVolume", "rolling updates can hang when the new pod can't mount a ReadWriteOnce volume the old pod holds", "app", app.Name, "volumes", strings.Join(volumes, ","))
+		}
+	}
+
+	templates := []*corev1.PodTemplateSpec{template}
+	if app.Spec.Canary != nil && app.Spec.Canary.Enabled {
+		slog.Info("creating canary for", "app", app.Name, "image", app.Spec.Canary.Image)
+		canary := CreateCanaryDeployment(app)
+		templates = append(templates, &canary.Spec.Template)
+		result = append(result, component("server", canary)...)
+
+		if volumes := singleAttachVolumes(app); len(volumes) != 0 {
+			report.Warn("CanaryWithSingleAttachVolume", "the canary pod can't start while another pod holds a ReadWriteOnce volume", "app", app.Name, "volumes", strings.Join(volumes, ","))
+		}
+	}
+
+	checksums, err := secretChecksums(app, report)
+	if err != nil {
+		return nil, err
+	}
+	if len(checksums) != 0 {
+		for _, template := range templates {
+			if template.Annotations == nil {
+				template.Annotations = map[string]string{}
+			}
+			maps.Copy(template.Annotations, checksums)
+		}
+	}
+
+	result = append(result, component("server", CreateService(app))...)
+
+	// StatefulSets require a governing headless Service.
+	if (app.Spec.Service != nil && app.Spec.Service.AlsoHeadless) || app.Spec.Workload == "statefulset" {
+		result = append(result, component("server", CreateHeadlessService(app))...)
+	}
+
+	result = append(result, component("server", CreateServiceAccount(app))...)
+
+	ingress := app.Spec.Ingress != nil && app.Spec.Ingress.Enabled
+	if app.Spec.Expose == "tailscale" {
+		slog.Info("creating tailscale serve config for", "app", app.Name, "hostname", tailscaleHostname(app))
+		result = append(result, component("tailscale", CreateConfigMap(app, TailscaleServeConfig(app)))...)
+
+		if ingress {
+			report.Warn("IgnoredIngress", "the App is only exposed on the tailnet, not creating its ingress", "app", app.Name)
+			ingress = false
+		}
+		if replicas(app) > 1 {
+			report.Warn("TailscaleReplicas", "every pod joins the tailnet as its own machine, only one of them gets the hostname", "app", app.Name, "hostname", tailscaleHostname(app))
+		}
+	}
+
+	if ingress {
+		if app.Spec.Ingress.Gateway != nil {
+			slog.Info("creating gateway routes for", "app", app.Name, "gateway", app.Spec.Ingress.Gateway.Name)
+			routes, err := CreateRoutes(app, report)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create gateway routes: %w", err)
+			}
+			result = append(result, component("ingress", routes...)...)
+		} else {
+			slog.Info("creating ingress for", "app", app.Name)
+			ing, err := CreateIngress(app, report)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create ingress: %w", err)
+			}
+			result = append(result, component("ingress", ing)...)
+
+			if app.Spec.Ingress.BasicAuth != nil {
+				result = append(result, component("ingress", CreateOnepasswordSecret(app, BasicAuthSecret(app)))...)
+			}
+			if len(app.Spec.Ingress.Allowlist) != 0 && ingressController(app) == "traefik" {
+				result = append(result, component("ingress", CreateAllowlistMiddleware(app))...)
+			}
+		}
+	}
+
+	if app.Spec.Onion != nil && app.Spec.Onion.Enabled {
+		slog.Info("creating onion service for", "app", app.Name)
+		result = append(result, component("onion", CreateOnion(app))...)
+	}
+
+	if app.Spec.Storage != nil && app.Spec.Storage.Enabled && app.Spec.Workload != "statefulset" {
+		slog.Info("creating storage for", "app", app.Name)
+		result = append(result, component("storage", CreateStorage(app))...)
+	}
+
+	if app.Spec.Role != nil {
+		if len(app.Spec.Role.NamespacedRules()) != 0 {
+			slog.Info("creating role for", "app", app.Name)
+			result = append(result, component("rbac", CreateRole(app), CreateRoleBinding(app))...)
+		}
+
+		if len(app.Spec.Role.ClusterWideRules()) != 0 {
+			slog.Info("creating cluster role for", "app", app.Name)
+			result = append(result, component("rbac", CreateClusterRole(app), CreateClusterRoleBinding(app))...)
+		}
+	}
+
+	for _, cron := range app.Spec.Crons {
+		slog.Info("creating cronjob for", "app", app.Name, "cron", cron.Name, "schedule", cron.Schedule)
+		result = append(result, component("cron", CreateCronJob(app, cron))...)
+	}
+
+	if alerts := app.Spec.Alerts; alerts != nil && (alerts.Defaults || len(alerts.Rules) != 0) {
+		slog.Info("creating alerts for", "app", app.Name)
+		result = append(result, component("alerts", CreatePrometheusRule(app))...)
+	}
+
+	if app.Spec.Bootstrap != nil {
+		slog.Info("creating bootstrap job for", "app", app.Name, "runPolicy", app.Spec.Bootstrap.RunPolicy)
+		job, err := CreateBootstrapJob(app, report)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create bootstrap job: %w", err)
+		}
+		result = append(result, component("bootstrap", job)...)
+	}
+
+	if app.Spec.RestartPolicy != nil {
+		slog.Info("creating scheduled restarts for", "app", app.Name, "schedule", restartSchedule(app))
+		result = append(result, component("restart", CreateRestartServiceAccount(app), CreateRestartRole(app), CreateRestartRoleBinding(app), CreateRestartCronJob(app))...)
+	}
+
+	report.Annotate(workload.Annotations)
+
+	sortObjects(result)
+
+	return result, nil
+}
+
+// sortObjects sorts objs by apiVersion, kind, namespace, and name, so that the same App renders the same way no
+// matter which settings it uses or in which order its secrets and volumes are listed.
+func sortObjects(objs []any) {
+	type object interface {
+		GetObjectKind() schema.ObjectKind
+		GetNamespace() string
+		GetName() string
+	}
+
+	key := func(obj any) []string {
+		o, ok := obj.(object)
+		if !ok {
+			return nil
+		}
+		apiVersion, kind := o.GetObjectKind().GroupVersionKind().ToAPIVersionAndKind()
+		return []string{apiVersion, kind, o.GetNamespace(), o.GetName()}
+	}
+
+	slices.SortStableFunc(objs, func(a, b any) int {
+		return slices.Compare(key(a), key(b))
+	})
+}
+
+// CreateDeployment runs the App as a Deployment.
+func CreateDeployment(backend v1.App) *appsv1.Deployment {
+	result := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: appsv1.SchemeGroupVersion.Identifier(),
+			Kind:       "Deployment",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        backend.Name,
+			Namespace:   backend.Namespace,
+			Labels:      backend.Labels,
+			Annotations: map[string]string{},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas:                ptr.To(replicas(backend)),
+			RevisionHistoryLimit:    ptr.To(ptr.Deref(backend.Spec.RevisionHistoryLimit, 3)),
+			ProgressDeadlineSeconds: ptr.To(ptr.Deref(backend.Spec.ProgressDeadlineSeconds, 600)),
+			MinReadySeconds:         ptr.Deref(backend.Spec.MinReadySeconds, 0),
+			Strategy: appsv1.DeploymentStrategy{
+				Type: appsv1.RollingUpdateDeploymentStrategyType,
+			},
+			Selector: &metav1.LabelSelector{MatchLabels: selector(backend)},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      backend.Labels,
+					Annotations: podmeta.Merge(backend.Spec.PodAnnotations),
+				},
+				Spec: corev1.PodSpec{
+					SecurityContext: &corev1.PodSecurityContext{
+						FSGroup: ptr.To[int64](1000),
+					},
+					ServiceAccountName:            serviceAccountName(backend),
+					AutomountServiceAccountToken:  ptr.To(backend.Spec.AutomountToken()),
+					PriorityClassName:             backend.Spec.PriorityClassName,
+					TerminationGracePeriodSeconds: backend.Spec.TerminationGracePeriodSeconds,
+					DNSPolicy:                     corev1.DNSPolicy(backend.Spec.DNSPolicy),
+					DNSConfig:                     backend.Spec.DNSConfig,
+					Containers: []corev1.Container{
+						{
+							Name:            containerName(backend),
+							Image:           backend.Spec.Image,
+							ImagePullPolicy: corev1.PullPolicy(cmp.Or(backend.Spec.ImagePullPolicy, "Always")),
+							SecurityContext: &corev1.SecurityContext{
+								RunAsUser:                ptr.To[int64](1000),
+								RunAsGroup:               ptr.To[int64](1000),
+								RunAsNonRoot:             ptr.To(true),
+								AllowPrivilegeEscalation: ptr.To(false),
+								Capabilities: &corev1.Capabilities{
+									Drop: []corev1.Capability{"ALL"},
+								},
+								SeccompProfile: &corev1.SeccompProfile{
+									Type: corev1.SeccompProfileTypeRuntimeDefault,
+								},
+							},
+							Env: []corev1.EnvVar{
+								{
+									Name:  "PORT",
+									Value: strconv.Itoa(backend.Spec.Port),
+								},
+								{
+									Name:  "BIND",
+									Value: fmt.Sprintf(":%d", backend.Spec.Port),
+								},
+								{
+									Name: "SLOG_LEVEL",
+									Value: cmp.Or(
+										backend.Spec.LogLevel,
+										"info",
+									),
+								},
+							},
+							Ports: containerPorts(backend),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if autoUpdate := backend.Spec.AutoUpdate; autoUpdate != nil && autoUpdate.Enabled {
+		maps.Copy(result.Annotations, map[string]string{
+			"keel.sh/policy":       autoUpdate.Policy,
+			"keel.sh/trigger":      autoUpdate.Trigger,
+			"keel.sh/pollSchedule": autoUpdate.PollSchedule,
+		})
+	}
+
+	maps.Copy(result.Annotations, backend.Spec.DeploymentAnnotations)
+
+	result.Spec.Strategy.Type = deploymentStrategy(backend)
+	if backend.Spec.Strategy != nil && result.Spec.Strategy.Type == appsv1.RollingUpdateDeploymentStrategyType {
+		result.Spec.Strategy.RollingUpdate = &appsv1.RollingUpdateDeployment{
+			MaxSurge:       backend.Spec.Strategy.MaxSurge,
+			MaxUnavailable: backend.Spec.Strategy.MaxUnavailable,
+		}
+	}
+
+	if backend.Spec.HostNetwork {
+		useHostNetwork(backend, &result.Spec.Template.Spec)
+
+		// A surge pod has nowhere to go when every node already runs one, so rolling updates replace pods one at
+		// a time instead.
+		tuned := backend.Spec.Strategy != nil && (backend.Spec.Strategy.MaxSurge != nil || backend.Spec.Strategy.MaxUnavailable != nil)
+		if result.Spec.Strategy.Type == appsv1.RollingUpdateDeploymentStrategyType && !tuned {
+			result.Spec.Strategy.RollingUpdate = &appsv1.RollingUpdateDeployment{
+				MaxSurge:       ptr.To(intstr.FromInt32(0)),
+				MaxUnavailable: ptr.To(intstr.FromInt32(1)),
+			}
+		}
+	}
+
+	if shutdown := backend.Spec.GracefulShutdown; shutdown != nil && shutdown.Enabled {
+		// Endpoints are removed while the hook sleeps, SIGTERM comes after it. The sleep counts against the
+		// grace period, so the App still gets the full default to shut down afterwards.
+		result.Spec.Template.Spec.Containers[0].Lifecycle = &corev1.Lifecycle{
+			PreStop: &corev1.LifecycleHandler{
+				Exec: &corev1.ExecAction{
+					Command: []string{"sleep", strconv.Itoa(int(shutdown.DelaySeconds))},
+				},
+			},
+		}
+
+		grace := corev1.DefaultTerminationGracePeriodSeconds + int64(shutdown.DelaySeconds)
+		result.Spec.Template.Spec.TerminationGracePeriodSeconds = ptr.To(max(grace, ptr.Deref(backend.Spec.TerminationGracePeriodSeconds, 0)))
+	}
+
+	// PORT, BIND, and SLOG_LEVEL are only defaults, the App's own env wins.
+	result.Spec.Template.Spec.Containers[0].Env = mergeEnv(result.Spec.Template.Spec.Containers[0].Env, backend.Spec.Env...)
+
+	if backend.Spec.Resources != nil {
+		for i := range result.Spec.Template.Spec.Containers {
+			result.Spec.Template.Spec.Containers[i].Resources = corev1.ResourceRequirements{
+				Requests: backend.Spec.Resources.Requests,
+				Limits:   backend.Spec.Resources.Limits,
+			}
+		}
+	}
+
+	if backend.Spec.RuntimeClassName != "" {
+		result.Spec.Template.Spec.RuntimeClassName = ptr.To(backend.Spec.RuntimeClassName)
+	}
+	result.Spec.Template.Spec.ShareProcessNamespace = backend.Spec.ShareProcessNamespace
+	result.Spec.Template.Spec.Tolerations = extendedResourceTolerations(backend)
+
+	for _, imagePullSecret := range backend.Spec.ImagePullSecrets {
+		result.Spec.Template.Spec.ImagePullSecrets = append(result.Spec.Template.Spec.ImagePullSecrets, corev1.LocalObjectReference{
+			Name: imagePullSecret,
+		})
+	}
+
+	for _, sec := range backend.Spec.Secrets {
+		if sec.Type == "docker-registry" {
+			result.Spec.Template.Spec.ImagePullSecrets = append(result.Spec.Template.Spec.ImagePullSecrets, corev1.LocalObjectReference{
+				Name: fmt.Sprintf("%s-%s", backend.Name, sec.Name),
+			})
+		}
+	}
+
+	if backend.Spec.Healthcheck != nil && backend.Spec.Healthcheck.Enabled {
+		// Copied before defaulting the port, the Healthcheck is shared with the caller's App.
+		healthcheck := *backend.Spec.Healthcheck
+		healthcheck.Port = cmp.Or(healthcheck.Port, backend.Spec.Port)
+		backend.Spec.Healthcheck = &healthcheck
+
+		switch backend.Spec.Healthcheck.Kind {
+		case "http":
+			result.Spec.Template.Spec.Containers[0].LivenessProbe = &corev1.Probe{
+				InitialDelaySeconds: 3,
+				PeriodSeconds:       10,
+				ProbeHandler: corev1.ProbeHandler{
+					HTTPGet: &corev1.HTTPGetAction{
+						Path:        backend.Spec.Healthcheck.Path,
+						Port:        intstr.FromInt(backend.Spec.Healthcheck.Port),
+						HTTPHeaders: probeHeaders(backend.Spec.Healthcheck),
+					},
+				},
+			}
+			result.Spec.Template.Spec.Containers[0].ReadinessProbe = &corev1.Probe{
+				InitialDelaySeconds: 3,
+				PeriodSeconds:       10,
+				ProbeHandler: corev1.ProbeHandler{
+					HTTPGet: &corev1.HTTPGetAction{
+						Path:        backend.Spec.Healthcheck.Path,
+						Port:        intstr.FromInt(backend.Spec.Healthcheck.Port),
+						HTTPHeaders: probeHeaders(backend.Spec.Healthcheck),
+					},
+				},
+			}
+		case "grpc":
+			result.Spec.Template.Spec.Containers[0].LivenessProbe = &corev1.Probe{
+				InitialDelaySeconds: 3,
+				PeriodSeconds:       10,
+				ProbeHandler: corev1.ProbeHandler{
+					GRPC: &corev1.GRPCAction{
+						Port: int32(backend.Spec.Healthcheck.Port),
+					},
+				},
+			}
+			result.Spec.Template.Spec.Containers[0].ReadinessProbe = &corev1.Probe{
+				InitialDelaySeconds: 0,
+				PeriodSeconds:       10,
+				ProbeHandler: corev1.ProbeHandler{
+					GRPC: &corev1.GRPCAction{
+						Port: int32(backend.Spec.Healthcheck.Port),
+					},
+				},
+			}
+		}
+	}
+
+	if sc := backend.Spec.SecurityContext; sc != nil {
+		podSecurityContext := result.Spec.Template.Spec.SecurityContext
+		podSecurityContext.FSGroup = cmp.Or(sc.FSGroup, podSecurityContext.FSGroup)
+
+		securityContext := result.Spec.Template.Spec.Containers[0].SecurityContext
+		securityContext.RunAsUser = cmp.Or(sc.RunAsUser, securityContext.RunAsUser)
+		securityContext.RunAsGroup = cmp.Or(sc.RunAsGroup, securityContext.RunAsGroup)
+		if sc.ReadOnlyRootFilesystem {
+			securityContext.ReadOnlyRootFilesystem = ptr.To(true)
+		}
+	}
+
+	if backend.Spec.RunAsRoot {
+		for i := range result.Spec.Template.Spec.Containers {
+			result.Spec.Template.Spec.Containers[i].SecurityContext = nil
+		}
+		result.Spec.Template.Spec.SecurityContext = nil
+	}
+
+	// These come first so that the App's own 1Password secrets win when both set a variable.
+	for _, name := range backend.Spec.EnvFromConfigMaps {
+		result.Spec.Template.Spec.Containers[0].EnvFrom = append(result.Spec.Template.Spec.Containers[0].EnvFrom, corev1.EnvFromSource{
+			ConfigMapRef: &corev1.ConfigMapEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+			},
+		})
+	}
+	for _, name := range backend.Spec.EnvFromSecrets {
+		result.Spec.Template.Spec.Containers[0].EnvFrom = append(result.Spec.Template.Spec.Containers[0].EnvFrom, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+			},
+		})
+	}
+
+	for _, sec := range backend.Spec.Secrets {
+		name := fmt.Sprintf("%s-%s", backend.Name, sec.Name)
+
+		if sec.Environment {
+			result.Spec.Template.Spec.Containers[0].EnvFrom = append(result.Spec.Template.Spec.Containers[0].EnvFrom, corev1.EnvFromSource{
+				Prefix: sec.EnvPrefix,
+				SecretRef: &corev1.SecretEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: name},
+					Optional:             optional(sec),
+				},
+			})
+		}
+
+		for _, envName := range slices.Sorted(maps.Keys(sec.EnvMap)) {
+			result.Spec.Template.Spec.Containers[0].Env = mergeEnv(result.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{
+				Name: envName,
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: name},
+						Key:                  sec.EnvMap[envName],
+						Optional:             optional(sec),
+					},
+				},
+			})
+		}
+
+		if sec.Folder {
+			var items []corev1.KeyToPath
+			for _, item := range sec.Items {
+				items = append(items, corev1.KeyToPath{Key: item.Key, Path: item.Path, Mode: item.Mode})
+			}
+
+			result.Spec.Template.Spec.Volumes = append(result.Spec.Template.Spec.Volumes, corev1.Volume{
+				Name: "secret-" + sec.Name,
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{
+						SecretName: name,
+						Items:      items,
+						Optional:   optional(sec),
+					},
+				},
+			})
+
+			result.Spec.Template.Spec.Containers[0].VolumeMounts = append(result.Spec.Template.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+				Name:      "secret-" + sec.Name,
+				MountPath: sec.MountPath,
+			})
+		}
+	}
+
+	if backend.Spec.Storage != nil && backend.Spec.Storage.Enabled {
+		result.Spec.Template.Spec.Volumes = append(result.Spec.Template.Spec.Volumes, corev1.Volume{
+			Name: "storage",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: backend.Name + "-storage",
+				},
+			},
+		})
+
+		result.Spec.Template.Spec.Containers[0].VolumeMounts = append(result.Spec.Template.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "storage",
+			MountPath: backend.Spec.Storage.Path,
+		})
+	}
+
+	result.Spec.Template.Spec.TopologySpreadConstraints = topologySpreadConstraints(backend)
+
+	for _, pvc := range backend.Spec.Volumes {
+		result.Spec.Template.Spec.Volumes = append(result.Spec.Template.Spec.Volumes, corev1.Volume{
+			Name: "pvc-" + pvc.Name,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: backend.Name + "-" + pvc.Name,
+				},
+			},
+		})
+
+		result.Spec.Template.Spec.Containers[0].VolumeMounts = append(result.Spec.Template.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "pvc-" + pvc.Name,
+			MountPath: pvc.Path,
+		})
+	}
+
+	for _, scratch := range backend.Spec.Scratch {
+		var sizeLimit *resource.Quantity
+		if scratch.SizeLimit != "" {
+			sizeLimit = ptr.To(resource.MustParse(scratch.SizeLimit))
+		}
+
+		result.Spec.Template.Spec.Volumes = append(result.Spec.Template.Spec.Volumes, corev1.Volume{
+			Name: "scratch-" + scratch.Name,
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{
+					Medium:    corev1.StorageMedium(scratch.Medium),
+					SizeLimit: sizeLimit,
+				},
+			},
+		})
+
+		result.Spec.Template.Spec.Containers[0].VolumeMounts = append(result.Spec.Template.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "scratch-" + scratch.Name,
+			MountPath: scratch.Path,
+		})
+	}
+
+	if backend.Spec.TmpVolume() {
+		result.Spec.Template.Spec.Volumes = append(result.Spec.Template.Spec.Volumes, corev1.Volume{
+			Name:         "tmp",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+
+		result.Spec.Template.Spec.Containers[0].VolumeMounts = append(result.Spec.Template.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "tmp",
+			MountPath: "/tmp",
+		})
+	}
+
+	for _, cm := range backend.Spec.ConfigMaps {
+		result.Spec.Template.Spec.Volumes = append(result.Spec.Template.Spec.Volumes, corev1.Volume{
+			Name: "cm-" + cm.Name,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: backend.Name + "-" + cm.GenName(),
+					},
+				},
+			},
+		})
+
+		result.Spec.Template.Spec.Containers[0].VolumeMounts = append(result.Spec.Template.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "cm-" + cm.Name,
+			MountPath: cm.Folder,
+		})
+	}
+
+	if sa := backend.Spec.ServiceAccount; sa != nil && sa.TokenProjection != nil {
+		result.Spec.Template.Spec.Volumes = append(result.Spec.Template.Spec.Volumes, corev1.Volume{
+			Name: "sa-token",
+			VolumeSource: corev1.VolumeSource{
+				Projected: &corev1.ProjectedVolumeSource{
+					Sources: []corev1.VolumeProjection{
+						{
+							ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+								Audience:          sa.TokenProjection.Audience,
+								ExpirationSeconds: ptr.To(ptr.Deref(sa.TokenProjection.ExpirationSeconds, 3600)),
+								Path:              "token",
+							},
+						},
+					},
+				},
+			},
+		})
+
+		result.Spec.Template.Spec.Containers[0].VolumeMounts = append(result.Spec.Template.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "sa-token",
+			MountPath: sa.TokenProjection.MountPath,
+			ReadOnly:  true,
+		})
+	}
+
+	result.Spec.Template.Spec.Volumes = append(result.Spec.Template.Spec.Volumes, backend.Spec.ExtraVolumes...)
+	result.Spec.Template.Spec.Containers[0].VolumeMounts = append(result.Spec.Template.Spec.Containers[0].VolumeMounts, backend.Spec.ExtraVolumeMounts...)
+
+	if backend.Spec.Expose == "tailscale" {
+		addTailscaleSidecar(backend, &result.Spec.Template.Spec)
+	}
+
+	setMeshInjection(backend, &result.Spec.Template, true)
+
+	return result
+}
+
+// optional marks the references to an optional secret, so pods don't wait for it to be synced. Required secrets
+// leave Optional unset, as they always have.
+func optional(sec v1.Secret) *bool {
+	if !sec.Optional {
+		return nil
+	}
+	return ptr.To(true)
+}
+
+// probeHeaders are the HTTP headers of the App's probes. Without any set they send the X-Kubernetes header the
+// probes have always sent.
+func probeHeaders(healthcheck *v1.Healthcheck) []corev1.HTTPHeader {
+	if len(healthcheck.Headers) == 0 {
+		return []corev1.HTTPHeader{
+			{
+				Name:  "X-Kubernetes",
+				Value: "is kinda okay",
+			},
+		}
+	}
+
+	var result []corev1.HTTPHeader
+	for _, name := range slices.Sorted(maps.Keys(healthcheck.Headers)) {
+		result = append(result, corev1.HTTPHeader{Name: name, Value: healthcheck.Headers[name]})
+	}
+	return result
+}
+
+// CreateCanaryDeployment runs one pod of the canary image as <app>-canary. It is the App's Deployment with another
+// image, so it has the same environment, secrets, and probes, and its pods have the labels the App's Service
+// selects, so they get a share of the traffic. The App's Deployment doesn't adopt them, since their ReplicaSet
+// already belongs to the canary.
+func CreateCanaryDeployment(app v1.App) *appsv1.Deployment {
+	canary := app
+	canary.Spec.Image = app.Spec.Canary.Image
+	// Keel would move the canary along with the App's image.
+	canary.Spec.AutoUpdate = nil
+	// A second sidecar would join the tailnet as another machine instead of sharing the App's traffic.
+	canary.Spec.Expose = ""
+
+	labels := maps.Clone(app.Labels)
+	delete(labels, versionLabel)
+	if version := imageVersion(canary.Spec.Image); version != "" {
+		labels[versionLabel] = version
+	}
+	labels[trackLabel] = "canary"
+	canary.Labels = labels
+
+	result := CreateDeployment(canary)
+	result.Name = app.Name + "-canary"
+	result.Spec.Selector.MatchLabels[trackLabel] = "canary"
+	result.Spec.Replicas = ptr.To(min(replicas(app), 1))
+
+	return result
+}
+
+// CreateStatefulSet runs the same pod template as CreateDeployment, but the storage volume comes from a
+// volumeClaimTemplate so every replica gets its own PVC and rolling updates never wait on a RWO volume.
+func CreateStatefulSet(backend v1.App) *appsv1.StatefulSet {
+	deployment := CreateDeployment(backend)
+
+	result := &appsv1.StatefulSet{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: appsv1.SchemeGroupVersion.Identifier(),
+			Kind:       "StatefulSet",
+		},
+		ObjectMeta: deployment.ObjectMeta,
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:             deployment.Spec.Replicas,
+			RevisionHistoryLimit: deployment.Spec.RevisionHistoryLimit,
+			MinReadySeconds:      deployment.Spec.MinReadySeconds,
+			Selector:             deployment.Spec.Selector,
+			ServiceName:          backend.Name + "-headless",
+			Template:             deployment.Spec.Template,
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+				Type: appsv1.RollingUpdateStatefulSetStrategyType,
+			},
+		},
+	}
+
+	if backend.Spec.Storage != nil && backend.Spec.Storage.Enabled {
+		var volumes []corev1.Volume
+		for _, volume := range result.Spec.Template.Spec.Volumes {
+			if volume.Name == "storage" {
+				continue
+			}
+			volumes = append(volumes, volume)
+		}
+		result.Spec.Template.Spec.Volumes = volumes
+
+		pvc := CreateStorage(backend)
+		result.Spec.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "storage",
+					// volumeClaimTemplates can't be changed, so they keep the labels they had before the version
+					// and other recommended labels were added.
+					Labels: withoutStandardLabels(backend.Labels),
+				},
+				Spec: pvc.Spec,
+			},
+		}
+	}
+
+	return result
+}
+
+// CreateService creates the App's Service, which maps port 80 to the App port.
+func CreateService(backend v1.App) *corev1.Service {
+	result := &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.Identifier(),
+			Kind:       "Service",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        backend.Name,
+			Namespace:   backend.Namespace,
+			Labels:      backend.Labels,
+			Annotations: map[string]string{},
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: selector(backend),
+			Type:     corev1.ServiceTypeClusterIP,
+			Ports:    servicePorts(backend),
+		},
+	}
+
+	if backend.Spec.Ingress != nil && backend.Spec.Ingress.Enabled && backend.Spec.Ingress.Kind == "grpc" && ingressController(backend) == "traefik" {
+		maps.Copy(result.Annotations, map[string]string{
+			"traefik.ingress.kubernetes.io/service.serversscheme": "h2c",
+		})
+	}
+
+	maps.Copy(result.Annotations, meshServiceAnnotations(backend))
+
+	// With an Ingress, external-dns publishes the Ingress instead.
+	if (backend.Spec.Ingress == nil || !backend.Spec.Ingress.Enabled) && backend.Spec.Service != nil && backend.Spec.Service.Type == "LoadBalancer" {
+		maps.Copy(result.Annotations, externalDNSAnnotations(backend))
+	}
+
+	if backend.Spec.Service != nil {
+		result.Spec.Type = cmp.Or(corev1.ServiceType(backend.Spec.Service.Type), corev1.ServiceTypeClusterIP)
+		result.Spec.Ports[0].NodePort = backend.Spec.Service.NodePort
+		maps.Copy(result.Annotations, backend.Spec.Service.Annotations)
+
+		if backend.Spec.Service.Headless {
+			result.Spec.ClusterIP = corev1.ClusterIPNone
+		}
+
+		if backend.Spec.Service.TrafficDistribution != "" {
+			result.Spec.TrafficDistribution = ptr.To(backend.Spec.Service.TrafficDistribution)
+		}
+
+		if backend.Spec.Service.TopologyAwareHints {
+			result.Annotations["service.kubernetes.io/topology-mode"] = "Auto"
+		}
+	}
+
+	return result
+}
+
+// containerPorts exposes the App port as "http" over TCP and/or "udp" over UDP.
+// Kubernetes keys container ports on number and protocol, so "both" is two entries with distinct names.
+func containerPorts(backend v1.App) []corev1.ContainerPort {
+	var result []corev1.ContainerPort
+
+	if backend.Spec.Protocol != "UDP" {
+		result = append(result, corev1.ContainerPort{
+			Name:          "http",
+			Protocol:      corev1.ProtocolTCP,
+			ContainerPort: int32(backend.Spec.Port),
+		})
+	}
+
+	if backend.Spec.Protocol == "UDP" || backend.Spec.Protocol == "both" {
+		result = append(result, corev1.ContainerPort{
+			Name:          "udp",
+			Protocol:      corev1.ProtocolUDP,
+			ContainerPort: int32(backend.Spec.Port),
+		})
+	}
+
+	return result
+}
+
+// servicePorts maps port 80 to the App port for HTTP. UDP has no such convention, so the UDP
+// service port uses the App port number as is (a DNS server on 53 is reachable on 53).
+func servicePorts(backend v1.App) []corev1.ServicePort {
+	var result []corev1.ServicePort
+
+	if backend.Spec.Protocol != "UDP" {
+		result = append(result, corev1.ServicePort{
+			Protocol:   corev1.ProtocolTCP,
+			Port:       80,
+			TargetPort: intstr.FromInt(backend.Spec.Port),
+			Name:       "http",
+		})
+	}
+
+	if backend.Spec.Protocol == "UDP" || backend.Spec.Protocol == "both" {
+		result = append(result, corev1.ServicePort{
+			Protocol:   corev1.ProtocolUDP,
+			Port:       int32(backend.Spec.Port),
+			TargetPort: intstr.FromInt(backend.Spec.Port),
+			Name:       "udp",
+		})
+	}
+
+	return append(result, onionServicePorts(backend)...)
+}
+
+// CreateHeadlessService creates a <name>-headless Service for peer discovery next to the regular ClusterIP Service.
+func CreateHeadlessService(backend v1.App) *corev1.Service {
+	result := CreateService(backend)
+	result.Name = backend.Name + "-headless"
+	result.Spec.Type = corev1.ServiceTypeClusterIP
+	result.Spec.ClusterIP = corev1.ClusterIPNone
+	result.Spec.Ports[0].NodePort = 0
+	return result
+}
+
+// externalDNSAnnotations are the annotations external-dns reads to publish the App's hostname. The hostname
+// defaults to the ingress host and the hosts that redirect to it.
+func externalDNSAnnotations(app v1.App) map[string]string {
+	dns := app.Spec.DNS
+	if dns == nil || !dns.Enabled {
+		return nil
+	}
+
+	hostname := dns.Hostname
+	if hostname == "" && app.Spec.Ingress != nil {
+		hostname = strings.Join(ingressHosts(app), ",")
+	}
+
+	result := map[string]string{
+		"external-dns.alpha.kubernetes.io/hostname": hostname,
+	}
+	if dns.TTL != 0 {
+		result["external-dns.alpha.kubernetes.io/ttl"] = strconv.FormatInt(dns.TTL, 10)
+	}
+	if dns.Target != "" {
+		result["external-dns.alpha.kubernetes.io/target"] = dns.Target
+	}
+	return result
+}
+
+// checkIngressClusterIssuer fails with strictReferences when the ingress ClusterIssuer doesn't exist, and only
+// warns otherwise.
+func checkIngressClusterIssuer(app v1.App, report *renderreport.Report) error {
+	if err := checkClusterIssuer(app); err != nil {
+		if app.Spec.StrictReferences {
+			return err
+		}
+		report.Warn("MissingClusterIssuer", "the ingress certificate won't be issued", "app", app.Name, "err", err)
+	}
+	return nil
+}
+
+// ingressController guesses which controller serves the App's ingress class from its name, so that the Ingress
+// and Service get annotations that controller understands. Classes named nginx or traefik, or starting with
+// nginx- or traefik-, are recognized. Any other class only gets the cert-manager annotation.
+func ingressController(app v1.App) string {
+	for _, controller := range []string{"nginx", "traefik"} {
+		if name := app.Spec.Ingress.ClassName; name == controller || strings.HasPrefix(name, controller+"-") {
+			return controller
+		}
+	}
+	return ""
+}
+
+// ingressHosts returns the Ingress host followed by the hosts that redirect to it.
+func ingressHosts(app v1.App) []string {
+	return append([]string{app.Spec.Ingress.Host}, app.Spec.Ingress.RedirectFrom...)
+}
+
+// ingressPaths returns the paths of the App's Ingress rule, which default to everything under / going to the App.
+func ingressPaths(app v1.App) []networkingv1.HTTPIngressPath {
+	if len(app.Spec.Ingress.Paths) == 0 {
+		return []networkingv1.HTTPIngressPath{
+			{
+				PathType: ptr.To(networkingv1.PathTypePrefix),
+				Path:     "/",
+				Backend:  *ingressBackend(app, "", 0),
+			},
+		}
+	}
+
+	var result []networkingv1.HTTPIngressPath
+	for _, p := range app.Spec.Ingress.Paths {
+		result = append(result, networkingv1.HTTPIngressPath{
+			PathType: ptr.To(networkingv1.PathType(p.PathType)),
+			Path:     p.Path,
+			Backend:  *ingressBackend(app, p.Service, p.Port),
+		})
+	}
+	return result
+}
+
+// ingressBackend points the Ingress at a Service port, or at the App's own http port when service is empty.
+func ingressBackend(app v1.App, service string, port int32) *networkingv1.IngressBackend {
+	if service == "" {
+		return &networkingv1.IngressBackend{
+			Service: &networkingv1.IngressServiceBackend{
+				Name: app.Name,
+				Port: networkingv1.ServiceBackendPort{
+					Name: "http",
+				},
+			},
+		}
+	}
+	return &networkingv1.IngressBackend{
+		Service: &networkingv1.IngressServiceBackend{
+			Name: service,
+			Port: networkingv1.ServiceBackendPort{
+				Number: port,
+			},
+		},
+	}
+}
+
+// CreateIngress exposes the App at its ingress host, with annotations for the controller of its ingress class.
+// Settings its controller ignores are recorded in report.
+func CreateIngress(app v1.App, report *renderreport.Report) (*networkingv1.Ingress, error) {
+	tls := ingressTLS(app)
+	issued := tls && app.Spec.Ingress.TLSSecretName == ""
+	if issued {
+		if err := checkIngressClusterIssuer(app, report); err != nil {
+			return nil, err
+		}
+	}
+
+	controller := ingressController(app)
+	if len(app.Spec.Ingress.Allowlist) != 0 && controller != "nginx" && controller != "traefik" {
+		return nil, fmt.Errorf("ingress.allowlist needs ingress-nginx or Traefik, it can't be used with the %s ingress class", app.Spec.Ingress.ClassName)
+	}
+	if len(app.Spec.Ingress.Allowlist) != 0 && app.Spec.Onion != nil && app.Spec.Onion.Enabled {
+		report.Warn("OnionWithoutAllowlist", "the onion service goes straight to the App, anyone can reach it over Tor", "app", app.Name)
+	}
+	if app.Spec.Ingress.BasicAuth != nil && controller != "nginx" {
+		// Leaving the App open when it was meant to be behind a password is worse than not rendering it.
+		return nil, fmt.Errorf("ingress.basicAuth needs ingress-nginx, it can't be used with the %s ingress class", app.Spec.Ingress.ClassName)
+	}
+	if len(app.Spec.Ingress.RedirectFrom) != 0 && controller != "nginx" {
+		// Without the server snippet the hosts would serve the App instead of redirecting.
+		return nil, fmt.Errorf("ingress.redirectFrom needs ingress-nginx, it can't be used with the %s ingress class", app.Spec.Ingress.ClassName)
+	}
+
+	annotations := map[string]string{}
+	if issued {
+		annotations["cert-manager.io/cluster-issuer"] = app.Spec.Ingress.ClusterIssuer
+	}
+	if tls {
+		switch controller {
+		case "nginx":
+			annotations["nginx.ingress.kubernetes.io/ssl-redirect"] = "true"
+		case "traefik":
+			// Traefik redirects plain HTTP on the web entrypoint itself, so the router only listens on websecure.
+			annotations["traefik.ingress.kubernetes.io/router.entrypoints"] = "websecure"
+			annotations["traefik.ingress.kubernetes.io/router.tls"] = "true"
+		}
+	}
+	if controller == "nginx" {
+		// Set before the App's own annotations, so that those win.
+		maps.Copy(annotations, rateLimitAnnotations(app))
+	}
+	maps.Copy(annotations, externalDNSAnnotations(app))
+	maps.Copy(annotations, meshIngressAnnotations(app))
+	maps.Copy(annotations, app.Spec.Ingress.Annotations)
+	result := &networkingv1.Ingress{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: networkingv1.SchemeGroupVersion.Identifier(),
+			Kind:       "Ingress",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        app.Name,
+			Namespace:   app.Namespace,
+			Labels:      app.Labels,
+			Annotations: annotations,
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: ptr.To(app.Spec.Ingress.ClassName),
+		},
+	}
+
+	// The hosts in redirectFrom need rules of their own for ingress-nginx to serve them, even though the server
+	// snippet redirects them before any path is matched.
+	for _, host := range ingressHosts(app) {
+		result.Spec.Rules = append(result.Spec.Rules, networkingv1.IngressRule{
+			Host: host,
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: ingressPaths(app),
+				},
+			},
+		})
+	}
+
+	if backend := app.Spec.Ingress.DefaultBackend; backend != nil {
+		result.Spec.DefaultBackend = ingressBackend(app, backend.Service, backend.Port)
+	}
+
+	if tls {
+		result.Spec.TLS = []networkingv1.IngressTLS{
+			{
+				Hosts:      ingressHosts(app),
+				SecretName: mkTLSSecretName(app),
+			},
+		}
+	}
+
+	if controller == "traefik" && len(app.Spec.Ingress.Allowlist) != 0 {
+		// Middlewares the Ingress already has from annotations are kept.
+		middleware := fmt.Sprintf("%s-%s@kubernetescrd", app.Namespace, allowlistMiddlewareName(app))
+		if existing := result.Annotations[traefikMiddlewaresAnnotation]; existing != "" {
+			middleware = existing + "," + middleware
+		}
+		result.Annotations[traefikMiddlewaresAnnotation] = middleware
+	}
+
+	if controller != "nginx" {
+		if app.Spec.Ingress.EnableCoreRules {
+			report.Warn("IgnoredCoreRules", "enableCoreRules needs ingress-nginx, ignoring it", "app", app.Name, "className", app.Spec.Ingress.ClassName)
+		}
+		if app.Spec.Ingress.RateLimit != nil {
+			report.Warn("IgnoredRateLimit", "rateLimit needs ingress-nginx, ignoring it", "app", app.Name, "className", app.Spec.Ingress.ClassName)
+		}
+		if app.Spec.Onion != nil && app.Spec.Onion.Enabled {
+			report.Warn("NoOnionLocation", "the Onion-Location header needs ingress-nginx, not advertising the onion service", "app", app.Name, "className", app.Spec.Ingress.ClassName)
+		}
+		return result, nil
+	}
+
+	if app.Spec.Ingress.EnableCoreRules {
+		result.Annotations["nginx.ingress.kubernetes.io/enable-owasp-core-rules"] = "true"
+		result.Annotations["nginx.ingress.kubernetes.io/enable-modsecurity"] = "true"
+		result.Annotations["nginx.ingress.kubernetes.io/modsecurity-transaction-id"] = "$request_id"
+	}
+
+	if len(app.Spec.Ingress.Allowlist) != 0 {
+		result.Annotations["nginx.ingress.kubernetes.io/whitelist-source-range"] = strings.Join(app.Spec.Ingress.Allowlist, ",")
+	}
+
+	if auth := app.Spec.Ingress.BasicAuth; auth != nil {
+		maps.Copy(result.Annotations, map[string]string{
+			"nginx.ingress.kubernetes.io/auth-type":   "basic",
+			"nginx.ingress.kubernetes.io/auth-secret": fmt.Sprintf("%s-%s", app.Name, BasicAuthSecret(app).Name),
+			"nginx.ingress.kubernetes.io/auth-realm":  auth.Realm,
+		})
+
+		if app.Spec.Onion != nil && app.Spec.Onion.Enabled {
+			report.Warn("OnionWithoutBasicAuth", "the onion service goes straight to the App, it isn't behind basic auth", "app", app.Name)
+		}
+	}
+
+	if len(app.Spec.Ingress.RedirectFrom) != 0 {
+		scheme := "http"
+		if tls {
+			scheme = "https"
+		}
+		var snippet strings.Builder
+		for _, host := range app.Spec.Ingress.RedirectFrom {
+			fmt.Fprintf(&snippet, "if ($host = %q) {\n  return 301 %s://%s$request_uri;\n}\n", host, scheme, app.Spec.Ingress.Host)
+		}
+		appendSnippet(result.Annotations, "nginx.ingress.kubernetes.io/server-snippet", snippet.String())
+	}
+
+	if app.Spec.Ingress.Kind == "grpc" {
+		maps.Copy(result.Annotations, map[string]string{
+			"nginx.ingress.kubernetes.io/backend-protocol": "GRPC",
+		})
+
+		if app.Spec.Mesh == "linkerd" {
+			appendConfigurationSnippet(result.Annotations, linkerdGRPCSnippet)
+		}
+	}
+
+	if app.Spec.Onion != nil && app.Spec.Onion.Enabled {
+		hostname := onionHostname(app, report)
+
+		// The tor controller only fills in the hostname after it has created the OnionService, so on the
+		// first deploy there is nothing to advertise yet. The header shows up on a later resync.
+		if hostname != "" {
+			appendConfigurationSnippet(result.Annotations, fmt.Sprintf("more_set_headers \"Onion-Location: http://%s$request_uri\";\n", hostname))
+		}
+	}
+
+	return result, nil
+}
+
+// appendConfigurationSnippet adds snippet to the ingress-nginx configuration snippet in annotations, after any
+// snippet set through ingress.annotations.
+func appendConfigurationSnippet(annotations map[string]string, snippet string) {
+	appendSnippet(annotations, "nginx.ingress.kubernetes.io/configuration-snippet", snippet)
+}
+
+// appendSnippet adds snippet to the ingress-nginx snippet annotation key, after anything already in it.
+func appendSnippet(annotations map[string]string, key, snippet string) {
+	if existing := annotations[key]; existing != "" {
+		snippet = strings.TrimRight(existing, "\n") + "\n" + snippet
+	}
+	annotations[key] = snippet
+}
+
+// onionHostname returns the .onion hostname of the App's OnionService, or an empty string if it isn't known yet.
+// The header is only a hint for Tor Browser, so failing to look the OnionService up is a warning rather than a
+// reason not to render the Ingress.
+func onionHostname(app v1.App, report *renderreport.Report) string {
+	onionSvc, err := lookupOnionService(app.Namespace, app.Name)
+	switch {
+	case err == nil:
+		return onionSvc.Status.Hostname
+	case k8s.IsErrNotFound(err):
+		report.Info("NoOnionLocation", "onion service does not exist yet, not setting Onion-Location", "app", app.Name)
+	case isLookupDenied(err):
+		report.Warn("NoOnionLocation", "not allowed to look up onion service, check the Airway's clusterAccess and RBAC, not setting Onion-Location", "app", app.Name, "err", err)
+	default:
+		report.Warn("NoOnionLocation", "failed to look up onion service, not setting Onion-Location", "app", app.Name, "err", err)
+	}
+	return ""
+}
+
+// applyNameSuffix renders the App as a preview of itself. Everything the flight creates is named after the App,
+// so renaming it moves every object, the selector, and the lookups of objects from earlier renders over to the
+// preview at once. The ingress host gets the suffix on its first label, which also gives the preview its own TLS
+// secret. References to objects the App doesn't create, such as existing Secrets, keep their names.
+func applyNameSuffix(app *v1.App) {
+	app.Name += "-" + app.Spec.NameSuffix
+
+	// The settings are copied before they are changed, they are shared with the caller's App.
+	if app.Spec.Ingress != nil {
+		ingress := *app.Spec.Ingress
+		ingress.Host = suffixHost(ingress.Host, app.Spec.NameSuffix)
+		ingress.RedirectFrom = make([]string, len(ingress.RedirectFrom))
+		for i, host := range app.Spec.Ingress.RedirectFrom {
+			ingress.RedirectFrom[i] = suffixHost(host, app.Spec.NameSuffix)
+		}
+		app.Spec.Ingress = &ingress
+	}
+
+	if app.Spec.DNS != nil && app.Spec.DNS.Hostname != "" {
+		dns := *app.Spec.DNS
+		dns.Hostname = suffixHost(dns.Hostname, app.Spec.NameSuffix)
+		app.Spec.DNS = &dns
+	}
+}
+
+// suffixHost appends suffix to the first label of host.
+func suffixHost(host, suffix string) string {
+	label, domain, ok := strings.Cut(host, ".")
+	if !ok {
+		return label + "-" + suffix
+	}
+	return label + "-" + suffix + "." + domain
+}
+
+// rateLimitAnnotations translates ingress.rateLimit to ingress-nginx annotations. Limits that aren't set are left
+// to ingress-nginx.
+func rateLimitAnnotations(app v1.App) map[string]string {
+	result := map[string]string{}
+	limit := app.Spec.Ingress.RateLimit
+	if limit == nil {
+		return result
+	}
+
+	if limit.RequestsPerSecond != 0 {
+		result["nginx.ingress.kubernetes.io/limit-rps"] = strconv.Itoa(limit.RequestsPerSecond)
+	}
+	if limit.BurstMultiplier != 0 {
+		result["nginx.ingress.kubernetes.io/limit-burst-multiplier"] = strconv.Itoa(limit.BurstMultiplier)
+	}
+	if limit.Connections != 0 {
+		result["nginx.ingress.kubernetes.io/limit-connections"] = strconv.Itoa(limit.Connections)
+	}
+	return result
+}
+
+const traefikMiddlewaresAnnotation = "traefik.ingress.kubernetes.io/router.middlewares"
+
+func allowlistMiddlewareName(app v1.App) string {
+	return app.Name + "-allowlist"
+}
+
+// CreateAllowlistMiddleware creates the Traefik Middleware that only lets ingress.allowlist through. There is no
+// annotation for it like with ingress-nginx, so the Ingress references the Middleware instead. ipAllowList needs
+// Traefik v3.
+func CreateAllowlistMiddleware(app v1.App) *unstructured.Unstructured {
+	sourceRange := make([]any, len(app.Spec.Ingress.Allowlist))
+	for i, cidr := range app.Spec.Ingress.Allowlist {
+		sourceRange[i] = cidr
+	}
+
+	result := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "traefik.io/v1alpha1",
+			"kind":       "Middleware",
+			"spec": map[string]any{
+				"ipAllowList": map[string]any{
+					"sourceRange": sourceRange,
+				},
+			},
+		},
+	}
+	result.SetName(allowlistMiddlewareName(app))
+	result.SetNamespace(app.Namespace)
+	result.SetLabels(app.Labels)
+	return result
+}
+
+// BasicAuthSecret is the secret that syncs the htpasswd file of ingress.basicAuth from 1Password. ingress-nginx
+// reads the users from its auth key.
+func BasicAuthSecret(app v1.App) v1.Secret {
+	return v1.Secret{
+		Name:     "basic-auth",
+		ItemPath: app.Spec.Ingress.BasicAuth.ItemPath,
+	}
+}
+
+// ingressTLS reports if the App is served over HTTPS. Without cert-manager there is nothing to issue the
+// certificate, so ingress.tls: false serves it over plain HTTP instead.
+func ingressTLS(app v1.App) bool {
+	return ptr.Deref(app.Spec.Ingress.TLS, true)
+}
+
+// mkTLSSecretName is the name of the Secret with the ingress certificate: ingress.tlsSecretName if it is set, or
+// one named after the host for cert-manager to issue.
+func mkTLSSecretName(app v1.App) string {
+	if app.Spec.Ingress.TLSSecretName != "" {
+		return app.Spec.Ingress.TLSSecretName
+	}
+	return hostTLSSecretName(app.Spec.Ingress.Host)
+}
+
+// hostTLSSecretName names the certificate Secret of host. Dots become dashes, the host is lowercased, and
+// anything else that can't be in a Secret name is dropped, so valid lowercase hosts keep the names they have
+// always had. The * of a wildcard host becomes wildcard-, so *.example.com gets wildcard--example-com-public-tls
+// and doesn't share a Secret with example.com or wildcard.example.com. Names over the 253 character limit are cut
+// short and get a hash of the whole name, so two long hosts that only differ at the end don't share a certificate.
+func hostTLSSecretName(host string) string {
+	const suffix = "-public-tls"
+
+	host = strings.ReplaceAll(strings.ToLower(host), "*", "wildcard-")
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r == '.':
+			return '-'
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		default:
+			return -1
+		}
+	}, host)
+	name = strings.Trim(name, "-") + suffix
+
+	if len(name) <= validation.DNS1123SubdomainMaxLength {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	hash := "-" + hex.EncodeToString(sum[:])[:8]
+	prefix := strings.TrimRight(name[:validation.DNS1123SubdomainMaxLength-len(hash)-len(suffix)], "-")
+	return prefix + hash + suffix
+}
+
+// CreateOnepasswordSecret syncs sec from 1Password into a Secret named <app>-<secret>.
+func CreateOnepasswordSecret(app v1.App, sec v1.Secret) *onepasswordv1.OnePasswordItem {
+	genName := fmt.Sprintf("%s-%s", app.Name, sec.Name)
+
+	result := &onepasswordv1.OnePasswordItem{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: onepasswordv1.GroupVersion.Identifier(),
+			Kind:       "OnePasswordItem",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        genName,
+			Namespace:   app.Namespace,
+			Labels:      app.Labels,
+			Annotations: map[string]string{},
+		},
+		Spec: onepasswordv1.OnePasswordItemSpec{
+			ItemPath: sec.ItemPath,
+		},
+	}
+
+	// The 1Password operator creates the Secret with this type. The item needs a .dockerconfigjson field.
+	if sec.Type == "docker-registry" {
+		result.Type = string(corev1.SecretTypeDockerConfigJson)
+	}
+
+	return result
+}
+
+// onionRules publishes the onion rules through the App's Service. Without rules, port 80 goes to the Service's
+// HTTP port like it always has.
+func onionRules(app v1.App) []onionv1alpha2.ServiceRule {
+	if len(app.Spec.Onion.Rules) == 0 {
+		return []onionv1alpha2.ServiceRule{
+			{
+				Port: networkingv1.ServiceBackendPort{
+					Name:   "http",
+					Number: 80,
+				},
+				Backend: networkingv1.IngressBackend{
+					Service: &networkingv1.IngressServiceBackend{
+						Name: app.Name,
+						Port: networkingv1.ServiceBackendPort{
+							Name:   "http",
+							Number: 80,
+						},
+					},
+				},
+			},
+		}
+	}
+
+	var result []onionv1alpha2.ServiceRule
+	for _, rule := range app.Spec.Onion.Rules {
+		target := networkingv1.ServiceBackendPort{Name: rule.TargetPortName}
+		switch {
+		case rule.TargetPortName == "http":
+			target.Number = 80
+		case rule.TargetPort == int32(app.Spec.Port) && app.Spec.Port == 80:
+			target = networkingv1.ServiceBackendPort{Name: "http", Number: 80}
+		default:
+			target = networkingv1.ServiceBackendPort{Name: onionPortName(rule.TargetPort), Number: rule.TargetPort}
+		}
+
+		result = append(result, onionv1alpha2.ServiceRule{
+			Port: networkingv1.ServiceBackendPort{
+				Number: rule.PublicPort,
+			},
+			Backend: networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{
+					Name: app.Name,
+					Port: target,
+				},
+			},
+		})
+	}
+
+	return result
+}
+
+// onionServicePorts are the extra Service ports that onion rules with a targetPort point at. Each one forwards
+// straight to the container port of the same number.
+func onionServicePorts(backend v1.App) []corev1.ServicePort {
+	if backend.Spec.Onion == nil || !backend.Spec.Onion.Enabled {
+		return nil
+	}
+
+	var result []corev1.ServicePort
+	seen := map[int32]bool{}
+	for _, rule := range backend.Spec.Onion.Rules {
+		if rule.TargetPort == 0 || seen[rule.TargetPort] {
+			continue
+		}
+		// With an App port of 80, port 80 is already the HTTP port.
+		if rule.TargetPort == 80 && backend.Spec.Port == 80 {
+			continue
+		}
+		seen[rule.TargetPort] = true
+
+		result = append(result, corev1.ServicePort{
+			Protocol:   corev1.ProtocolTCP,
+			Port:       rule.TargetPort,
+			TargetPort: intstr.FromInt32(rule.TargetPort),
+			Name:       onionPortName(rule.TargetPort),
+		})
+	}
+
+	return result
+}
+
+func onionPortName(port int32) string {
+	return fmt.Sprintf("tor-%d", port)
+}
+
+// CreateOnion exposes the App as a Tor hidden service.
+func CreateOnion(app v1.App) *onionv1alpha2.OnionService {
+	result := &onionv1alpha2.OnionService{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: onionv1alpha2.GroupVersion.Identifier(),
+			Kind:       "OnionService",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      app.Name,
+			Namespace: app.Namespace,
+			Labels:    app.Labels,
+		},
+		Spec: onionv1alpha2.OnionServiceSpec{
+			Version: cmp.Or(app.Spec.Onion.Version, 3),
+			Rules:   onionRules(app),
+			Template: onionv1alpha2.ServicePodTemplate{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"app": app.Name},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{},
+				},
+			},
+		},
+	}
+
+	if secret := app.Spec.Onion.PrivateKeySecret; secret != nil {
+		result.Spec.PrivateKeySecret = onionv1alpha2.SecretReference{
+			Name: secret.Name,
+			Key:  secret.Key,
+		}
+	}
+
+	var cfg strings.Builder
+
+	if app.Spec.Onion.Haproxy {
+		fmt.Fprintln(&cfg, "HiddenServiceExportCircuitID haproxy")
+	}
+
+	if app.Spec.Onion.NonAnonymous {
+		fmt.Fprintln(&cfg, "HiddenServiceNonAnonymousMode 1")
+		fmt.Fprintln(&cfg, "HiddenServiceSingleHopMode 1")
+	}
+
+	if app.Spec.Onion.NumIntroductionPoints != 0 {
+		fmt.Fprintf(&cfg, "HiddenServiceNumIntroductionPoints %d\n", app.Spec.Onion.NumIntroductionPoints)
+	}
+
+	if app.Spec.Onion.MaxStreams != 0 {
+		fmt.Fprintf(&cfg, "HiddenServiceMaxStreams %d\n", app.Spec.Onion.MaxStreams)
+	}
+
+	if pow := app.Spec.Onion.ProofOfWorkDefense; pow != nil && pow.Enabled {
+		fmt.Fprintln(&cfg, "HiddenServicePoWDefensesEnabled 1")
+		fmt.Fprintf(&cfg, "HiddenServicePoWQueueRate %d\n", cmp.Or(pow.QueueRate, 1))
+		fmt.Fprintf(&cfg, "HiddenServicePoWQueueBurst %d\n", cmp.Or(pow.QueueBurst, 10))
+	}
+
+	result.Spec.ExtraConfig = cfg.String()
+
+	return result
+}
+
+// CreatePVC creates the PersistentVolumeClaim of one of the App's volumes.
+func CreatePVC(app v1.App, pvc v1.Volume) *corev1.PersistentVolumeClaim {
+	size, err := resource.ParseQuantity(pvc.Size)
+	if err != nil {
+		panic(err)
+	}
+
+	result := &corev1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.Identifier(),
+			Kind:       "PersistentVolumeClaim",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      app.Name + "-" + pvc.Name,
+			Namespace: app.Namespace,
+			Labels:    app.Labels,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{
+				corev1.PersistentVolumeAccessMode(cmp.Or(pvc.AccessMode, string(corev1.ReadWriteOnce))),
+			},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: size,
+				},
+			},
+			StorageClassName: pvc.StorageClass,
+		},
+	}
+
+	return result
+}
+
+// deploymentStrategy is strategy.type if it is set. Otherwise Apps with a ReadWriteOnce volume are recreated, as the
+// new pod of a rolling update can't mount a volume the old pod still holds and never starts.
+func deploymentStrategy(app v1.App) appsv1.DeploymentStrategyType {
+	if app.Spec.Strategy != nil && app.Spec.Strategy.Type != "" {
+		return appsv1.DeploymentStrategyType(app.Spec.Strategy.Type)
+	}
+	if len(singleAttachVolumes(app)) != 0 {
+		return appsv1.RecreateDeploymentStrategyType
+	}
+	return appsv1.RollingUpdateDeploymentStrategyType
+}
+
+// singleAttachVolumes lists the App's volumes that only one node, or one pod, can mount at a time.
+func singleAttachVolumes(app v1.App) []string {
+	var result []string
+	if app.Spec.Storage != nil && app.Spec.Storage.Enabled {
+		result = append(result, "storage")
+	}
+	for _, volume := range app.Spec.Volumes {
+		switch corev1.PersistentVolumeAccessMode(volume.AccessMode) {
+		case corev1.ReadWriteOnce, corev1.ReadWriteOncePod:
+			result = append(result, volume.Name)
+		}
+	}
+	return result
+}
+
+// CreateStorage creates the PVC for the single storage volume, which is the volume named "storage".
+func CreateStorage(app v1.App) *corev1.PersistentVolumeClaim {
+	return CreatePVC(app, storageVolume(app))
+}
+
+// storageVolume is the storage setting as a volume, so that it keeps the PVC name <app>-storage.
+func storageVolume(app v1.App) v1.Volume {
+	return v1.Volume{
+		Name:         "storage",
+		Path:         app.Spec.Storage.Path,
+		Size:         app.Spec.Storage.Size,
+		StorageClass: app.Spec.Storage.StorageClass,
+		AccessMode:   string(corev1.ReadWriteOnce),
+	}
+}
+
+// CreateRole grants the App's namespaced RBAC rules.
+func CreateRole(app v1.App) *rbacv1.Role {
+	return &rbacv1.Role{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.Identifier(),
+			Kind:       "Role",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      app.Name,
+			Namespace: app.Namespace,
+			Labels:    app.Labels,
+		},
+		Rules: app.Spec.Role.NamespacedRules(),
+	}
+}
+
+// CreateRoleBinding binds the Role from CreateRole to the App's ServiceAccount.
+func CreateRoleBinding(app v1.App) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.Identifier(),
+			Kind:       "RoleBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      app.Name,
+			Namespace: app.Namespace,
+			Labels:    app.Labels,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      "ServiceAccount",
+				Name:      serviceAccountName(app),
+				Namespace: app.Namespace,
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     app.Name,
+		},
+	}
+}
+
+// clusterRoleName is the name of the App's ClusterRole and ClusterRoleBinding. They are cluster-scoped, so the
+// namespace is part of the name to keep Apps with the same name in different namespaces apart.
+func clusterRoleName(app v1.App) string {
+	return app.Namespace + "-" + app.Name
+}
+
+// CreateClusterRole grants the App's cluster-wide RBAC rules.
+func CreateClusterRole(app v1.App) *rbacv1.ClusterRole {
+	return &rbacv1.ClusterRole{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.Identifier(),
+			Kind:       "ClusterRole",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   clusterRoleName(app),
+			Labels: app.Labels,
+		},
+		Rules: app.Spec.Role.ClusterWideRules(),
+	}
+}
+
+// CreateClusterRoleBinding binds the ClusterRole from CreateClusterRole to the App's ServiceAccount.
+func CreateClusterRoleBinding(app v1.App) *rbacv1.ClusterRoleBinding {
+	return &rbacv1.ClusterRoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.Identifier(),
+			Kind:       "ClusterRoleBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   clusterRoleName(app),
+			Labels: app.Labels,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      "ServiceAccount",
+				Name:      serviceAccountName(app),
+				Namespace: app.Namespace,
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     clusterRoleName(app),
+		},
+	}
+}
+
+// serviceAccountName is the name of the ServiceAccount the App's pods run as.
+func serviceAccountName(app v1.App) string {
+	if app.Spec.ServiceAccount != nil && app.Spec.ServiceAccount.Name != "" {
+		return app.Spec.ServiceAccount.Name
+	}
+	return app.Name
+}
+
+// CreateServiceAccount creates the ServiceAccount the App's pods run as.
+func CreateServiceAccount(app v1.App) *corev1.ServiceAccount {
+	var annotations map[string]string
+	if app.Spec.ServiceAccount != nil {
+		annotations = app.Spec.ServiceAccount.Annotations
+	}
+
+	return &corev1.ServiceAccount{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.Identifier(),
+			Kind:       "ServiceAccount",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        serviceAccountName(app),
+			Namespace:   app.Namespace,
+			Labels:      app.Labels,
+			Annotations: annotations,
+		},
+		AutomountServiceAccountToken: ptr.To(app.Spec.AutomountToken()),
+	}
+}
+
+// CreateConfigMap creates one of the App's ConfigMaps, named after a hash of its data.
+func CreateConfigMap(app v1.App, cm v1.ConfigMap) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.Identifier(),
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      app.Name + "-" + cm.GenName(),
+			Namespace: app.Namespace,
+			Labels:    app.Labels,
+		},
+		Data: cm.Data,
+	}
+}
+
+// mergeEnv sets vars in env. A variable that is already set is replaced where it is, so every name is set once and
+// the last value wins, the same as the kubelet does with duplicates but without leaving them in the pod spec.
+func mergeEnv(env []corev1.EnvVar, vars ...corev1.EnvVar) []corev1.EnvVar {
+	for _, v := range vars {
+		i := slices.IndexFunc(env, func(e corev1.EnvVar) bool { return e.Name == v.Name })
+		if i == -1 {
+			env = append(env, v)
+			continue
+		}
+		env[i] = v
+	}
+	return env
+}
+
+// containerName is the name of the App's container. Container names are DNS labels, which App names with dots
+// or more than 63 characters aren't, so those Apps get a container named app instead.
+func containerName(app v1.App) string {
+	if app.Spec.ContainerName != "" {
+		return app.Spec.ContainerName
+	}
+	if errs := validation.IsDNS1123Label(app.Name); len(errs) != 0 {
+		return "app"
+	}
+	return app.Name
+}
+
+// replicas is the number of pods the App runs: none while it is suspended, and replicas, or 1 if it isn't set,
+// otherwise. replicas itself is left alone, so that unsuspending the App brings back as many pods as before.
+func replicas(app v1.App) int32 {
+	if app.Spec.Suspend {
+		return 0
+	}
+	return ptr.Deref(app.Spec.Replicas, 1)
+}
+
+// extendedResourceTolerations tolerates the taints that nodes with extended resources, such as GPU nodes, usually
+// have to keep other pods away. By convention the taint is named after the resource, like nvidia.com/gpu, which is
+// also what the ExtendedResourceToleration admission plugin tolerates on clusters that have it enabled.
+func extendedResourceTolerations(app v1.App) []corev1.Toleration {
+	if app.Spec.Resources == nil {
+		return nil
+	}
+
+	var result []corev1.Toleration
+	for _, name := range slices.Sorted(maps.Keys(app.Spec.Resources.Limits)) {
+		if !v1.IsExtendedResource(name) {
+			continue
+		}
+		result = append(result, corev1.Toleration{
+			Key:      string(name),
+			Operator: corev1.TolerationOpExists,
+			Effect:   corev1.TaintEffectNoSchedule,
+		})
+	}
+	return result
+}
+
+// useHostNetwork runs the pods in the node's network namespace. Two pods on one node would fight over the same
+// ports, so the pods refuse to share a node, and replicas beyond the number of nodes stay pending.
+func useHostNetwork(app v1.App, spec *corev1.PodSpec) {
+	spec.HostNetwork = true
+	if app.Spec.DNSPolicy == "" {
+		// Otherwise the pods would use the node's resolver and couldn't resolve Services.
+		spec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+	}
+
+	spec.Affinity = &corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+				{
+					LabelSelector: &metav1.LabelSelector{MatchLabels: selector(app)},
+					TopologyKey:   corev1.LabelHostname,
+				},
+			},
+		},
+	}
+}
+
+// topologySpreadConstraints returns the App's spread constraints, pointing any without a label selector at
+// the App's pods.
+func topologySpreadConstraints(backend v1.App) []corev1.TopologySpreadConstraint {
+	var result []corev1.TopologySpreadConstraint
+
+	for _, tsc := range backend.Spec.TopologySpreadConstraints {
+		if tsc.LabelSelector == nil {
+			tsc.LabelSelector = &metav1.LabelSelector{MatchLabels: selector(backend)}
+		}
+		result = append(result, tsc)
+	}
+
+	if backend.Spec.SpreadAcrossZones {
+		result = append(result, corev1.TopologySpreadConstraint{
+			MaxSkew:           1,
+			TopologyKey:       "topology.kubernetes.io/zone",
+			WhenUnsatisfiable: corev1.ScheduleAnyway,
+			LabelSelector:     &metav1.LabelSelector{MatchLabels: selector(backend)},
+		})
+	}
+
+	return result
+}
+
+// Our selector for our backend application. Independent from the regular labels passed in the backend spec.
+func selector(backend v1.App) map[string]string {
+	return map[string]string{"app.kubernetes.io/name": backend.Name}
+}
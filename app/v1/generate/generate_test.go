@@ -0,0 +1,742 @@
+package generate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/utils/ptr"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+	"github.com/Xe/yoke-stuff/internal/renderreport"
+	"github.com/Xe/yoke-stuff/internal/stub"
+	"github.com/yokecd/yoke/pkg/flight/wasi/k8s"
+
+	onionv1alpha2 "github.com/bugfest/tor-controller/apis/tor/v1alpha2"
+)
+
+func TestMain(m *testing.M) {
+	// Render decisions are logged too, which is only noise here.
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	os.Exit(m.Run())
+}
+
+// decode decodes an App from YAML the way the flight does, defaults and validation included.
+func decode(t *testing.T, manifest string) v1.App {
+	t.Helper()
+
+	var app v1.App
+	if err := yaml.NewYAMLToJSONDecoder(strings.NewReader(manifest)).Decode(&app); err != nil {
+		t.Fatalf("failed to decode App: %v", err)
+	}
+	return app
+}
+
+// render decodes manifest and renders it with Generate.
+func render(t *testing.T, manifest string) []any {
+	t.Helper()
+
+	result, err := Generate(decode(t, manifest))
+	if err != nil {
+		t.Fatalf("failed to render App: %v", err)
+	}
+	return result
+}
+
+// find returns the object of type T named name in objs.
+func find[T any, PT interface {
+	*T
+	GetName() string
+}](t *testing.T, objs []any, name string) PT {
+	t.Helper()
+
+	for _, obj := range objs {
+		if obj, ok := obj.(PT); ok && obj.GetName() == name {
+			return obj
+		}
+	}
+	t.Fatalf("no %T named %s in the rendered objects", PT(nil), name)
+	return nil
+}
+
+// decisions returns the render report written to annotations.
+func decisions(t *testing.T, annotations map[string]string) []renderreport.Decision {
+	t.Helper()
+
+	data, ok := annotations[renderreport.Annotation]
+	if !ok {
+		return nil
+	}
+	var result []renderreport.Decision
+	if err := json.Unmarshal([]byte(data), &result); err != nil {
+		t.Fatalf("render report isn't JSON: %v", err)
+	}
+	return result
+}
+
+func TestGenerateConcurrentReports(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			name := fmt.Sprintf("app%d", i)
+			suspended := i%2 == 0
+			result, err := Generate(decode(t, fmt.Sprintf(`
+apiVersion: x.within.website/v1
+kind: App
+metadata:
+  name: %s
+spec:
+  image: ghcr.io/xe/x/stickers:latest
+  suspend: %t
+`, name, suspended)))
+			if err != nil {
+				t.Errorf("%s: failed to render App: %v", name, err)
+				return
+			}
+
+			var deployment *appsv1.Deployment
+			for _, obj := range result {
+				if obj, ok := obj.(*appsv1.Deployment); ok {
+					deployment = obj
+				}
+			}
+
+			got := decisions(t, deployment.Annotations)
+			if !suspended {
+				if len(got) != 0 {
+					t.Errorf("%s: got decisions %+v from another render", name, got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0].Reason != "Suspended" || got[0].Details["app"] != name {
+				t.Errorf("%s: got decisions %+v, want only its own Suspended decision", name, got)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// longHost returns a valid host of n characters that ends in tld.
+func longHost(n int, tld string) string {
+	var labels []string
+	for n > len(tld) {
+		size := min(63, n-len(tld)-1)
+		labels = append(labels, strings.Repeat("a", size))
+		n -= size + 1
+	}
+	host := strings.Join(append(labels, tld), ".")
+	if errs := validation.IsDNS1123Subdomain(host); len(errs) != 0 && len(host) <= validation.DNS1123SubdomainMaxLength {
+		panic(fmt.Sprintf("longHost made an invalid host %q: %s", host, strings.Join(errs, ", ")))
+	}
+	return host
+}
+
+func TestHostTLSSecretName(t *testing.T) {
+	for _, tt := range []struct {
+		name, host, want string
+	}{
+		{
+			name: "short host keeps its name",
+			host: "stickers.within.website",
+			want: "stickers-within-website-public-tls",
+		},
+		{
+			name: "uppercase",
+			host: "Stickers.Within.Website",
+			want: "stickers-within-website-public-tls",
+		},
+		{
+			name: "trailing dot",
+			host: "stickers.within.website.",
+			want: "stickers-within-website-public-tls",
+		},
+		{
+			name: "wildcard",
+			host: "*.within.website",
+			want: "wildcard--within-website-public-tls",
+		},
+		{
+			name: "one under the limit",
+			host: longHost(validation.DNS1123SubdomainMaxLength-len("-public-tls")-1, "com"),
+			want: strings.ReplaceAll(longHost(validation.DNS1123SubdomainMaxLength-len("-public-tls")-1, "com"), ".", "-") + "-public-tls",
+		},
+		{
+			name: "at the limit",
+			host: longHost(validation.DNS1123SubdomainMaxLength-len("-public-tls"), "com"),
+			want: strings.ReplaceAll(longHost(validation.DNS1123SubdomainMaxLength-len("-public-tls"), "com"), ".", "-") + "-public-tls",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if len(tt.want) > validation.DNS1123SubdomainMaxLength {
+				t.Fatalf("bad test case, %q is too long", tt.want)
+			}
+			if got := hostTLSSecretName(tt.host); got != tt.want {
+				t.Errorf("hostTLSSecretName(%q) = %q, want %q", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostTLSSecretNameWildcardDoesNotShareSecret(t *testing.T) {
+	names := map[string]string{}
+	for _, host := range []string{"within.website", "*.within.website", "wildcard.within.website"} {
+		name := hostTLSSecretName(host)
+		if other, ok := names[name]; ok {
+			t.Errorf("%s and %s both use secret %s", host, other, name)
+		}
+		names[name] = host
+	}
+}
+
+func TestHostTLSSecretNameOverLimit(t *testing.T) {
+	limit := validation.DNS1123SubdomainMaxLength - len("-public-tls")
+
+	for _, n := range []int{limit + 1, limit + 20, 253} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			host := longHost(n, "com")
+			got := hostTLSSecretName(host)
+			if len(got) > validation.DNS1123SubdomainMaxLength {
+				t.Errorf("name is %d characters, want at most %d", len(got), validation.DNS1123SubdomainMaxLength)
+			}
+			if errs := validation.IsDNS1123Subdomain(got); len(errs) != 0 {
+				t.Errorf("%q is not a valid Secret name: %s", got, strings.Join(errs, ", "))
+			}
+			if !strings.HasSuffix(got, "-public-tls") {
+				t.Errorf("%q lost its -public-tls suffix", got)
+			}
+
+			// Two hosts that only differ after the cut get their own Secrets.
+			if other := hostTLSSecretName(longHost(n, "org")); other == got {
+				t.Errorf("hosts ending in .com and .org both use secret %s", got)
+			}
+		})
+	}
+}
+
+func TestRoleGrantsRBACAndToken(t *testing.T) {
+	const rules = `
+  role:
+    enabled: %t
+    rules:
+      - apiGroups: [""]
+        resources: ["configmaps"]
+        verbs: ["get"]
+`
+
+	for _, tt := range []struct {
+		name string
+		role string
+		want bool
+	}{
+		{name: "no role"},
+		{name: "enabled", role: fmt.Sprintf(rules, true), want: true},
+		{name: "enabled: false", role: fmt.Sprintf(rules, false), want: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			result := render(t, `
+apiVersion: x.within.website/v1
+kind: App
+metadata:
+  name: stickers
+  namespace: default
+spec:
+  image: ghcr.io/xe/x/stickers:latest
+`+tt.role)
+
+			var roles, bindings int
+			for _, obj := range result {
+				switch obj.(type) {
+				case *rbacv1.Role:
+					roles++
+				case *rbacv1.RoleBinding:
+					bindings++
+				}
+			}
+			want := 0
+			if tt.want {
+				want = 1
+			}
+			if roles != want || bindings != want {
+				t.Errorf("got %d Roles and %d RoleBindings, want %d of each", roles, bindings, want)
+			}
+
+			sa := find[corev1.ServiceAccount](t, result, "stickers")
+			deployment := find[appsv1.Deployment](t, result, "stickers")
+			for what, automount := range map[string]*bool{
+				"ServiceAccount": sa.AutomountServiceAccountToken,
+				"pod":            deployment.Spec.Template.Spec.AutomountServiceAccountToken,
+			} {
+				if automount == nil || *automount != tt.want {
+					t.Errorf("%s automountServiceAccountToken = %v, want %t", what, ptr.Deref(automount, true), tt.want)
+				}
+			}
+		})
+	}
+}
+
+// podSpecs returns the pod specs of every workload in objs, keyed by kind and name, with the names of the
+// volumes each one can mount. StatefulSets also mount their volumeClaimTemplates.
+func podSpecs(objs []any) map[string]struct {
+	spec    corev1.PodSpec
+	volumes map[string]bool
+} {
+	type podSpec = struct {
+		spec    corev1.PodSpec
+		volumes map[string]bool
+	}
+	result := map[string]podSpec{}
+	add := func(key string, spec corev1.PodSpec, claims ...corev1.PersistentVolumeClaim) {
+		volumes := map[string]bool{}
+		for _, v := range spec.Volumes {
+			volumes[v.Name] = true
+		}
+		for _, claim := range claims {
+			volumes[claim.Name] = true
+		}
+		result[key] = podSpec{spec, volumes}
+	}
+
+	for _, obj := range objs {
+		switch obj := obj.(type) {
+		case *appsv1.Deployment:
+			add("Deployment/"+obj.Name, obj.Spec.Template.Spec)
+		case *appsv1.StatefulSet:
+			add("StatefulSet/"+obj.Name, obj.Spec.Template.Spec, obj.Spec.VolumeClaimTemplates...)
+		case *batchv1.CronJob:
+			add("CronJob/"+obj.Name, obj.Spec.JobTemplate.Spec.Template.Spec)
+		case *batchv1.Job:
+			add("Job/"+obj.Name, obj.Spec.Template.Spec)
+		}
+	}
+	return result
+}
+
+func TestFolderSecretVolumeMounts(t *testing.T) {
+	for _, workload := range []string{"deployment", "statefulset"} {
+		t.Run(workload, func(t *testing.T) {
+			result := render(t, fmt.Sprintf(`
+apiVersion: x.within.website/v1
+kind: App
+metadata:
+  name: stickers
+  namespace: default
+spec:
+  image: ghcr.io/xe/x/stickers:latest
+  workload: %s
+  storage:
+    enabled: true
+    path: /data
+    size: 1Gi
+  secrets:
+    - name: certs
+      itemPath: vaults/lc/items/certs
+      folder: true
+    - name: tmp
+      itemPath: vaults/lc/items/tmp
+      folder: true
+      mountPath: /etc/tmp
+    - name: env
+      itemPath: vaults/lc/items/env
+      environment: true
+  crons:
+    - name: cleanup
+      schedule: "0 4 * * *"
+      command: ["/bin/cleanup"]
+  bootstrap:
+    command: ["/bin/bootstrap"]
+`, workload))
+
+			specs := podSpecs(result)
+			if len(specs) != 3 {
+				t.Fatalf("got pod specs %v, want the %s, the cron, and the bootstrap job", slices.Collect(maps.Keys(specs)), workload)
+			}
+
+			for key, pod := range specs {
+				var mounted []string
+				for _, container := range slices.Concat(pod.spec.InitContainers, pod.spec.Containers) {
+					for _, mount := range container.VolumeMounts {
+						if !pod.volumes[mount.Name] {
+							t.Errorf("%s: container %s mounts volume %s, which the pod doesn't have", key, container.Name, mount.Name)
+						}
+						mounted = append(mounted, mount.Name)
+					}
+				}
+
+				for _, sec := range []string{"certs", "tmp"} {
+					name := "secret-" + sec
+					if !slices.Contains(mounted, name) {
+						t.Errorf("%s: folder secret volume %s isn't mounted, got mounts %v", key, name, mounted)
+					}
+					for _, v := range pod.spec.Volumes {
+						if v.Name == name && (v.Secret == nil || v.Secret.SecretName != "stickers-"+sec) {
+							t.Errorf("%s: volume %s doesn't mount secret stickers-%s: %+v", key, name, sec, v.VolumeSource)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestUserEnvOverridesBuiltins(t *testing.T) {
+	result := render(t, `
+apiVersion: x.within.website/v1
+kind: App
+metadata:
+  name: stickers
+  namespace: default
+spec:
+  image: ghcr.io/xe/x/stickers:latest
+  env:
+    - name: SLOG_LEVEL
+      value: debug
+`)
+
+	env := find[appsv1.Deployment](t, result, "stickers").Spec.Template.Spec.Containers[0].Env
+
+	var levels []corev1.EnvVar
+	for _, v := range env {
+		if v.Name == "SLOG_LEVEL" {
+			levels = append(levels, v)
+		}
+	}
+	if len(levels) != 1 || levels[0].Value != "debug" {
+		t.Errorf("got SLOG_LEVEL %+v, want it set once to debug", levels)
+	}
+
+	// The other built-ins are still there, in the order they always were.
+	var names []string
+	for _, v := range env {
+		names = append(names, v.Name)
+	}
+	if want := []string{"PORT", "BIND", "SLOG_LEVEL"}; !slices.Equal(names[:len(want)], want) {
+		t.Errorf("got env %v, want it to start with %v", names, want)
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	env := []corev1.EnvVar{
+		{Name: "PORT", Value: "3000"},
+		{Name: "BIND", Value: ":3000"},
+		{Name: "SLOG_LEVEL", Value: "info"},
+	}
+
+	got := mergeEnv(env,
+		corev1.EnvVar{Name: "PORT", Value: "8080"},
+		corev1.EnvVar{Name: "FOO", Value: "bar"},
+		corev1.EnvVar{Name: "FOO", Value: "baz"},
+	)
+	want := []corev1.EnvVar{
+		{Name: "PORT", Value: "8080"},
+		{Name: "BIND", Value: ":3000"},
+		{Name: "SLOG_LEVEL", Value: "info"},
+		{Name: "FOO", Value: "baz"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("mergeEnv() = %+v, want %+v", got, want)
+	}
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	const manifest = `
+apiVersion: x.within.website/v1
+kind: App
+metadata:
+  name: stickers
+  namespace: default
+spec:
+  image: ghcr.io/xe/x/stickers:latest
+  ingress:
+    enabled: true
+    host: stickers.within.website
+  secrets:
+%s
+`
+	// One secret of each kind: lists inside the pod spec, like envFrom and volumes, keep the order they are written
+	// in, since for envFrom that order decides which value wins.
+	secrets := []string{
+		"    - {name: tigris, itemPath: vaults/lc/items/tigris, environment: true}",
+		"    - {name: certs, itemPath: vaults/lc/items/certs, folder: true}",
+		"    - {name: smtp, itemPath: vaults/lc/items/smtp, envMap: {SMTP_PASSWORD: password}}",
+	}
+
+	encode := func(secrets []string) string {
+		data, err := json.Marshal(render(t, fmt.Sprintf(manifest, strings.Join(secrets, "\n"))))
+		if err != nil {
+			t.Fatalf("failed to encode rendered objects: %v", err)
+		}
+		return string(data)
+	}
+
+	want := encode(secrets)
+	if got := encode(secrets); got != want {
+		t.Errorf("rendering the same App twice gave different output:\n%s\n%s", want, got)
+	}
+
+	slices.Reverse(secrets)
+	if got := encode(secrets); got != want {
+		t.Errorf("listing the secrets in another order changed the output:\n%s\n%s", want, got)
+	}
+}
+
+func TestServiceAnnotations(t *testing.T) {
+	const h2c = "traefik.ingress.kubernetes.io/service.serversscheme"
+
+	for _, tt := range []struct {
+		name, spec string
+		want       map[string]string
+	}{
+		{
+			name: "none",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+`,
+			want: map[string]string{},
+		},
+		{
+			name: "grpc behind traefik",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+ingress:
+  enabled: true
+  host: stickers.within.website
+  className: traefik
+  kind: grpc
+`,
+			want: map[string]string{h2c: "h2c"},
+		},
+		{
+			name: "user annotations next to the built-in one",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+ingress:
+  enabled: true
+  host: stickers.within.website
+  className: traefik
+  kind: grpc
+service:
+  annotations:
+    metallb.universe.tf/address-pool: public
+`,
+			want: map[string]string{h2c: "h2c", "metallb.universe.tf/address-pool": "public"},
+		},
+		{
+			name: "user annotation wins over the built-in one",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+ingress:
+  enabled: true
+  host: stickers.within.website
+  className: traefik
+  kind: grpc
+service:
+  annotations:
+    traefik.ingress.kubernetes.io/service.serversscheme: https
+`,
+			want: map[string]string{h2c: "https"},
+		},
+		{
+			name: "user annotation wins over external-dns",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+dns:
+  enabled: true
+  hostname: stickers.within.website
+service:
+  type: LoadBalancer
+  annotations:
+    external-dns.alpha.kubernetes.io/hostname: stickers.xeserv.us
+`,
+			want: map[string]string{"external-dns.alpha.kubernetes.io/hostname": "stickers.xeserv.us"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app := decode(t, "apiVersion: x.within.website/v1\nkind: App\nmetadata:\n  name: stickers\nspec:"+strings.ReplaceAll(tt.spec, "\n", "\n  "))
+
+			got := CreateService(app).Annotations
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("got annotations %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceTrafficDistribution(t *testing.T) {
+	const topologyMode = "service.kubernetes.io/topology-mode"
+
+	for _, tt := range []struct {
+		name, spec   string
+		want         string
+		wantTopology string
+	}{
+		{
+			name: "neither",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+`,
+		},
+		{
+			name: "trafficDistribution",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+service:
+  trafficDistribution: PreferClose
+`,
+			want: "PreferClose",
+		},
+		{
+			name: "topologyAwareHints",
+			spec: `
+image: ghcr.io/xe/x/stickers:latest
+service:
+  topologyAwareHints: true
+`,
+			wantTopology: "Auto",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app := decode(t, "apiVersion: x.within.website/v1\nkind: App\nmetadata:\n  name: stickers\nspec:"+strings.ReplaceAll(tt.spec, "\n", "\n  "))
+
+			service := CreateService(app)
+			if got := ptr.Deref(service.Spec.TrafficDistribution, ""); got != tt.want {
+				t.Errorf("got trafficDistribution %q, want %q", got, tt.want)
+			}
+			if got := service.Annotations[topologyMode]; got != tt.wantTopology {
+				t.Errorf("got %s %q, want %q", topologyMode, got, tt.wantTopology)
+			}
+		})
+	}
+}
+
+func TestOnionHostname(t *testing.T) {
+	app := decode(t, `
+apiVersion: x.within.website/v1
+kind: App
+metadata:
+  name: stickers
+  namespace: default
+spec:
+  image: ghcr.io/xe/x/stickers:latest
+  onion:
+    enabled: true
+`)
+
+	for _, tt := range []struct {
+		name string
+		err  error
+		want string
+		// wantMessage is the start of the message of the NoOnionLocation decision, empty when there is none.
+		wantMessage string
+		wantErr     bool
+	}{
+		{
+			name: "found",
+			want: "abcdefghijklmnop.onion",
+		},
+		{
+			name:        "not created yet",
+			err:         k8s.ErrorNotFound("not found"),
+			wantMessage: "onion service does not exist yet",
+		},
+		{
+			name:        "forbidden",
+			err:         k8s.ErrorForbidden("onionservices.tor.k8s.torproject.org is forbidden"),
+			wantMessage: "not allowed to look up onion service",
+			wantErr:     true,
+		},
+		{
+			name:        "no cluster access",
+			err:         k8s.ErrorClusterAccessNotGranted,
+			wantMessage: "not allowed to look up onion service",
+			wantErr:     true,
+		},
+		{
+			name:        "other error",
+			err:         errors.New("connection refused"),
+			wantMessage: "failed to look up onion service",
+			wantErr:     true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stub.Set(t, &lookupOnionService, func(namespace, name string) (*onionv1alpha2.OnionService, error) {
+				if namespace != "default" || name != "stickers" {
+					t.Errorf("looked up onion service %s/%s, want default/stickers", namespace, name)
+				}
+				if tt.err != nil {
+					return nil, tt.err
+				}
+				var svc onionv1alpha2.OnionService
+				svc.Status.Hostname = "abcdefghijklmnop.onion"
+				return &svc, nil
+			})
+
+			report := &renderreport.Report{}
+			if got := onionHostname(app, report); got != tt.want {
+				t.Errorf("onionHostname() = %q, want %q", got, tt.want)
+			}
+
+			if tt.wantMessage == "" {
+				if len(report.Decisions) != 0 {
+					t.Errorf("got decisions %+v, want none", report.Decisions)
+				}
+				return
+			}
+			if len(report.Decisions) != 1 {
+				t.Fatalf("got decisions %+v, want one", report.Decisions)
+			}
+			decision := report.Decisions[0]
+			if decision.Reason != "NoOnionLocation" || !strings.HasPrefix(decision.Message, tt.wantMessage) {
+				t.Errorf("got decision %+v, want NoOnionLocation saying %q", decision, tt.wantMessage)
+			}
+			if _, ok := decision.Details["err"]; ok != tt.wantErr {
+				t.Errorf("decision has the lookup error: %t, want %t", ok, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOnionLocationHeader(t *testing.T) {
+	stub.Set(t, &lookupOnionService, func(namespace, name string) (*onionv1alpha2.OnionService, error) {
+		var svc onionv1alpha2.OnionService
+		svc.Status.Hostname = "abcdefghijklmnop.onion"
+		return &svc, nil
+	})
+
+	result := render(t, `
+apiVersion: x.within.website/v1
+kind: App
+metadata:
+  name: stickers
+  namespace: default
+spec:
+  image: ghcr.io/xe/x/stickers:latest
+  ingress:
+    enabled: true
+    host: stickers.within.website
+  onion:
+    enabled: true
+`)
+
+	snippet := find[networkingv1.Ingress](t, result, "stickers").Annotations["nginx.ingress.kubernetes.io/configuration-snippet"]
+	if want := `more_set_headers "Onion-Location: http://abcdefghijklmnop.onion$request_uri";`; !strings.Contains(snippet, want) {
+		t.Errorf("got configuration snippet %q, want it to contain %q", snippet, want)
+	}
+}
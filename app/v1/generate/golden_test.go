@@ -0,0 +1,142 @@
+package generate
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata with the current output")
+
+// TestGolden renders every App in testdata/*.yaml and compares the result with the JSON file of the same name.
+// After an intended change to the output, run `go test ./app/v1/generate -run TestGolden -update` and review
+// the diff of testdata.
+func TestGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no golden test cases in testdata")
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".yaml")
+		t.Run(name, func(t *testing.T) {
+			manifest, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := json.MarshalIndent(render(t, string(manifest)), "", "  ")
+			if err != nil {
+				t.Fatalf("failed to encode rendered objects: %v", err)
+			}
+			got = append(got, '\n')
+
+			golden := strings.TrimSuffix(input, ".yaml") + ".json"
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v, run with -update to create it", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("rendered objects differ from %s, run with -update and review the diff:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+// claims returns what a render takes for itself in a namespace: the kind and name of every object, the values its
+// selectors match, and its ingress hosts and TLS secrets.
+func claims(t *testing.T, manifest string) map[string]bool {
+	t.Helper()
+
+	data, err := json.Marshal(render(t, manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var objs []struct {
+		Kind     string `json:"kind"`
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			// Selector is a Service's selector or the label selector of a Deployment or StatefulSet.
+			Selector json.RawMessage `json:"selector"`
+			Rules    []struct {
+				Host string `json:"host"`
+			} `json:"rules"`
+			TLS []struct {
+				SecretName string `json:"secretName"`
+			} `json:"tls"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(data, &objs); err != nil {
+		t.Fatal(err)
+	}
+
+	result := map[string]bool{}
+	for _, obj := range objs {
+		result[obj.Kind+" "+obj.Metadata.Name] = true
+
+		var selector struct {
+			MatchLabels map[string]string `json:"matchLabels"`
+		}
+		var labels map[string]string
+		if json.Unmarshal(obj.Spec.Selector, &selector) == nil && selector.MatchLabels != nil {
+			labels = selector.MatchLabels
+		} else {
+			json.Unmarshal(obj.Spec.Selector, &labels)
+		}
+		for key, value := range labels {
+			result["selector "+key+"="+value] = true
+		}
+
+		for _, rule := range obj.Spec.Rules {
+			result["host "+rule.Host] = true
+		}
+		for _, tls := range obj.Spec.TLS {
+			result["secret "+tls.SecretName] = true
+		}
+	}
+	return result
+}
+
+// TestPreviewSharesNothing renders an App and a preview of it with a nameSuffix, and checks that the preview
+// can't take over anything of the App's in the namespace they share.
+func TestPreviewSharesNothing(t *testing.T) {
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	base := claims(t, read("preview-base.yaml"))
+	preview := claims(t, read("preview-pr-42.yaml"))
+	for _, claim := range []string{"Deployment stickers-pr-42", "selector app.kubernetes.io/name=stickers-pr-42", "host stickers-pr-42.within.website", "host stickers-pr-42.xeserv.us"} {
+		if !preview[claim] {
+			t.Errorf("the preview doesn't have %s", claim)
+		}
+	}
+	if len(base) != len(preview) {
+		t.Errorf("the App claims %d things and its preview %d, want the same objects", len(base), len(preview))
+	}
+	for claim := range preview {
+		if base[claim] {
+			t.Errorf("the App and its preview both have %s", claim)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package generate
+
+import (
+	"maps"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+)
+
+// The recommended labels (https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/) set
+// on everything the App creates. Only app.kubernetes.io/name is part of the selector, the others change over the
+// life of the App.
+const (
+	instanceLabel  = "app.kubernetes.io/instance"
+	versionLabel   = "app.kubernetes.io/version"
+	managedByLabel = "app.kubernetes.io/managed-by"
+	componentLabel = "app.kubernetes.io/component"
+)
+
+// trackLabel sets the pods of the canary Deployment apart from the App's own.
+const trackLabel = "x.within.website/track"
+
+// standardLabels are the recommended labels shared by everything the App creates. instance is the name of the App
+// before any nameSuffix.
+func standardLabels(app v1.App, instance string) map[string]string {
+	result := map[string]string{
+		instanceLabel:  instance,
+		managedByLabel: "yoke",
+	}
+	if version := imageVersion(app.Spec.Image); version != "" {
+		result[versionLabel] = version
+	}
+	return result
+}
+
+// withoutStandardLabels returns labels without the recommended labels other than the name, for places that
+// can't change after they are created, like a StatefulSet's volumeClaimTemplates.
+func withoutStandardLabels(labels map[string]string) map[string]string {
+	result := maps.Clone(labels)
+	for _, key := range []string{instanceLabel, versionLabel, managedByLabel, componentLabel} {
+		delete(result, key)
+	}
+	return result
+}
+
+// imageVersion is the tag of image, or latest if it has none, the same as the container runtime assumes. Images
+// pinned only by digest and tags that aren't valid label values have no version.
+func imageVersion(image string) string {
+	image, _, pinned := strings.Cut(image, "@")
+
+	// A colon before the last slash belongs to the registry's port.
+	name := image[strings.LastIndex(image, "/")+1:]
+	_, tag, ok := strings.Cut(name, ":")
+	if !ok {
+		if pinned {
+			return ""
+		}
+		tag = "latest"
+	}
+
+	if len(validation.IsValidLabelValue(tag)) != 0 {
+		return ""
+	}
+	return tag
+}
+
+// component sets the app.kubernetes.io/component label on objs, and on the pods of Deployments, StatefulSets, and
+// CronJobs. A Job's pod template can't change, so only the Job itself gets it. objs is returned so the call can
+// wrap the objects being added to the result.
+func component(name string, objs ...any) []any {
+	for _, obj := range objs {
+		if obj, ok := obj.(metav1.Object); ok {
+			obj.SetLabels(withComponent(obj.GetLabels(), name))
+		}
+
+		switch obj := obj.(type) {
+		case *appsv1.Deployment:
+			obj.Spec.Template.Labels = withComponent(obj.Spec.Template.Labels, name)
+		case *appsv1.StatefulSet:
+			obj.Spec.Template.Labels = withComponent(obj.Spec.Template.Labels, name)
+		case *batchv1.CronJob:
+			obj.Spec.JobTemplate.Labels = withComponent(obj.Spec.JobTemplate.Labels, name)
+			obj.Spec.JobTemplate.Spec.Template.Labels = withComponent(obj.Spec.JobTemplate.Spec.Template.Labels, name)
+		}
+	}
+	return objs
+}
+
+// withComponent copies labels, which are usually shared with other objects, and sets the component label.
+func withComponent(labels map[string]string, name string) map[string]string {
+	result := maps.Clone(labels)
+	if result == nil {
+		result = map[string]string{}
+	}
+	result[componentLabel] = name
+	return result
+}
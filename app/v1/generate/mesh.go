@@ -0,0 +1,73 @@
+package generate
+
+import (
+	"maps"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+)
+
+// The annotation and label the mesh injectors look for on pods. Linkerd reads an annotation, Istio prefers the
+// label over its deprecated annotation.
+const (
+	linkerdInjectAnnotation = "linkerd.io/inject"
+	istioInjectLabel        = "sidecar.istio.io/inject"
+)
+
+// setMeshInjection turns the proxy of the App's mesh on or off for the pods of template. It wins over
+// podAnnotations.
+func setMeshInjection(app v1.App, template *corev1.PodTemplateSpec, enabled bool) {
+	switch app.Spec.Mesh {
+	case "linkerd":
+		value := "disabled"
+		if enabled {
+			value = "enabled"
+		}
+		if template.Annotations == nil {
+			template.Annotations = map[string]string{}
+		}
+		template.Annotations[linkerdInjectAnnotation] = value
+	case "istio":
+		// The template shares its labels with the workload, so they are copied before being changed.
+		labels := maps.Clone(template.Labels)
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[istioInjectLabel] = strconv.FormatBool(enabled)
+		template.Labels = labels
+	}
+}
+
+// meshIngressAnnotations has the ingress controller send traffic to the Service's cluster IP instead of straight
+// to the pods, so that the mesh does the load balancing and sees the traffic as meant for the Service. Linkerd
+// also needs gRPC requests from ingress-nginx to name the Service in the l5d-dst-override header.
+func meshIngressAnnotations(app v1.App) map[string]string {
+	annotations := map[string]string{}
+	if app.Spec.Mesh == "" || app.Spec.Ingress == nil || !app.Spec.Ingress.Enabled || app.Spec.Ingress.Gateway != nil {
+		return annotations
+	}
+
+	if ingressController(app) == "nginx" {
+		annotations["nginx.ingress.kubernetes.io/service-upstream"] = "true"
+	}
+	return annotations
+}
+
+// meshServiceAnnotations is meshIngressAnnotations for Traefik, which reads the setting from the Service.
+func meshServiceAnnotations(app v1.App) map[string]string {
+	annotations := map[string]string{}
+	if app.Spec.Mesh == "" || app.Spec.Ingress == nil || !app.Spec.Ingress.Enabled || app.Spec.Ingress.Gateway != nil {
+		return annotations
+	}
+
+	if ingressController(app) == "traefik" {
+		annotations["traefik.ingress.kubernetes.io/service.nativelb"] = "true"
+	}
+	return annotations
+}
+
+// linkerdGRPCSnippet is the ingress-nginx configuration snippet that tells Linkerd which Service a gRPC request
+// is for.
+const linkerdGRPCSnippet = "grpc_set_header l5d-dst-override $service_name.$namespace.svc.cluster.local:$service_port;\n"
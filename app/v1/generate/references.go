@@ -0,0 +1,340 @@
+package generate
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+	"github.com/Xe/yoke-stuff/internal/renderreport"
+	"github.com/yokecd/yoke/pkg/flight/wasi/k8s"
+
+	onionv1alpha2 "github.com/bugfest/tor-controller/apis/tor/v1alpha2"
+)
+
+// reference is an object that the App expects to already exist in its namespace.
+type reference struct {
+	Kind string
+	Name string
+}
+
+func (r reference) String() string {
+	return strings.ToLower(r.Kind) + "/" + r.Name
+}
+
+// OfflineEnv is the environment variable that makes the flight render without looking anything up in the cluster
+// when it is set to true, such as for validating Apps in CI.
+const OfflineEnv = "FLIGHT_OFFLINE"
+
+// errOffline is returned by lookups when the flight is offline. It wraps k8s.ErrorClusterAccessNotGranted, so every
+// lookup falls back the same way as when the flight isn't allowed to look things up.
+var errOffline = fmt.Errorf("%s is set: %w", OfflineEnv, k8s.ErrorClusterAccessNotGranted)
+
+// offline reports if OfflineEnv is set.
+var offline = sync.OnceValue(func() bool {
+	result, _ := strconv.ParseBool(os.Getenv(OfflineEnv))
+	return result
+})
+
+// lookup is k8s.Lookup, except that outside of Wasm, such as when the flight is run with go run, there is no
+// cluster to ask, so it acts as if the flight wasn't granted cluster access instead of panicking. It does the same
+// when the flight is offline, whatever the host would answer.
+func lookup[T any](id k8s.ResourceIdentifier) (*T, error) {
+	if offline() {
+		return nil, errOffline
+	}
+	if runtime.GOOS != "wasip1" {
+		return nil, k8s.ErrorClusterAccessNotGranted
+	}
+	return k8s.Lookup[T](id)
+}
+
+// lookupSecret, lookupConfigMap, lookupJob, lookupClusterIssuer, lookupOnionService, lookupDeployment,
+// lookupStatefulSet, lookupEndpoints, lookupPod, and lookupResourceQuota fetch objects from the cluster. They are
+// variables so they can be swapped out when the flight runs without a cluster.
+var (
+	lookupSecret = func(namespace, name string) (*corev1.Secret, error) {
+		return lookup[corev1.Secret](k8s.ResourceIdentifier{
+			ApiVersion: "v1",
+			Kind:       "Secret",
+			Name:       name,
+			Namespace:  namespace,
+		})
+	}
+	lookupConfigMap = func(namespace, name string) (*corev1.ConfigMap, error) {
+		return lookup[corev1.ConfigMap](k8s.ResourceIdentifier{
+			ApiVersion: "v1",
+			Kind:       "ConfigMap",
+			Name:       name,
+			Namespace:  namespace,
+		})
+	}
+	lookupJob = func(namespace, name string) (*batchv1.Job, error) {
+		return lookup[batchv1.Job](k8s.ResourceIdentifier{
+			ApiVersion: batchv1.SchemeGroupVersion.Identifier(),
+			Kind:       "Job",
+			Name:       name,
+			Namespace:  namespace,
+		})
+	}
+	lookupClusterIssuer = func(name string) (*metav1.PartialObjectMetadata, error) {
+		return lookup[metav1.PartialObjectMetadata](k8s.ResourceIdentifier{
+			ApiVersion: "cert-manager.io/v1",
+			Kind:       "ClusterIssuer",
+			Name:       name,
+		})
+	}
+	lookupOnionService = func(namespace, name string) (*onionv1alpha2.OnionService, error) {
+		return lookup[onionv1alpha2.OnionService](k8s.ResourceIdentifier{
+			ApiVersion: onionv1alpha2.GroupVersion.Identifier(),
+			Kind:       "OnionService",
+			Name:       name,
+			Namespace:  namespace,
+		})
+	}
+	lookupDeployment = func(namespace, name string) (*appsv1.Deployment, error) {
+		return lookup[appsv1.Deployment](k8s.ResourceIdentifier{
+			ApiVersion: appsv1.SchemeGroupVersion.Identifier(),
+			Kind:       "Deployment",
+			Name:       name,
+			Namespace:  namespace,
+		})
+	}
+	lookupStatefulSet = func(namespace, name string) (*appsv1.StatefulSet, error) {
+		return lookup[appsv1.StatefulSet](k8s.ResourceIdentifier{
+			ApiVersion: appsv1.SchemeGroupVersion.Identifier(),
+			Kind:       "StatefulSet",
+			Name:       name,
+			Namespace:  namespace,
+		})
+	}
+	lookupEndpoints = func(namespace, name string) (*corev1.Endpoints, error) {
+		return lookup[corev1.Endpoints](k8s.ResourceIdentifier{
+			ApiVersion: "v1",
+			Kind:       "Endpoints",
+			Name:       name,
+			Namespace:  namespace,
+		})
+	}
+	lookupPod = func(namespace, name string) (*corev1.Pod, error) {
+		return lookup[corev1.Pod](k8s.ResourceIdentifier{
+			ApiVersion: "v1",
+			Kind:       "Pod",
+			Name:       name,
+			Namespace:  namespace,
+		})
+	}
+	lookupResourceQuota = func(namespace, name string) (*corev1.ResourceQuota, error) {
+		return lookup[corev1.ResourceQuota](k8s.ResourceIdentifier{
+			ApiVersion: "v1",
+			Kind:       "ResourceQuota",
+			Name:       name,
+			Namespace:  namespace,
+		})
+	}
+)
+
+// lookupReference checks if a reference exists in the cluster.
+func lookupReference(namespace string, ref reference) error {
+	var err error
+	switch ref.Kind {
+	case "Secret":
+		_, err = lookupSecret(namespace, ref.Name)
+	case "ConfigMap":
+		_, err = lookupConfigMap(namespace, ref.Name)
+	default:
+		err = fmt.Errorf("unknown reference kind %q", ref.Kind)
+	}
+	return err
+}
+
+// isLookupDenied reports if a lookup failed because the flight may not read the object, as opposed to
+// the object not existing.
+func isLookupDenied(err error) bool {
+	return k8s.IsErrForbidden(err) || k8s.IsErrUnauthenticated(err) || errors.Is(err, k8s.ErrorClusterAccessNotGranted)
+}
+
+// externalReferences lists every object the App references but does not create itself.
+func externalReferences(app v1.App) []reference {
+	var result []reference
+
+	for _, name := range app.Spec.ImagePullSecrets {
+		result = append(result, reference{Kind: "Secret", Name: name})
+	}
+
+	for _, name := range app.Spec.EnvFromConfigMaps {
+		result = append(result, reference{Kind: "ConfigMap", Name: name})
+	}
+
+	for _, name := range app.Spec.EnvFromSecrets {
+		result = append(result, reference{Kind: "Secret", Name: name})
+	}
+
+	if ingress := app.Spec.Ingress; ingress != nil && ingress.Enabled && ingress.TLSSecretName != "" {
+		result = append(result, reference{Kind: "Secret", Name: ingress.TLSSecretName})
+	}
+
+	return result
+}
+
+// checkReferences fails when any external reference is missing. Lookups that the flight isn't allowed
+// to make only log a warning so strictReferences still works without cluster access.
+func checkReferences(app v1.App, report *renderreport.Report) error {
+	var missing []string
+
+	for _, ref := range externalReferences(app) {
+		err := lookupReference(app.Namespace, ref)
+		switch {
+		case err == nil:
+			continue
+		case k8s.IsErrNotFound(err):
+			missing = append(missing, ref.String())
+		case isLookupDenied(err):
+			report.Warn("ReferenceUnchecked", "can't check if reference exists", "app", app.Name, "reference", ref.String(), "err", err)
+		default:
+			return fmt.Errorf("failed to look up %s: %w", ref, err)
+		}
+	}
+
+	if len(missing) != 0 {
+		return fmt.Errorf("missing references: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// wellKnownClusterIssuers are the ClusterIssuers that hypercloud's default initialize config creates. yoke can
+// only look objects up by name, so these are the issuers a missing one is compared against.
+var wellKnownClusterIssuers = []string{"letsencrypt-prod", "letsencrypt-staging"}
+
+// checkClusterIssuer fails when the Ingress's ClusterIssuer doesn't exist, which otherwise only shows up as a
+// certificate that never gets issued.
+//
+// yoke refuses lookups of objects that another release owns, so an existing ClusterIssuer comes back as
+// forbidden and the check passes. A forbidden lookup is also what a flight without cluster access gets, so
+// those skip the check too.
+func checkClusterIssuer(app v1.App) error {
+	name := app.Spec.Ingress.ClusterIssuer
+
+	_, err := lookupClusterIssuer(name)
+	switch {
+	case err == nil, isLookupDenied(err):
+		return nil
+	case !k8s.IsErrNotFound(err):
+		return fmt.Errorf("failed to look up cluster issuer %s: %w", name, err)
+	}
+
+	// The lookup above was allowed, so a forbidden lookup here means the issuer exists in another release.
+	var existing []string
+	for _, candidate := range wellKnownClusterIssuers {
+		if _, err := lookupClusterIssuer(candidate); err == nil || k8s.IsErrForbidden(err) {
+			existing = append(existing, candidate)
+		}
+	}
+
+	if len(existing) != 0 {
+		return fmt.Errorf("cluster issuer %s does not exist, existing issuers include %s", name, strings.Join(existing, ", "))
+	}
+	return fmt.Errorf("cluster issuer %s does not exist", name)
+}
+
+// checkEnvCollisions fails when two environment secrets would set the same variable, since which one
+// wins then depends on envFrom ordering. Secrets that can't be read yet (for example because the
+// 1Password operator hasn't synced them) are skipped with a warning.
+func checkEnvCollisions(app v1.App, report *renderreport.Report) error {
+	seen := map[string]string{} // env var name -> secret name
+	var errs []error
+
+	for _, sec := range app.Spec.Secrets {
+		if !sec.Environment {
+			continue
+		}
+
+		name := fmt.Sprintf("%s-%s", app.Name, sec.Name)
+		secret, err := lookupSecret(app.Namespace, name)
+		if err != nil {
+			if k8s.IsErrNotFound(err) || isLookupDenied(err) {
+				report.Warn("EnvCollisionUnchecked", "skipping environment collision check for secret", "app", app.Name, "secret", name, "err", err)
+				continue
+			}
+			return fmt.Errorf("failed to look up secret %s: %w", name, err)
+		}
+
+		keys := slices.Sorted(maps.Keys(secret.Data))
+		for _, key := range keys {
+			envName := sec.EnvPrefix + key
+			if other, ok := seen[envName]; ok {
+				errs = append(errs, fmt.Errorf("environment variable %s is set by both secret %s and secret %s", envName, other, name))
+				continue
+			}
+			seen[envName] = name
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkQuota warns when the App's requests, times its replicas, are more than what is left of
+// resourcePolicy.quota. The quota's usage already counts the App's running pods, so an App that fits can still
+// be warned about when it is re-rendered. Only requests are compared, either as requests.<name> or, for cpu and
+// memory, the bare name.
+func checkQuota(app v1.App, report *renderreport.Report) error {
+	name := app.Spec.ResourcePolicy.Quota
+
+	quota, err := lookupResourceQuota(app.Namespace, name)
+	switch {
+	case err == nil:
+	case k8s.IsErrNotFound(err):
+		report.Warn("MissingResourceQuota", "resource quota does not exist", "app", app.Name, "quota", name)
+		return nil
+	case isLookupDenied(err):
+		report.Info("QuotaUnchecked", "can't look up resource quota, skipping quota check", "app", app.Name, "quota", name, "err", err)
+		return nil
+	default:
+		return fmt.Errorf("failed to look up resource quota %s: %w", name, err)
+	}
+
+	requests := corev1.ResourceList{}
+	if app.Spec.Resources != nil {
+		// Kubernetes defaults a missing request to its limit.
+		maps.Copy(requests, app.Spec.Resources.Limits)
+		maps.Copy(requests, app.Spec.Resources.Requests)
+	}
+
+	replicas := int64(replicas(app))
+	for _, resource := range slices.Sorted(maps.Keys(requests)) {
+		footprint := requests[resource].DeepCopy()
+		footprint.Mul(replicas)
+
+		keys := []corev1.ResourceName{"requests." + resource}
+		if resource == corev1.ResourceCPU || resource == corev1.ResourceMemory {
+			keys = append(keys, resource)
+		}
+
+		for _, key := range keys {
+			hard, ok := quota.Status.Hard[key]
+			if !ok {
+				continue
+			}
+			remaining := hard.DeepCopy()
+			remaining.Sub(quota.Status.Used[key])
+
+			if footprint.Cmp(remaining) > 0 {
+				report.Warn("OverQuota", "app requests more than is left of the resource quota", "app", app.Name, "quota", name, "resource", key, "requested", footprint.String(), "remaining", remaining.String(), "replicas", replicas)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,399 @@
+package generate
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+	"github.com/Xe/yoke-stuff/internal/renderreport"
+	"github.com/Xe/yoke-stuff/internal/stub"
+	"github.com/yokecd/yoke/pkg/flight/wasi/k8s"
+)
+
+// stubReferences makes lookupSecret and lookupConfigMap answer from objects, which maps kind/name to the error
+// its lookup returns. Objects that aren't in it are found.
+func stubReferences(t *testing.T, objects map[string]error) {
+	t.Helper()
+
+	stub.Set(t, &lookupSecret, func(namespace, name string) (*corev1.Secret, error) {
+		if err := objects["secret/"+name]; err != nil {
+			return nil, err
+		}
+		return &corev1.Secret{}, nil
+	})
+	stub.Set(t, &lookupConfigMap, func(namespace, name string) (*corev1.ConfigMap, error) {
+		if err := objects["configmap/"+name]; err != nil {
+			return nil, err
+		}
+		return &corev1.ConfigMap{}, nil
+	})
+}
+
+func TestCheckReferences(t *testing.T) {
+	app := decode(t, `
+apiVersion: x.within.website/v1
+kind: App
+metadata:
+  name: stickers
+  namespace: default
+spec:
+  image: ghcr.io/xe/x/stickers:latest
+  strictReferences: true
+  imagePullSecrets: [regcred]
+  envFromConfigMaps: [shared-config]
+  envFromSecrets: [smtp-credentials]
+`)
+
+	notFound := k8s.ErrorNotFound("not found")
+	forbidden := k8s.ErrorForbidden("forbidden")
+
+	for _, tt := range []struct {
+		name    string
+		objects map[string]error
+		wantErr string
+		// wantUnchecked are the references reported as unchecked.
+		wantUnchecked []string
+	}{
+		{
+			name: "all found",
+		},
+		{
+			name:    "missing secret",
+			objects: map[string]error{"secret/regcred": notFound},
+			wantErr: "missing references: secret/regcred",
+		},
+		{
+			name: "every reference missing",
+			objects: map[string]error{
+				"secret/regcred":          notFound,
+				"configmap/shared-config": notFound,
+				"secret/smtp-credentials": notFound,
+			},
+			wantErr: "missing references: secret/regcred, configmap/shared-config, secret/smtp-credentials",
+		},
+		{
+			name:          "forbidden",
+			objects:       map[string]error{"configmap/shared-config": forbidden},
+			wantUnchecked: []string{"configmap/shared-config"},
+		},
+		{
+			name: "forbidden and missing",
+			objects: map[string]error{
+				"secret/regcred":          forbidden,
+				"secret/smtp-credentials": notFound,
+			},
+			wantErr:       "missing references: secret/smtp-credentials",
+			wantUnchecked: []string{"secret/regcred"},
+		},
+		{
+			name: "no cluster access",
+			objects: map[string]error{
+				"secret/regcred":          k8s.ErrorClusterAccessNotGranted,
+				"configmap/shared-config": k8s.ErrorClusterAccessNotGranted,
+				"secret/smtp-credentials": k8s.ErrorClusterAccessNotGranted,
+			},
+			wantUnchecked: []string{"secret/regcred", "configmap/shared-config", "secret/smtp-credentials"},
+		},
+		{
+			name:    "lookup fails",
+			objects: map[string]error{"configmap/shared-config": errors.New("connection refused")},
+			wantErr: "failed to look up configmap/shared-config: connection refused",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stubReferences(t, tt.objects)
+
+			report := &renderreport.Report{}
+			err := checkReferences(app, report)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("checkReferences() failed: %v", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+
+			var unchecked []string
+			for _, decision := range report.Decisions {
+				if decision.Reason == "ReferenceUnchecked" {
+					unchecked = append(unchecked, decision.Details["reference"])
+				}
+			}
+			if !slices.Equal(unchecked, tt.wantUnchecked) {
+				t.Errorf("got unchecked references %v, want %v", unchecked, tt.wantUnchecked)
+			}
+		})
+	}
+}
+
+func TestStrictReferencesFailsRender(t *testing.T) {
+	stubReferences(t, map[string]error{"secret/regcred": k8s.ErrorNotFound("not found")})
+
+	const manifest = `
+apiVersion: x.within.website/v1
+kind: App
+metadata:
+  name: stickers
+  namespace: default
+spec:
+  image: ghcr.io/xe/x/stickers:latest
+  strictReferences: %t
+  imagePullSecrets: [regcred]
+`
+
+	_, err := Generate(decode(t, fmt.Sprintf(manifest, true)))
+	if err == nil || !strings.Contains(err.Error(), "secret/regcred") {
+		t.Errorf("got error %v, want the render to fail on secret/regcred", err)
+	}
+
+	if _, err := Generate(decode(t, fmt.Sprintf(manifest, false))); err != nil {
+		t.Errorf("without strictReferences, got error %v", err)
+	}
+}
+
+// stubClusterIssuers makes lookupClusterIssuer answer from issuers, which maps a name to the error its lookup
+// returns. Issuers that aren't in it don't exist.
+func stubClusterIssuers(t *testing.T, issuers map[string]error) {
+	t.Helper()
+
+	stub.Set(t, &lookupClusterIssuer, func(name string) (*metav1.PartialObjectMetadata, error) {
+		err, ok := issuers[name]
+		switch {
+		case !ok:
+			return nil, k8s.ErrorNotFound("not found")
+		case err != nil:
+			return nil, err
+		}
+		return &metav1.PartialObjectMetadata{}, nil
+	})
+}
+
+// issuerApp decodes an App with an Ingress that uses the ClusterIssuer issuer.
+func issuerApp(t *testing.T, issuer string, strict bool) v1.App {
+	t.Helper()
+
+	return decode(t, fmt.Sprintf(`
+apiVersion: x.within.website/v1
+kind: App
+metadata:
+  name: stickers
+  namespace: default
+spec:
+  image: ghcr.io/xe/x/stickers:latest
+  strictReferences: %t
+  ingress:
+    enabled: true
+    host: stickers.within.website
+    clusterIssuer: %s
+`, strict, issuer))
+}
+
+func TestCheckClusterIssuer(t *testing.T) {
+	// yoke refuses lookups of objects owned by another release, which is how existing issuers usually come back.
+	owned := k8s.ErrorForbidden("owned by another release")
+
+	for _, tt := range []struct {
+		name    string
+		issuer  string
+		issuers map[string]error
+		wantErr string
+	}{
+		{
+			name:    "found",
+			issuer:  "letsencrypt-prod",
+			issuers: map[string]error{"letsencrypt-prod": nil},
+		},
+		{
+			name:    "owned by another release",
+			issuer:  "letsencrypt-prod",
+			issuers: map[string]error{"letsencrypt-prod": owned},
+		},
+		{
+			name:    "no cluster access",
+			issuer:  "letsencypt-prod",
+			issuers: map[string]error{"letsencypt-prod": k8s.ErrorClusterAccessNotGranted},
+		},
+		{
+			name:    "typo",
+			issuer:  "letsencypt-prod",
+			issuers: map[string]error{"letsencrypt-prod": owned},
+			wantErr: "cluster issuer letsencypt-prod does not exist, existing issuers include letsencrypt-prod",
+		},
+		{
+			name:    "typo with both well-known issuers",
+			issuer:  "letsencypt-prod",
+			issuers: map[string]error{"letsencrypt-prod": owned, "letsencrypt-staging": nil},
+			wantErr: "cluster issuer letsencypt-prod does not exist, existing issuers include letsencrypt-prod, letsencrypt-staging",
+		},
+		{
+			name:    "no issuers",
+			issuer:  "letsencrypt-prod",
+			wantErr: "cluster issuer letsencrypt-prod does not exist",
+		},
+		{
+			name:    "lookup fails",
+			issuer:  "letsencrypt-prod",
+			issuers: map[string]error{"letsencrypt-prod": errors.New("connection refused")},
+			wantErr: "failed to look up cluster issuer letsencrypt-prod: connection refused",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stubClusterIssuers(t, tt.issuers)
+
+			err := checkClusterIssuer(issuerApp(t, tt.issuer, false))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("checkClusterIssuer() failed: %v", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckIngressClusterIssuer(t *testing.T) {
+	t.Run("missing warns", func(t *testing.T) {
+		stubClusterIssuers(t, nil)
+
+		report := &renderreport.Report{}
+		if err := checkIngressClusterIssuer(issuerApp(t, "letsencypt-prod", false), report); err != nil {
+			t.Fatalf("checkIngressClusterIssuer() failed without strictReferences: %v", err)
+		}
+		if len(report.Decisions) != 1 || report.Decisions[0].Reason != "MissingClusterIssuer" {
+			t.Errorf("got decisions %+v, want MissingClusterIssuer", report.Decisions)
+		}
+	})
+
+	t.Run("missing fails with strictReferences", func(t *testing.T) {
+		stubClusterIssuers(t, nil)
+
+		if err := checkIngressClusterIssuer(issuerApp(t, "letsencypt-prod", true), nil); err == nil {
+			t.Error("checkIngressClusterIssuer() didn't fail with strictReferences")
+		}
+	})
+
+	t.Run("forbidden is silent", func(t *testing.T) {
+		stubClusterIssuers(t, map[string]error{"letsencypt-prod": k8s.ErrorForbidden("forbidden")})
+
+		report := &renderreport.Report{}
+		if err := checkIngressClusterIssuer(issuerApp(t, "letsencypt-prod", true), report); err != nil {
+			t.Fatalf("checkIngressClusterIssuer() failed: %v", err)
+		}
+		if len(report.Decisions) != 0 {
+			t.Errorf("got decisions %+v, want none", report.Decisions)
+		}
+	})
+}
+
+func TestCheckQuota(t *testing.T) {
+	app := decode(t, `
+apiVersion: x.within.website/v1
+kind: App
+metadata:
+  name: stickers
+  namespace: default
+spec:
+  image: ghcr.io/xe/x/stickers:latest
+  replicas: 2
+  resources:
+    requests:
+      cpu: 500m
+      memory: 256Mi
+  resourcePolicy:
+    quota: compute
+`)
+
+	// quota has hard limits and usage for the keys in the form "key=hard/used".
+	quota := func(entries ...string) *corev1.ResourceQuota {
+		result := &corev1.ResourceQuota{}
+		result.Status.Hard = corev1.ResourceList{}
+		result.Status.Used = corev1.ResourceList{}
+		for _, entry := range entries {
+			key, amounts, _ := strings.Cut(entry, "=")
+			hard, used, _ := strings.Cut(amounts, "/")
+			result.Status.Hard[corev1.ResourceName(key)] = resource.MustParse(hard)
+			result.Status.Used[corev1.ResourceName(key)] = resource.MustParse(used)
+		}
+		return result
+	}
+
+	for _, tt := range []struct {
+		name    string
+		quota   *corev1.ResourceQuota
+		err     error
+		wantErr string
+		// want are the reasons of the reported decisions, with the resource for OverQuota.
+		want []string
+	}{
+		{
+			name:  "fits",
+			quota: quota("requests.cpu=2/1", "requests.memory=1Gi/512Mi"),
+		},
+		{
+			name:  "over requests.cpu",
+			quota: quota("requests.cpu=2/1500m", "requests.memory=1Gi/512Mi"),
+			want:  []string{"OverQuota requests.cpu"},
+		},
+		{
+			name:  "over the bare memory key",
+			quota: quota("cpu=4/0", "memory=1Gi/900Mi"),
+			want:  []string{"OverQuota memory"},
+		},
+		{
+			name:  "over both forms",
+			quota: quota("requests.memory=1Gi/900Mi", "memory=1Gi/900Mi"),
+			want:  []string{"OverQuota requests.memory", "OverQuota memory"},
+		},
+		{
+			name:  "other resources aren't compared",
+			quota: quota("requests.nvidia.com/gpu=1/1", "pods=10/10"),
+		},
+		{
+			name: "not found",
+			err:  k8s.ErrorNotFound("not found"),
+			want: []string{"MissingResourceQuota"},
+		},
+		{
+			name: "forbidden",
+			err:  k8s.ErrorForbidden("forbidden"),
+			want: []string{"QuotaUnchecked"},
+		},
+		{
+			name:    "lookup fails",
+			err:     errors.New("connection refused"),
+			wantErr: "failed to look up resource quota compute: connection refused",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stub.Set(t, &lookupResourceQuota, func(namespace, name string) (*corev1.ResourceQuota, error) {
+				if namespace != "default" || name != "compute" {
+					t.Errorf("looked up resource quota %s/%s, want default/compute", namespace, name)
+				}
+				return tt.quota, tt.err
+			})
+
+			var report renderreport.Report
+			err := checkQuota(app, &report)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("checkQuota() failed: %v", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+
+			var got []string
+			for _, decision := range report.Decisions {
+				got = append(got, strings.TrimSpace(decision.Reason+" "+decision.Details["resource"]))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got decisions %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
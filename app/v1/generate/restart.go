@@ -0,0 +1,176 @@
+package generate
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+)
+
+// restartSchedule derives a cron schedule that restarts the App at least once every maxPodLifetime.
+// The interval is rounded down to something cron can express evenly, and the minute is derived from
+// the App name so that many Apps with the same lifetime don't all restart at once.
+func restartSchedule(app v1.App) string {
+	lifetime := app.Spec.RestartPolicy.MaxPodLifetime.Duration
+
+	h := fnv.New32a()
+	h.Write([]byte(app.Namespace + "/" + app.Name))
+	minute := h.Sum32() % 60
+
+	switch {
+	case lifetime < 24*time.Hour:
+		hours := int(lifetime / time.Hour)
+		// Only divisors of 24 give an even interval across midnight.
+		for _, step := range []int{12, 8, 6, 4, 3, 2, 1} {
+			if step <= hours {
+				if step == 1 {
+					return fmt.Sprintf("%d * * * *", minute)
+				}
+				return fmt.Sprintf("%d */%d * * *", minute, step)
+			}
+		}
+		return fmt.Sprintf("%d * * * *", minute)
+	case lifetime < 7*24*time.Hour:
+		days := int(lifetime / (24 * time.Hour))
+		if days == 1 {
+			return fmt.Sprintf("%d 0 * * *", minute)
+		}
+		// Day-of-month steps reset at the start of every month, which only ever makes the interval shorter.
+		return fmt.Sprintf("%d 0 */%d * *", minute, days)
+	default:
+		return fmt.Sprintf("%d 0 * * 0", minute)
+	}
+}
+
+func restartName(app v1.App) string {
+	return app.Name + "-restart"
+}
+
+// CreateRestartCronJob restarts the App on the restart schedule with kubectl rollout restart.
+func CreateRestartCronJob(app v1.App) *batchv1.CronJob {
+	return &batchv1.CronJob{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: batchv1.SchemeGroupVersion.Identifier(),
+			Kind:       "CronJob",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      restartName(app),
+			Namespace: app.Namespace,
+			Labels:    app.Labels,
+		},
+		Spec: batchv1.CronJobSpec{
+			Schedule:                   restartSchedule(app),
+			ConcurrencyPolicy:          batchv1.ForbidConcurrent,
+			SuccessfulJobsHistoryLimit: ptr.To[int32](1),
+			FailedJobsHistoryLimit:     ptr.To[int32](1),
+			JobTemplate: batchv1.JobTemplateSpec{
+				Spec: batchv1.JobSpec{
+					BackoffLimit: ptr.To[int32](2),
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							ServiceAccountName: restartName(app),
+							RestartPolicy:      corev1.RestartPolicyNever,
+							Containers: []corev1.Container{
+								{
+									Name:  "kubectl",
+									Image: "docker.io/bitnami/kubectl:latest",
+									Args: []string{
+										"rollout", "restart",
+										"--namespace", app.Namespace,
+										app.Spec.Workload + "/" + app.Name,
+									},
+									SecurityContext: &corev1.SecurityContext{
+										RunAsUser:                ptr.To[int64](1000),
+										RunAsGroup:               ptr.To[int64](1000),
+										RunAsNonRoot:             ptr.To(true),
+										AllowPrivilegeEscalation: ptr.To(false),
+										Capabilities: &corev1.Capabilities{
+											Drop: []corev1.Capability{"ALL"},
+										},
+										SeccompProfile: &corev1.SeccompProfile{
+											Type: corev1.SeccompProfileTypeRuntimeDefault,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// CreateRestartServiceAccount creates the ServiceAccount that CreateRestartCronJob runs as.
+func CreateRestartServiceAccount(app v1.App) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.Identifier(),
+			Kind:       "ServiceAccount",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      restartName(app),
+			Namespace: app.Namespace,
+			Labels:    app.Labels,
+		},
+		AutomountServiceAccountToken: ptr.To(true),
+	}
+}
+
+// CreateRestartRole only grants access to the App's own Deployment or StatefulSet, rollout restart needs get and patch.
+func CreateRestartRole(app v1.App) *rbacv1.Role {
+	return &rbacv1.Role{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.Identifier(),
+			Kind:       "Role",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      restartName(app),
+			Namespace: app.Namespace,
+			Labels:    app.Labels,
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups:     []string{"apps"},
+				Resources:     []string{app.Spec.Workload + "s"},
+				ResourceNames: []string{app.Name},
+				Verbs:         []string{"get", "patch"},
+			},
+		},
+	}
+}
+
+// CreateRestartRoleBinding binds the Role from CreateRestartRole to the ServiceAccount from
+// CreateRestartServiceAccount.
+func CreateRestartRoleBinding(app v1.App) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.Identifier(),
+			Kind:       "RoleBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      restartName(app),
+			Namespace: app.Namespace,
+			Labels:    app.Labels,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      "ServiceAccount",
+				Name:      restartName(app),
+				Namespace: app.Namespace,
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     restartName(app),
+		},
+	}
+}
@@ -0,0 +1,118 @@
+package generate
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+)
+
+// restartApp returns an App named name that is restarted at least once every lifetime.
+func restartApp(name string, lifetime time.Duration) v1.App {
+	var app v1.App
+	app.Name = name
+	app.Namespace = "default"
+	app.Spec.RestartPolicy = &v1.RestartPolicy{MaxPodLifetime: metav1.Duration{Duration: lifetime}}
+	return app
+}
+
+func TestRestartSchedule(t *testing.T) {
+	for _, tt := range []struct {
+		lifetime time.Duration
+		// want is the schedule without its minute.
+		want string
+	}{
+		{time.Hour, "* * * *"},
+		{90 * time.Minute, "* * * *"},
+		{2 * time.Hour, "*/2 * * *"},
+		{5 * time.Hour, "*/4 * * *"},
+		{6 * time.Hour, "*/6 * * *"},
+		{7 * time.Hour, "*/6 * * *"},
+		{12 * time.Hour, "*/12 * * *"},
+		{23 * time.Hour, "*/12 * * *"},
+		{24 * time.Hour, "0 * * *"},
+		{47 * time.Hour, "0 * * *"},
+		{48 * time.Hour, "0 */2 * *"},
+		{6 * 24 * time.Hour, "0 */6 * *"},
+		{7 * 24 * time.Hour, "0 * * 0"},
+		{30 * 24 * time.Hour, "0 * * 0"},
+	} {
+		t.Run(tt.lifetime.String(), func(t *testing.T) {
+			got := restartSchedule(restartApp("stickers", tt.lifetime))
+
+			minute, rest, _ := strings.Cut(got, " ")
+			if rest != tt.want {
+				t.Errorf("restartSchedule() = %q, want %q after the minute", got, tt.want)
+			}
+			var m int
+			if _, err := fmt.Sscan(minute, &m); err != nil || m < 0 || m > 59 {
+				t.Errorf("restartSchedule() = %q, want a minute between 0 and 59", got)
+			}
+
+			// Over a year, including the ends of months and a leap day, no two restarts are further apart than
+			// the lifetime.
+			schedule, err := cron.ParseStandard(got)
+			if err != nil {
+				t.Fatalf("restartSchedule() = %q, which isn't a valid schedule: %v", got, err)
+			}
+			prev := schedule.Next(time.Date(2028, 1, 1, 0, 0, 0, 0, time.UTC))
+			for prev.Year() == 2028 {
+				next := schedule.Next(prev)
+				if gap := next.Sub(prev); gap > tt.lifetime {
+					t.Fatalf("restartSchedule() = %q restarts %s after %s, longer than %s", got, gap, prev, tt.lifetime)
+				}
+				prev = next
+			}
+		})
+	}
+}
+
+func TestRestartScheduleMinute(t *testing.T) {
+	// The minute only depends on the App, so renders don't move the schedule around.
+	a := restartSchedule(restartApp("stickers", 24*time.Hour))
+	if b := restartSchedule(restartApp("stickers", 24*time.Hour)); a != b {
+		t.Errorf("two renders of the same App got %q and %q", a, b)
+	}
+
+	// Apps with the same lifetime are spread over the hour.
+	minutes := map[string]bool{}
+	for i := range 20 {
+		schedule := restartSchedule(restartApp(fmt.Sprintf("app%d", i), 24*time.Hour))
+		minute, _, _ := strings.Cut(schedule, " ")
+		minutes[minute] = true
+	}
+	if len(minutes) < 10 {
+		t.Errorf("20 Apps only restart at %d different minutes", len(minutes))
+	}
+}
+
+func TestRestartRoleIsScopedToTheWorkload(t *testing.T) {
+	for _, workload := range []string{"deployment", "statefulset"} {
+		t.Run(workload, func(t *testing.T) {
+			app := restartApp("stickers", 24*time.Hour)
+			app.Spec.Workload = workload
+
+			role := CreateRestartRole(app)
+			if len(role.Rules) != 1 {
+				t.Fatalf("got rules %+v, want exactly one", role.Rules)
+			}
+			rule := role.Rules[0]
+			if len(rule.Resources) != 1 || rule.Resources[0] != workload+"s" {
+				t.Errorf("rule covers %v, want only %ss", rule.Resources, workload)
+			}
+			if len(rule.ResourceNames) != 1 || rule.ResourceNames[0] != "stickers" {
+				t.Errorf("rule covers names %v, want only stickers", rule.ResourceNames)
+			}
+
+			args := CreateRestartCronJob(app).Spec.JobTemplate.Spec.Template.Spec.Containers[0].Args
+			if target := args[len(args)-1]; target != workload+"/stickers" {
+				t.Errorf("cron job restarts %s, want %s/stickers", target, workload)
+			}
+		})
+	}
+}
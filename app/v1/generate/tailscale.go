@@ -0,0 +1,132 @@
+package generate
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+)
+
+// tailscaleStateDir is where the Tailscale sidecar keeps its tailnet state, so a restarted sidecar with the
+// storage state comes back as the same machine.
+const tailscaleStateDir = "/var/lib/tailscale"
+
+// TailscaleServeConfig is the ConfigMap with the serve config of the Tailscale sidecar. It proxies HTTP on port 80
+// and HTTPS on port 443 of the App's tailnet name to the App port. containerboot fills in ${TS_CERT_DOMAIN} with
+// the machine's name on the tailnet.
+func TailscaleServeConfig(app v1.App) v1.ConfigMap {
+	handlers := map[string]any{
+		"Handlers": map[string]any{
+			"/": map[string]string{"Proxy": fmt.Sprintf("http://127.0.0.1:%d", app.Spec.Port)},
+		},
+	}
+	config := map[string]any{
+		"TCP": map[string]any{
+			"80":  map[string]bool{"HTTP": true},
+			"443": map[string]bool{"HTTPS": true},
+		},
+		"Web": map[string]any{
+			"${TS_CERT_DOMAIN}:80":  handlers,
+			"${TS_CERT_DOMAIN}:443": handlers,
+		},
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+
+	return v1.ConfigMap{
+		Name: "tailscale",
+		Data: map[string]string{"serve.json": string(data)},
+	}
+}
+
+// addTailscaleSidecar puts the App on the tailnet with a Tailscale container next to it. tailscaled runs in
+// userspace networking mode, so the sidecar needs no extra capabilities and can run as non-root like the App.
+func addTailscaleSidecar(app v1.App, spec *corev1.PodSpec) {
+	ts := app.Spec.Tailscale
+
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: "tailscale-serve",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: app.Name + "-" + TailscaleServeConfig(app).GenName(),
+				},
+			},
+		},
+	})
+
+	state := corev1.VolumeMount{
+		Name:      "tailscale-state",
+		MountPath: tailscaleStateDir,
+	}
+	if ts.State == "storage" {
+		state.Name = "storage"
+		state.SubPath = "tailscale"
+	} else {
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name:         "tailscale-state",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+	}
+
+	spec.Containers = append(spec.Containers, corev1.Container{
+		Name:            "tailscale",
+		Image:           ts.Image,
+		ImagePullPolicy: corev1.PullPolicy(cmp.Or(app.Spec.ImagePullPolicy, "Always")),
+		Env: []corev1.EnvVar{
+			{
+				Name: "TS_AUTHKEY",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: fmt.Sprintf("%s-%s", app.Name, ts.AuthKeySecret)},
+						Key:                  "authkey",
+					},
+				},
+			},
+			// With the storage state the machine stays logged in, so the auth key is only needed the first time.
+			{Name: "TS_AUTH_ONCE", Value: "true"},
+			{Name: "TS_HOSTNAME", Value: tailscaleHostname(app)},
+			{Name: "TS_USERSPACE", Value: "true"},
+			{Name: "TS_STATE_DIR", Value: tailscaleStateDir},
+			// containerboot would otherwise keep its state in a Secret, which the App's ServiceAccount can't
+			// write to.
+			{Name: "TS_KUBE_SECRET", Value: ""},
+			{Name: "TS_SERVE_CONFIG", Value: "/etc/tailscale/serve.json"},
+		},
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser:                ptr.To[int64](1000),
+			RunAsGroup:               ptr.To[int64](1000),
+			RunAsNonRoot:             ptr.To(true),
+			AllowPrivilegeEscalation: ptr.To(false),
+			Capabilities: &corev1.Capabilities{
+				Drop: []corev1.Capability{"ALL"},
+			},
+			SeccompProfile: &corev1.SeccompProfile{
+				Type: corev1.SeccompProfileTypeRuntimeDefault,
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			state,
+			{
+				Name:      "tailscale-serve",
+				MountPath: "/etc/tailscale",
+				ReadOnly:  true,
+			},
+		},
+	})
+}
+
+// tailscaleHostname is the machine name of the App on the tailnet.
+func tailscaleHostname(app v1.App) string {
+	if app.Spec.Tailscale.Hostname != "" {
+		return app.Spec.Tailscale.Hostname
+	}
+	return app.Name
+}
@@ -0,0 +1,152 @@
+// Package v2 is the x.within.website/v2 App API. It is the v1 API with a few of its warts cleaned up:
+//
+//   - healthcheck is enabled by being set instead of with a separate enabled flag.
+//   - ingress takes a list of hosts instead of a single host.
+//
+// Everything else is the same as in v1. v1 is still the storage version, so v2 Apps are converted to v1 with
+// ConvertToV1 before they are stored or rendered, and v2 can only hold what v1 can: ingress.hosts takes one host
+// for now.
+package v2
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+)
+
+const (
+	APIVersion = "x.within.website/v2"
+	KindApp    = "App"
+)
+
+// App represents a backend application with opinionated defaults.
+type App struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              AppSpec `json:"spec" description:"The desired state of the App."`
+}
+
+// AppSpec is v1.AppSpec with the fields that changed in v2 replaced. The fields here shadow the ones of the same
+// name in v1.AppSpec, both in JSON and in the generated schema.
+type AppSpec struct {
+	v1.AppSpec
+
+	Healthcheck *Healthcheck `json:"healthcheck,omitempty" yaml:"healthcheck,omitempty" description:"Liveness and readiness probe settings. Health checks are enabled when this is set."`
+	Ingress     *Ingress     `json:"ingress,omitempty" yaml:"ingress,omitempty" description:"Settings for exposing the App to the public Internet over HTTP."`
+}
+
+type Healthcheck struct {
+	Path    string            `json:"path,omitempty" yaml:"path,omitempty" description:"The HTTP path to check. Defaults to /."`
+	Port    int               `json:"port,omitempty" yaml:"port,omitempty" description:"The port to check. Defaults to the App port."`
+	Kind    string            `json:"kind,omitempty" yaml:"kind,omitempty" description:"The kind of health check: http (default) or grpc." Enum:"http,grpc"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty" description:"The HTTP headers the probes send, such as Host or Authorization. Defaults to X-Kubernetes: is kinda okay." example:"{\"Host\": \"stickers.within.website\"}"`
+}
+
+func (h *Healthcheck) UnmarshalJSON(data []byte) error {
+	type HealthcheckAlt Healthcheck
+	if err := json.Unmarshal(data, (*HealthcheckAlt)(h)); err != nil {
+		return err
+	}
+	if h.Path == "" {
+		h.Path = "/"
+	}
+	switch h.Kind {
+	case "":
+		h.Kind = "http"
+	case "grpc", "http":
+		// all is good
+	default:
+		return fmt.Errorf("Healthcheck: unknown kind %q", h.Kind)
+	}
+	return nil
+}
+
+type Ingress struct {
+	Enabled         bool               `json:"enabled" yaml:"enabled" description:"If true, create an HTTP Ingress for this App."`
+	Kind            string             `json:"kind,omitempty" yaml:"kind,omitempty" description:"The kind of traffic the App serves, set to grpc for gRPC backends."`
+	Hosts           []string           `json:"hosts,omitempty" yaml:"hosts,omitempty" description:"The HTTP hostnames for the Ingress. Only one is supported until v2 is the storage version." example:"[\"stickers.within.website\"]" MaxItems:"1"`
+	TLS             *bool              `json:"tls,omitempty" yaml:"tls,omitempty" description:"If false, serve the App over plain HTTP without a certificate, for clusters without cert-manager. Defaults to true."`
+	TLSSecretName   string             `json:"tlsSecretName,omitempty" yaml:"tlsSecretName,omitempty" description:"If set, use this existing certificate Secret, such as a shared wildcard certificate, instead of having cert-manager issue one." example:"within-website-wildcard-tls"`
+	ClusterIssuer   string             `json:"clusterIssuer,omitempty" yaml:"clusterIssuer,omitempty" description:"The cert-manager ClusterIssuer for the certificate. Defaults to letsencrypt-prod. Can't be used with tls: false or tlsSecretName."`
+	ClassName       string             `json:"className,omitempty" yaml:"className,omitempty" description:"The ingress class the Ingress should use. Defaults to nginx."`
+	EnableCoreRules bool               `json:"enableCoreRules,omitempty" yaml:"enableCoreRules,omitempty" description:"If true, enable ModSecurity with the OWASP core rule set."`
+	Annotations     map[string]string  `json:"annotations,omitempty" yaml:"annotations,omitempty" description:"Additional annotations added to the Ingress."`
+	Gateway         *v1.GatewayRef     `json:"gateway,omitempty" yaml:"gateway,omitempty" description:"If set, attach an HTTPRoute (or a GRPCRoute for gRPC Apps) to this Gateway API Gateway instead of creating an Ingress."`
+	Allowlist       []string           `json:"allowlist,omitempty" yaml:"allowlist,omitempty" description:"If set, only these CIDRs can reach the App, everyone else is refused. Only supported with ingress-nginx and Traefik." example:"[\"10.0.0.0/8\", \"192.168.1.0/24\"]"`
+	BasicAuth       *v1.BasicAuth      `json:"basicAuth,omitempty" yaml:"basicAuth,omitempty" description:"If set, require HTTP basic auth for the App. Only supported with ingress-nginx."`
+	RateLimit       *v1.RateLimit      `json:"rateLimit,omitempty" yaml:"rateLimit,omitempty" description:"If set, limit how fast each client can make requests. Only supported with ingress-nginx."`
+	Paths           []v1.IngressPath   `json:"paths,omitempty" yaml:"paths,omitempty" description:"The paths the Ingress routes. Defaults to sending everything under / to the App."`
+	DefaultBackend  *v1.IngressBackend `json:"defaultBackend,omitempty" yaml:"defaultBackend,omitempty" description:"If set, where the Ingress sends requests that don't match any of its hosts and paths."`
+	RedirectFrom    []string           `json:"redirectFrom,omitempty" yaml:"redirectFrom,omitempty" description:"Hostnames that are permanently redirected to the Ingress host, such as the www subdomain. Only supported with ingress-nginx." example:"[\"www.within.website\"]"`
+}
+
+func (i *Ingress) UnmarshalJSON(data []byte) error {
+	type IngressAlt Ingress
+	if err := json.Unmarshal(data, (*IngressAlt)(i)); err != nil {
+		return err
+	}
+	if i.Enabled && len(i.Hosts) == 0 {
+		return fmt.Errorf("at least one host is required when ingress is enabled")
+	}
+	if len(i.Hosts) > 1 {
+		return fmt.Errorf("ingress.hosts only supports one host for now, got %d", len(i.Hosts))
+	}
+	switch {
+	case i.TLS != nil && !*i.TLS:
+		if i.ClusterIssuer != "" {
+			return fmt.Errorf("ingress.clusterIssuer can't be used with tls: false")
+		}
+		if i.TLSSecretName != "" {
+			return fmt.Errorf("ingress.tlsSecretName can't be used with tls: false")
+		}
+	case i.TLSSecretName != "":
+		if errs := validation.IsDNS1123Subdomain(i.TLSSecretName); len(errs) != 0 {
+			return fmt.Errorf("ingress: invalid tlsSecretName %q: %s", i.TLSSecretName, strings.Join(errs, ", "))
+		}
+		// The certificate comes from the Secret, so there is nothing for cert-manager to issue.
+		if i.ClusterIssuer != "" {
+			return fmt.Errorf("ingress.clusterIssuer can't be used with tlsSecretName")
+		}
+	case i.Enabled && i.ClusterIssuer == "":
+		i.ClusterIssuer = "letsencrypt-prod"
+	}
+	if i.Enabled && i.ClassName == "" {
+		i.ClassName = "nginx"
+	}
+	return nil
+}
+
+func (app App) MarshalJSON() ([]byte, error) {
+	app.Kind = KindApp
+	app.APIVersion = APIVersion
+
+	type AppAlt App
+	return json.Marshal(AppAlt(app))
+}
+
+// Custom Unmarshalling to raise an error if the ApiVersion or Kind does not match. The rest of the App is
+// checked the same way as a v1 App, by converting it to v1.
+func (app *App) UnmarshalJSON(data []byte) error {
+	type AppAlt App
+	if err := json.Unmarshal(data, (*AppAlt)(app)); err != nil {
+		return err
+	}
+	if app.APIVersion != APIVersion {
+		return fmt.Errorf("unexpected api version: expected %s but got %s", APIVersion, app.APIVersion)
+	}
+	if app.Kind != KindApp {
+		return fmt.Errorf("unexpected kind: expected %s but got %s", KindApp, app.Kind)
+	}
+
+	converted, err := ConvertToV1(data)
+	if err != nil {
+		return err
+	}
+	var stored v1.App
+	return json.Unmarshal(converted, &stored)
+}
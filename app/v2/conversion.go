@@ -0,0 +1,84 @@
+package v2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+)
+
+// ConvertToV2 converts the JSON of a x.within.website/v1 App to x.within.website/v2. Only the fields that changed
+// are touched, everything else is copied as it is, so ConvertToV1 gives back the same App. The App isn't
+// validated or defaulted, so that what is stored doesn't change just by being read.
+func ConvertToV2(data []byte) ([]byte, error) {
+	obj, spec, err := decodeObject(data)
+	if err != nil {
+		return nil, err
+	}
+	obj["apiVersion"] = APIVersion
+
+	if healthcheck, ok := spec["healthcheck"].(map[string]any); ok {
+		if enabled, _ := healthcheck["enabled"].(bool); enabled {
+			delete(healthcheck, "enabled")
+		} else {
+			delete(spec, "healthcheck")
+		}
+	}
+
+	if ingress, ok := spec["ingress"].(map[string]any); ok {
+		if host, _ := ingress["host"].(string); host != "" {
+			ingress["hosts"] = []any{host}
+		}
+		delete(ingress, "host")
+	}
+
+	return json.Marshal(obj)
+}
+
+// ConvertToV1 converts the JSON of a x.within.website/v2 App to x.within.website/v1. An App with more than one
+// ingress host can't be converted.
+func ConvertToV1(data []byte) ([]byte, error) {
+	obj, spec, err := decodeObject(data)
+	if err != nil {
+		return nil, err
+	}
+	obj["apiVersion"] = v1.APIVersion
+
+	if healthcheck, ok := spec["healthcheck"].(map[string]any); ok {
+		healthcheck["enabled"] = true
+	}
+
+	if ingress, ok := spec["ingress"].(map[string]any); ok {
+		hosts, _ := ingress["hosts"].([]any)
+		if len(hosts) > 1 {
+			return nil, fmt.Errorf("ingress.hosts only supports one host for now, got %d", len(hosts))
+		}
+
+		// host is required in v1.
+		ingress["host"] = ""
+		if len(hosts) == 1 {
+			ingress["host"] = hosts[0]
+		}
+		delete(ingress, "hosts")
+	}
+
+	return json.Marshal(obj)
+}
+
+// decodeObject decodes an App into plain JSON values, keeping numbers as they were written.
+func decodeObject(data []byte) (obj, spec map[string]any, err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode App: %w", err)
+	}
+
+	spec, _ = obj["spec"].(map[string]any)
+	if spec == nil {
+		spec = map[string]any{}
+	}
+	obj["spec"] = spec
+
+	return obj, spec, nil
+}
@@ -0,0 +1,167 @@
+package v2
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "github.com/Xe/yoke-stuff/app/v1"
+)
+
+// assertJSONEqual fails the test when got and want aren't the same JSON, ignoring formatting and key order.
+func assertJSONEqual(t *testing.T, got []byte, want string) {
+	t.Helper()
+
+	var gotValue, wantValue any
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("got invalid JSON %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("bad test case, invalid JSON %s: %v", want, err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestRoundTripV1(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		v1   string
+		v2   string
+		// back is what v1 comes back as, if it isn't v1 itself.
+		back string
+	}{
+		{
+			name: "minimal",
+			v1:   `{"apiVersion":"x.within.website/v1","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest"}}`,
+			v2:   `{"apiVersion":"x.within.website/v2","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest"}}`,
+		},
+		{
+			name: "enabled healthcheck",
+			v1:   `{"apiVersion":"x.within.website/v1","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest","healthcheck":{"enabled":true,"path":"/healthz","port":9000}}}`,
+			v2:   `{"apiVersion":"x.within.website/v2","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest","healthcheck":{"path":"/healthz","port":9000}}}`,
+		},
+		{
+			name: "disabled healthcheck is dropped",
+			v1:   `{"apiVersion":"x.within.website/v1","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest","healthcheck":{"enabled":false,"path":"/healthz"}}}`,
+			v2:   `{"apiVersion":"x.within.website/v2","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest"}}`,
+			back: `{"apiVersion":"x.within.website/v1","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest"}}`,
+		},
+		{
+			name: "ingress host",
+			v1:   `{"apiVersion":"x.within.website/v1","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest","ingress":{"enabled":true,"host":"stickers.within.website","clusterIssuer":"letsencrypt-staging"}}}`,
+			v2:   `{"apiVersion":"x.within.website/v2","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest","ingress":{"enabled":true,"hosts":["stickers.within.website"],"clusterIssuer":"letsencrypt-staging"}}}`,
+		},
+		{
+			name: "disabled ingress without host",
+			v1:   `{"apiVersion":"x.within.website/v1","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest","ingress":{"enabled":false,"host":""}}}`,
+			v2:   `{"apiVersion":"x.within.website/v2","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest","ingress":{"enabled":false}}}`,
+		},
+		{
+			name: "unchanged fields and numbers are kept",
+			v1:   `{"apiVersion":"x.within.website/v1","kind":"App","metadata":{"name":"stickers","namespace":"default","annotations":{"a":"b"}},"spec":{"image":"ghcr.io/xe/x/stickers:latest","replicas":0,"port":8080,"storage":{"enabled":true,"path":"/data","size":"1Gi"},"resources":{"limits":{"cpu":"1.5"}}}}`,
+			v2:   `{"apiVersion":"x.within.website/v2","kind":"App","metadata":{"name":"stickers","namespace":"default","annotations":{"a":"b"}},"spec":{"image":"ghcr.io/xe/x/stickers:latest","replicas":0,"port":8080,"storage":{"enabled":true,"path":"/data","size":"1Gi"},"resources":{"limits":{"cpu":"1.5"}}}}`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			converted, err := ConvertToV2([]byte(tt.v1))
+			if err != nil {
+				t.Fatalf("ConvertToV2() failed: %v", err)
+			}
+			assertJSONEqual(t, converted, tt.v2)
+
+			var app App
+			if err := json.Unmarshal(converted, &app); err != nil {
+				t.Errorf("the converted App isn't a valid v2 App: %v", err)
+			}
+
+			back, err := ConvertToV1(converted)
+			if err != nil {
+				t.Fatalf("ConvertToV1() failed: %v", err)
+			}
+			want := tt.back
+			if want == "" {
+				want = tt.v1
+			}
+			assertJSONEqual(t, back, want)
+		})
+	}
+}
+
+func TestRoundTripV2(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		v2      string
+		v1      string
+		wantErr string
+	}{
+		{
+			name: "minimal",
+			v2:   `{"apiVersion":"x.within.website/v2","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest"}}`,
+			v1:   `{"apiVersion":"x.within.website/v1","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest"}}`,
+		},
+		{
+			name: "empty healthcheck",
+			v2:   `{"apiVersion":"x.within.website/v2","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest","healthcheck":{}}}`,
+			v1:   `{"apiVersion":"x.within.website/v1","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest","healthcheck":{"enabled":true}}}`,
+		},
+		{
+			name: "grpc healthcheck",
+			v2:   `{"apiVersion":"x.within.website/v2","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest","healthcheck":{"kind":"grpc","port":9000}}}`,
+			v1:   `{"apiVersion":"x.within.website/v1","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest","healthcheck":{"enabled":true,"kind":"grpc","port":9000}}}`,
+		},
+		{
+			name: "one ingress host",
+			v2:   `{"apiVersion":"x.within.website/v2","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest","ingress":{"enabled":true,"hosts":["stickers.within.website"],"redirectFrom":["www.stickers.within.website"]}}}`,
+			v1:   `{"apiVersion":"x.within.website/v1","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest","ingress":{"enabled":true,"host":"stickers.within.website","redirectFrom":["www.stickers.within.website"]}}}`,
+		},
+		{
+			name: "ingress without hosts",
+			v2:   `{"apiVersion":"x.within.website/v2","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest","ingress":{"enabled":false}}}`,
+			v1:   `{"apiVersion":"x.within.website/v1","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest","ingress":{"enabled":false,"host":""}}}`,
+		},
+		{
+			name:    "more than one ingress host",
+			v2:      `{"apiVersion":"x.within.website/v2","kind":"App","metadata":{"name":"stickers"},"spec":{"image":"ghcr.io/xe/x/stickers:latest","ingress":{"enabled":true,"hosts":["stickers.within.website","stickers.xeserv.us"]}}}`,
+			wantErr: "ingress.hosts only supports one host for now, got 2",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			converted, err := ConvertToV1([]byte(tt.v2))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ConvertToV1() = %s, %v, want an error containing %q", converted, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ConvertToV1() failed: %v", err)
+			}
+			assertJSONEqual(t, converted, tt.v1)
+
+			var app v1.App
+			if err := json.Unmarshal(converted, &app); err != nil {
+				t.Errorf("the converted App isn't a valid v1 App: %v", err)
+			}
+
+			back, err := ConvertToV2(converted)
+			if err != nil {
+				t.Fatalf("ConvertToV2() failed: %v", err)
+			}
+			assertJSONEqual(t, back, tt.v2)
+		})
+	}
+}
+
+func TestConvertInvalidJSON(t *testing.T) {
+	for name, fn := range map[string]func([]byte) ([]byte, error){
+		"ConvertToV1": ConvertToV1,
+		"ConvertToV2": ConvertToV2,
+	} {
+		if _, err := fn([]byte(`{"spec":`)); err == nil {
+			t.Errorf("%s accepted invalid JSON", name)
+		}
+	}
+}
@@ -1,2 +1,2 @@
 $`go tool yeet`;
-$`yoke takeoff appairway oci://reg.xeiaso.net/crds/app/airway:${git.tag()} -- --flight-url=oci://reg.xeiaso.net/crds/app/flight:${git.tag()}`;
+$`yoke takeoff appairway oci://reg.xeiaso.net/crds/app/airway:${git.tag()} -- --flight-url=oci://reg.xeiaso.net/crds/app/flight:${git.tag()} --converter-url=oci://reg.xeiaso.net/crds/app/converter:${git.tag()}`;
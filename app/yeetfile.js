@@ -1,8 +1,10 @@
 $`GOOS=wasip1 GOARCH=wasm go build -o x-app.wasm ./v1/flight`;
 $`GOOS=wasip1 GOARCH=wasm go build -o x-app-airway.wasm ./v1/airway`;
+$`GOOS=wasip1 GOARCH=wasm go build -o x-app-converter.wasm ./converter`;
 
 $`yoke stow ./x-app.wasm oci://registry.int.xeserv.us/crds/app/flight:${git.tag()}`;
 $`yoke stow ./x-app-airway.wasm oci://registry.int.xeserv.us/crds/app/airway:${git.tag()}`;
+$`yoke stow ./x-app-converter.wasm oci://registry.int.xeserv.us/crds/app/converter:${git.tag()}`;
 
 $`gzip -f9 *.wasm`;
 
@@ -11,3 +13,7 @@ file.install(
   "x-app-airway.wasm.gz",
   `../var/x-app-airway-${git.tag()}.wasm.gz`,
 );
+file.install(
+  "x-app-converter.wasm.gz",
+  `../var/x-app-converter-${git.tag()}.wasm.gz`,
+);
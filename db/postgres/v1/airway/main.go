@@ -11,9 +11,9 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/yokecd/yoke/pkg/apis/airway/v1alpha1"
-	"github.com/yokecd/yoke/pkg/openapi"
 
 	v1 "github.com/Xe/yoke-stuff/db/postgres/v1"
+	"github.com/Xe/yoke-stuff/internal/schema"
 )
 
 var (
@@ -53,7 +53,7 @@ func run() error {
 						Served:  true,
 						Storage: true,
 						Schema: &apiextv1.CustomResourceValidation{
-							OpenAPIV3Schema: openapi.SchemaFrom(reflect.TypeFor[v1.Postgres]()),
+							OpenAPIV3Schema: schema.SchemaFrom(reflect.TypeFor[v1.Postgres]()),
 						},
 					},
 				},
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"cmp"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
@@ -18,6 +19,9 @@ import (
 	"k8s.io/utils/ptr"
 
 	v1 "github.com/Xe/yoke-stuff/db/postgres/v1"
+	"github.com/Xe/yoke-stuff/internal/conninfo"
+	"github.com/Xe/yoke-stuff/internal/podmeta"
+	"github.com/Xe/yoke-stuff/internal/renderreport"
 
 	"github.com/yokecd/yoke/pkg/flight/wasi/k8s"
 
@@ -39,11 +43,21 @@ func run() error {
 		return err
 	}
 
-	// Make sure that our labels include our custom selector.
-	if app.Labels == nil {
-		app.Labels = map[string]string{}
+	result, err := render(app)
+	if err != nil {
+		return err
 	}
-	maps.Copy(app.Labels, selector(app))
+
+	// Create our resources (Deployment and Service) and encode them back out via Stdout.
+	return json.NewEncoder(os.Stdout).Encode(result)
+}
+
+// render returns everything the Postgres needs. Its render report is written to the Deployment.
+func render(app v1.Postgres) ([]any, error) {
+	app.Labels = objectLabels(app)
+
+	// Collects the decisions of this render. It is written to the Deployment once everything is rendered.
+	report := &renderreport.Report{}
 
 	var result []any
 
@@ -51,25 +65,40 @@ func run() error {
 		result = append(result, createOnepasswordSecret(app, sec))
 	}
 
-	result = append(result, createDeployment(app))
+	deployment := createDeployment(app)
+	result = append(result, deployment)
 	result = append(result, createService(app))
 
 	// Create a consumer-facing Secret containing DATABASE_URL so other services
 	// can consume a single well-known secret to reach this Postgres instance.
-	result = append(result, createDatabaseSecret(app))
+	dbSecret, err := createDatabaseSecret(app, report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database secret: %w", err)
+	}
+	result = append(result, dbSecret)
 
 	slog.Info("creating deployment and service for", "postgres", app.Name)
 	slog.Info("healthcheck", "hc", app.Spec.Healthcheck)
 	result = append(result, createServiceAccount(app))
 
+	job, err := maintenanceJob(app, report)
+	if err != nil {
+		return nil, err
+	}
+	if job != nil {
+		slog.Info("creating maintenance job for", "postgres", app.Name, "job", job.Name)
+		result = append(result, job)
+	}
+
 	// Storage is present when Size is set in the spec.
 	if app.Spec.Storage.Size != "" {
 		slog.Info("creating storage for", "app", app.Name)
 		result = append(result, createStorage(app))
 	}
 
-	// Create our resources (Deployment and Service) and encode them back out via Stdout.
-	return json.NewEncoder(os.Stdout).Encode(result)
+	report.Annotate(deployment.Annotations)
+
+	return result, nil
 }
 
 func createDeployment(backend v1.Postgres) *appsv1.Deployment {
@@ -91,7 +120,10 @@ func createDeployment(backend v1.Postgres) *appsv1.Deployment {
 			},
 			Selector: &metav1.LabelSelector{MatchLabels: selector(backend)},
 			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{Labels: backend.Labels},
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      podmeta.Merge(backend.Labels, backend.Spec.PodLabels, selector(backend)),
+					Annotations: podmeta.Merge(backend.Spec.PodAnnotations),
+				},
 				Spec: corev1.PodSpec{
 					SecurityContext: &corev1.PodSecurityContext{
 						FSGroup: ptr.To[int64](70),
@@ -105,7 +137,7 @@ func createDeployment(backend v1.Postgres) *appsv1.Deployment {
 					Containers: []corev1.Container{
 						{
 							Name:            "postgres",
-							Image:           "docker.io/postgres:16",
+							Image:           postgresImage(backend),
 							ImagePullPolicy: corev1.PullAlways,
 							SecurityContext: &corev1.SecurityContext{
 								RunAsUser:                ptr.To[int64](70),
@@ -153,6 +185,18 @@ func createDeployment(backend v1.Postgres) *appsv1.Deployment {
 		result.Spec.Template.Spec.Containers[0].Env = append(result.Spec.Template.Spec.Containers[0].Env, backend.Spec.Env...)
 	}
 
+	if backend.Spec.Logging != nil {
+		maps.Copy(result.Spec.Template.Annotations, backend.Spec.Logging.PodAnnotations)
+
+		if backend.Spec.Logging.JSONLogs {
+			configureJSONLogs(backend, result)
+		}
+	}
+
+	if readOnly(backend) {
+		configureReadOnly(backend, result)
+	}
+
 	// Expose generated DB credentials from the conventionally-named secret
 	secretName := backend.Name + "-database"
 	result.Spec.Template.Spec.Containers[0].Env = append(result.Spec.Template.Spec.Containers[0].Env,
@@ -237,6 +281,52 @@ func createDeployment(backend v1.Postgres) *appsv1.Deployment {
 	return result
 }
 
+func postgresImage(backend v1.Postgres) string {
+	return fmt.Sprintf("docker.io/postgres:%d", cmp.Or(backend.Spec.Version, 16))
+}
+
+// configureJSONLogs makes postgres write jsonlog to stdout. jsonlog is only written by the logging
+// collector, which always writes to a file, so the log file is a symlink to the postmaster's stdout
+// (PID 1, the image's entrypoint execs postgres) and rotation is turned off so the name never changes.
+func configureJSONLogs(backend v1.Postgres, result *appsv1.Deployment) {
+	const logDir = "/var/log/postgresql"
+
+	result.Spec.Template.Spec.Volumes = append(result.Spec.Template.Spec.Volumes, corev1.Volume{
+		Name: "logs",
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+
+	container := &result.Spec.Template.Spec.Containers[0]
+	container.Args = append(container.Args,
+		"-c", "logging_collector=on",
+		"-c", "log_destination=jsonlog",
+		"-c", "log_directory="+logDir,
+		"-c", "log_filename=postgres.log",
+		"-c", "log_rotation_age=0",
+		"-c", "log_rotation_size=0",
+	)
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      "logs",
+		MountPath: logDir,
+	})
+
+	result.Spec.Template.Spec.InitContainers = append(result.Spec.Template.Spec.InitContainers, corev1.Container{
+		Name:            "jsonlog-stdout",
+		Image:           container.Image,
+		ImagePullPolicy: container.ImagePullPolicy,
+		SecurityContext: container.SecurityContext,
+		Command:         []string{"ln", "-sf", "/proc/1/fd/1", logDir + "/postgres.json"},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "logs",
+				MountPath: logDir,
+			},
+		},
+	})
+}
+
 func createService(backend v1.Postgres) *corev1.Service {
 	return &corev1.Service{
 		TypeMeta: metav1.TypeMeta{
@@ -285,7 +375,18 @@ func createOnepasswordSecret(app v1.Postgres, sec v1.Secret) *onepasswordv1.OneP
 	return result
 }
 
-func createDatabaseSecret(app v1.Postgres) *corev1.Secret {
+// lookupSecret fetches a Secret from the cluster. It is a variable so it can be swapped out when the flight runs
+// without a cluster.
+var lookupSecret = func(namespace, name string) (*corev1.Secret, error) {
+	return k8s.Lookup[corev1.Secret](k8s.ResourceIdentifier{
+		ApiVersion: "v1",
+		Kind:       "Secret",
+		Name:       name,
+		Namespace:  namespace,
+	})
+}
+
+func createDatabaseSecret(app v1.Postgres, report *renderreport.Report) (*corev1.Secret, error) {
 	// Name the secret <app.Name>-database so consumers can find it by convention.
 	name := app.Name + "-database"
 
@@ -293,34 +394,50 @@ func createDatabaseSecret(app v1.Postgres) *corev1.Secret {
 	// which is named <app.Name>-postgres in the same namespace.
 	svcFQDN := fmt.Sprintf("%s.%s.svc", app.Name+"-postgres", app.Namespace)
 
-	// We'll resolve/generate the password below and then compose a proper DATABASE_URL
-	// that embeds the generated or existing password.
-	dbURL := ""
-
 	// Attempt to look up an existing secret and reuse its password if present.
 	secretName := app.Name + "-database"
-	existing, err := k8s.Lookup[corev1.Secret](k8s.ResourceIdentifier{
-		ApiVersion: "v1",
-		Kind:       "Secret",
-		Name:       secretName,
-		Namespace:  app.Namespace,
-	})
+	existing, err := lookupSecret(app.Namespace, secretName)
 	if err != nil && !k8s.IsErrNotFound(err) {
 		// lookup failed in a way other than not-found; panic because the flight cannot continue reliably.
 		panic(fmt.Errorf("failed to lookup secret: %v", err))
 	}
 
+	var minLength int
+	if app.Spec.Credentials != nil {
+		minLength = app.Spec.Credentials.MinLength
+	}
+
 	password := func() string {
 		if existing != nil {
 			if b, ok := existing.Data["POSTGRES_PASSWORD"]; ok {
+				report.Info("ReusedPassword", "kept the password and connection details of the existing secret", "secret", secretName)
 				return string(b)
 			}
+			report.Warn("RegeneratedPassword", "existing secret has no POSTGRES_PASSWORD, generating a new password", "secret", secretName)
 		}
-		return RandomString()
+		password := RandomString()
+		for len(password) < minLength {
+			password += RandomString()
+		}
+		return password
 	}()
 
-	// Compose final DATABASE_URL using the resolved password.
-	dbURL = fmt.Sprintf("postgres://%s:%s@%s:%d/%s", "postgres", password, svcFQDN, 5432, app.Name)
+	if len(password) < minLength {
+		return nil, fmt.Errorf("password in secret %s is %d characters, credentials.minLength requires at least %d", secretName, len(password), minLength)
+	}
+
+	info := conninfo.Postgres{
+		Host:     svcFQDN,
+		Port:     5432,
+		Database: app.Name,
+		User:     "postgres",
+		Password: password,
+	}
+
+	// DATABASE_URL and POSTGRES_PASSWORD predate secretFormat and are always
+	// included so that existing consumers keep working.
+	data := info.Keys(append([]string{conninfo.FormatURL}, app.Spec.SecretFormat...))
+	data["POSTGRES_PASSWORD"] = password
 
 	result := &corev1.Secret{
 		TypeMeta: metav1.TypeMeta{
@@ -332,14 +449,15 @@ func createDatabaseSecret(app v1.Postgres) *corev1.Secret {
 			Namespace: app.Namespace,
 			Labels:    app.Labels,
 		},
-		StringData: map[string]string{
-			"DATABASE_URL":      dbURL,
-			"POSTGRES_PASSWORD": password,
-		},
-		Type: corev1.SecretTypeOpaque,
+		StringData: data,
+		Type:       corev1.SecretTypeOpaque,
 	}
 
-	return result
+	if readOnly(app) {
+		result.Annotations = map[string]string{readOnlyAnnotation: "true"}
+	}
+
+	return result, nil
 }
 
 func createStorage(app v1.Postgres) *corev1.PersistentVolumeClaim {
@@ -391,6 +509,12 @@ func createServiceAccount(app v1.Postgres) *corev1.ServiceAccount {
 }
 
 // Our selector for our backend application. Independent from the regular labels passed in the backend spec.
+// objectLabels are the labels of every generated object. They include our custom selector, see package podmeta for
+// the precedence order.
+func objectLabels(app v1.Postgres) map[string]string {
+	return podmeta.Merge(app.Labels, app.Spec.CommonLabels, selector(app))
+}
+
 func selector(backend v1.Postgres) map[string]string {
 	return map[string]string{"app.kubernetes.io/name": backend.Name}
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	v1 "github.com/Xe/yoke-stuff/db/postgres/v1"
+	"github.com/Xe/yoke-stuff/internal/renderreport"
+	"github.com/yokecd/yoke/pkg/flight/wasi/k8s"
+)
+
+// readOnlyAnnotation is set on the Deployment and the <name>-database Secret while the database is read-only,
+// so that consumers can watch for maintenance windows.
+const readOnlyAnnotation = "db.x.within.website/read-only"
+
+func readOnly(backend v1.Postgres) bool {
+	return backend.Spec.Maintenance != nil && backend.Spec.Maintenance.ReadOnly
+}
+
+// lookupJob fetches a Job from the cluster. It is a variable so it can be swapped out when the flight runs without
+// a cluster.
+var lookupJob = func(namespace, name string) (*batchv1.Job, error) {
+	return k8s.Lookup[batchv1.Job](k8s.ResourceIdentifier{
+		ApiVersion: batchv1.SchemeGroupVersion.Identifier(),
+		Kind:       "Job",
+		Name:       name,
+		Namespace:  namespace,
+	})
+}
+
+// onlineMaintenance reports whether readOnly is applied by the maintenance Job instead of a restart. The online
+// method doesn't touch the arguments.
+func onlineMaintenance(backend v1.Postgres) bool {
+	return backend.Spec.Maintenance != nil && backend.Spec.Maintenance.Method == "online"
+}
+
+// maintenanceJob returns the Job that sets default_transaction_read_only with ALTER SYSTEM, or nil if none is
+// needed. ALTER SYSTEM persists in postgresql.auto.conf, so switching to the restart method or removing maintenance
+// would leave the server read-only. Once either online Job exists, the -off one keeps being rendered. The restart
+// method's command line argument takes precedence over it.
+func maintenanceJob(backend v1.Postgres, report *renderreport.Report) (*batchv1.Job, error) {
+	if onlineMaintenance(backend) {
+		return createMaintenanceJob(backend, readOnly(backend)), nil
+	}
+
+	for _, on := range []bool{true, false} {
+		name := maintenanceJobName(backend, on)
+		_, err := lookupJob(backend.Namespace, name)
+		switch {
+		case err == nil:
+			slog.Info("online maintenance was used before, keeping default_transaction_read_only off in postgresql.auto.conf", "postgres", backend.Name, "job", name)
+			return createMaintenanceJob(backend, false), nil
+		case k8s.IsErrNotFound(err):
+			// Never run.
+		case k8s.IsErrForbidden(err) || k8s.IsErrUnauthenticated(err) || errors.Is(err, k8s.ErrorClusterAccessNotGranted):
+			report.Info("MaintenanceUnchecked", "can't look up the online maintenance Job, an earlier online maintenance may have left the database read-only", "job", name, "err", err)
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("failed to look up job %s: %w", name, err)
+		}
+	}
+	return nil, nil
+}
+
+// maintenanceJobName is the name of the Job that turns default_transaction_read_only on or off.
+func maintenanceJobName(backend v1.Postgres, on bool) string {
+	state := "off"
+	if on {
+		state = "on"
+	}
+	return fmt.Sprintf("%s-postgres-read-only-%s", backend.Name, state)
+}
+
+// configureReadOnly sets default_transaction_read_only on the postgres command line. Changing the arguments rolls
+// the pod, which is what the restart method is for.
+func configureReadOnly(backend v1.Postgres, result *appsv1.Deployment) {
+	result.Annotations[readOnlyAnnotation] = "true"
+
+	if backend.Spec.Maintenance.Method == "restart" {
+		container := &result.Spec.Template.Spec.Containers[0]
+		container.Args = append(container.Args, "-c", "default_transaction_read_only=on")
+	}
+}
+
+// createMaintenanceJob turns default_transaction_read_only on or off with ALTER SYSTEM and reloads the config, so
+// the running server picks it up without a restart. Jobs are immutable, so the Job is named after the state it
+// sets and yoke replaces it when readOnly is flipped.
+func createMaintenanceJob(backend v1.Postgres, on bool) *batchv1.Job {
+	state := "off"
+	if on {
+		state = "on"
+	}
+
+	return &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: batchv1.SchemeGroupVersion.Identifier(),
+			Kind:       "Job",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      maintenanceJobName(backend, on),
+			Namespace: backend.Namespace,
+			Labels:    backend.Labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: ptr.To[int32](10),
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyOnFailure,
+					Containers: []corev1.Container{
+						{
+							Name:            "read-only",
+							Image:           postgresImage(backend),
+							ImagePullPolicy: corev1.PullAlways,
+							SecurityContext: &corev1.SecurityContext{
+								RunAsUser:                ptr.To[int64](70),
+								RunAsGroup:               ptr.To[int64](70),
+								RunAsNonRoot:             ptr.To(true),
+								AllowPrivilegeEscalation: ptr.To(false),
+								Capabilities: &corev1.Capabilities{
+									Drop: []corev1.Capability{"ALL"},
+								},
+								SeccompProfile: &corev1.SeccompProfile{
+									Type: corev1.SeccompProfileTypeRuntimeDefault,
+								},
+							},
+							// ALTER SYSTEM can't run in a transaction block, so each statement is its own -c.
+							Command: []string{
+								"psql", "-v", "ON_ERROR_STOP=1",
+								"-c", "ALTER SYSTEM SET default_transaction_read_only = " + state,
+								"-c", "SELECT pg_reload_conf()",
+							},
+							Env: []corev1.EnvVar{
+								{Name: "PGHOST", Value: backend.Name + "-postgres"},
+								{Name: "PGUSER", Value: "postgres"},
+								{Name: "PGDATABASE", Value: "postgres"},
+								// The session itself must be able to write, even once the server is read-only.
+								{Name: "PGOPTIONS", Value: "-c default_transaction_read_only=off"},
+								{
+									Name: "PGPASSWORD",
+									ValueFrom: &corev1.EnvVarSource{
+										SecretKeyRef: &corev1.SecretKeySelector{
+											LocalObjectReference: corev1.LocalObjectReference{Name: backend.Name + "-database"},
+											Key:                  "POSTGRES_PASSWORD",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+
+	v1 "github.com/Xe/yoke-stuff/db/postgres/v1"
+	"github.com/Xe/yoke-stuff/internal/renderreport"
+	"github.com/Xe/yoke-stuff/internal/stub"
+	"github.com/yokecd/yoke/pkg/flight/wasi/k8s"
+)
+
+// stubJobs makes lookupJob find the Jobs in existing, or fail with err when it is set.
+func stubJobs(t *testing.T, err error, existing ...string) {
+	t.Helper()
+
+	stub.Set(t, &lookupJob, func(namespace, name string) (*batchv1.Job, error) {
+		switch {
+		case err != nil:
+			return nil, err
+		case slices.Contains(existing, name):
+			return &batchv1.Job{}, nil
+		}
+		return nil, k8s.ErrorNotFound("not found")
+	})
+}
+
+func TestMaintenance(t *testing.T) {
+	const readOnlyArg = "default_transaction_read_only=on"
+
+	for _, tt := range []struct {
+		name        string
+		maintenance *v1.Maintenance
+		// existing are the maintenance Jobs from earlier renders.
+		existing []string
+		// wantArg is whether the postgres command line makes the server read-only.
+		wantArg bool
+		// wantJob is the name of the maintenance Job, if there is one.
+		wantJob string
+		// wantAnnotated is whether the Deployment and the database Secret carry readOnlyAnnotation.
+		wantAnnotated bool
+	}{
+		{
+			name: "no maintenance",
+		},
+		{
+			name:          "restart",
+			maintenance:   &v1.Maintenance{ReadOnly: true, Method: "restart"},
+			wantArg:       true,
+			wantAnnotated: true,
+		},
+		{
+			name:        "restart, writable",
+			maintenance: &v1.Maintenance{Method: "restart"},
+		},
+		{
+			name:          "online",
+			maintenance:   &v1.Maintenance{ReadOnly: true, Method: "online"},
+			wantJob:       "foo-postgres-read-only-on",
+			wantAnnotated: true,
+		},
+		{
+			name:        "online, writable",
+			maintenance: &v1.Maintenance{Method: "online"},
+			existing:    []string{"foo-postgres-read-only-on"},
+			wantJob:     "foo-postgres-read-only-off",
+		},
+		{
+			// The argument makes the server read-only, the Job clears what ALTER SYSTEM left behind for later.
+			name:          "restart after online",
+			maintenance:   &v1.Maintenance{ReadOnly: true, Method: "restart"},
+			existing:      []string{"foo-postgres-read-only-on"},
+			wantArg:       true,
+			wantJob:       "foo-postgres-read-only-off",
+			wantAnnotated: true,
+		},
+		{
+			name:     "removed while read-only online",
+			existing: []string{"foo-postgres-read-only-on"},
+			wantJob:  "foo-postgres-read-only-off",
+		},
+		{
+			name:     "removed after online",
+			existing: []string{"foo-postgres-read-only-off"},
+			wantJob:  "foo-postgres-read-only-off",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stubSecret(t, nil)
+			stubJobs(t, nil, tt.existing...)
+
+			app := postgres(v1.PostgresSpec{Maintenance: tt.maintenance})
+
+			deployment := createDeployment(app)
+			args := deployment.Spec.Template.Spec.Containers[0].Args
+			if got := slices.Contains(args, readOnlyArg); got != tt.wantArg {
+				t.Errorf("postgres args %v, want %s there: %t", args, readOnlyArg, tt.wantArg)
+			}
+
+			job, err := maintenanceJob(app, nil)
+			if err != nil {
+				t.Fatalf("maintenanceJob() failed: %v", err)
+			}
+			if got := (job != nil); got != (tt.wantJob != "") {
+				t.Fatalf("got a maintenance job: %t, want %t", got, tt.wantJob != "")
+			}
+			if job != nil {
+				if job.Name != tt.wantJob {
+					t.Errorf("job is named %s, want %s", job.Name, tt.wantJob)
+				}
+				state := strings.TrimPrefix(tt.wantJob, "foo-postgres-read-only-")
+				if command := strings.Join(job.Spec.Template.Spec.Containers[0].Command, " "); !strings.Contains(command, "SET default_transaction_read_only = "+state) {
+					t.Errorf("job runs %q, want it to set default_transaction_read_only to %s", command, state)
+				}
+			}
+
+			secret, err := createDatabaseSecret(app, nil)
+			if err != nil {
+				t.Fatalf("createDatabaseSecret() failed: %v", err)
+			}
+			for kind, annotations := range map[string]map[string]string{"Deployment": deployment.Annotations, "Secret": secret.Annotations} {
+				if _, got := annotations[readOnlyAnnotation]; got != tt.wantAnnotated {
+					t.Errorf("%s annotations %v, want %s there: %t", kind, annotations, readOnlyAnnotation, tt.wantAnnotated)
+				}
+			}
+		})
+	}
+}
+
+func TestMaintenanceLookupErrors(t *testing.T) {
+	app := postgres(v1.PostgresSpec{})
+
+	t.Run("denied", func(t *testing.T) {
+		stubJobs(t, k8s.ErrorClusterAccessNotGranted)
+
+		var report renderreport.Report
+		job, err := maintenanceJob(app, &report)
+		if err != nil || job != nil {
+			t.Fatalf("got job %v and error %v, want neither", job, err)
+		}
+		if len(report.Decisions) != 1 || report.Decisions[0].Reason != "MaintenanceUnchecked" {
+			t.Errorf("got decisions %v, want MaintenanceUnchecked", report.Decisions)
+		}
+	})
+
+	t.Run("lookup fails", func(t *testing.T) {
+		refused := errors.New("connection refused")
+		stubJobs(t, refused)
+
+		if _, err := maintenanceJob(app, nil); !errors.Is(err, refused) {
+			t.Errorf("got error %v, want %v", err, refused)
+		}
+	})
+}
@@ -3,6 +3,11 @@ package v1
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Xe/yoke-stuff/internal/conninfo"
+	"github.com/Xe/yoke-stuff/internal/podmeta"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -18,20 +23,74 @@ const (
 type Postgres struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
-	Spec              PostgresSpec `json:"spec"`
+	Spec              PostgresSpec `json:"spec" description:"The desired state of the Postgres database."`
 }
 
 type PostgresSpec struct {
-	Env         []corev1.EnvVar `json:"env,omitempty" yaml:"env,omitempty"`
-	Healthcheck bool            `json:"healthcheck,omitempty" yaml:"healthcheck,omitempty"`
+	Env          []corev1.EnvVar `json:"env,omitempty" yaml:"env,omitempty" description:"Additional environment variables for the postgres container."`
+	Healthcheck  bool            `json:"healthcheck,omitempty" yaml:"healthcheck,omitempty" description:"If true, configure liveness and readiness probes."`
+	Version      int             `json:"version,omitempty" yaml:"version,omitempty" description:"The major version of postgres to run. Defaults to 16." example:"16"`
+	Logging      *Logging        `json:"logging,omitempty" yaml:"logging,omitempty" description:"Settings for shipping postgres logs to a log collector."`
+	SecretFormat []string        `json:"secretFormat,omitempty" yaml:"secretFormat,omitempty" description:"Extra key sets to add to the <name>-database Secret: url (DATABASE_URL), discrete (DB_HOST, DB_PORT, DB_NAME, DB_USER, DB_PASSWORD), or jdbc (JDBC_DATABASE_URL). DATABASE_URL and POSTGRES_PASSWORD are always included." example:"[\"discrete\", \"jdbc\"]"`
+	Credentials  *Credentials    `json:"credentials,omitempty" yaml:"credentials,omitempty" description:"The policy for the password in the <name>-database Secret."`
+	Maintenance  *Maintenance    `json:"maintenance,omitempty" yaml:"maintenance,omitempty" description:"Settings for putting the database into maintenance mode."`
+
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty" yaml:"podAnnotations,omitempty" description:"Annotations added to the postgres pod."`
+	PodLabels      map[string]string `json:"podLabels,omitempty" yaml:"podLabels,omitempty" description:"Labels added to the postgres pod. They can't override the selector labels."`
+	CommonLabels   map[string]string `json:"commonLabels,omitempty" yaml:"commonLabels,omitempty" description:"Labels added to every object generated for this Postgres instance. They can't override the selector labels."`
+
+	Storage Storage  `json:"storage,omitempty" yaml:"storage,omitempty" description:"The persistent volume backing the database."`
+	Secrets []Secret `json:"secrets,omitempty" yaml:"secrets,omitempty" description:"Secrets synced from 1Password and exposed as environment variables."`
+}
+
+type Logging struct {
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty" yaml:"podAnnotations,omitempty" description:"Annotations added to the postgres pod, such as hints for a log collector. They take precedence over spec.podAnnotations."`
+	JSONLogs       bool              `json:"jsonLogs,omitempty" yaml:"jsonLogs,omitempty" description:"If true, write one JSON object per log line to stdout. Requires postgres 15 or later."`
+}
+
+type Credentials struct {
+	MinLength int `json:"minLength,omitempty" yaml:"minLength,omitempty" description:"The minimum length of the password. A password set outside the flight, such as by editing the <name>-database Secret, that is shorter fails the render. Generated passwords are at least 32 characters." example:"24"`
+}
+
+func (c *Credentials) UnmarshalJSON(data []byte) error {
+	type CredentialsAlt Credentials
+	var alt CredentialsAlt
+	if err := json.Unmarshal(data, &alt); err != nil {
+		return err
+	}
+	if alt.MinLength < 0 {
+		return fmt.Errorf("credentials.minLength can't be negative, got %d", alt.MinLength)
+	}
+	*c = Credentials(alt)
+	return nil
+}
+
+type Maintenance struct {
+	ReadOnly bool   `json:"readOnly,omitempty" yaml:"readOnly,omitempty" description:"If true, make new transactions read-only by default (default_transaction_read_only)."`
+	Method   string `json:"method,omitempty" yaml:"method,omitempty" description:"How to apply readOnly: restart (default) sets it on the postgres command line and rolls the pod, online changes it with ALTER SYSTEM from a Job. Once the online method has been used, a Job keeps turning the setting off in postgresql.auto.conf after switching to restart or removing maintenance." Enum:"restart,online"`
+}
 
-	Storage Storage  `json:"storage,omitempty" yaml:"storage,omitempty"`
-	Secrets []Secret `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+func (m *Maintenance) UnmarshalJSON(data []byte) error {
+	type MaintenanceAlt Maintenance
+	var alt MaintenanceAlt
+	if err := json.Unmarshal(data, &alt); err != nil {
+		return err
+	}
+	switch alt.Method {
+	case "":
+		alt.Method = "restart"
+	case "restart", "online":
+		// all is good
+	default:
+		return fmt.Errorf("unknown maintenance method %q, must be one of restart or online", alt.Method)
+	}
+	*m = Maintenance(alt)
+	return nil
 }
 
 type Secret struct {
-	Name     string `json:"name" yaml:"name"`
-	ItemPath string `json:"itemPath" yaml:"itemPath"`
+	Name     string `json:"name" yaml:"name" description:"The name of the secret in Kubernetes with <name>-postgres prepended."`
+	ItemPath string `json:"itemPath" yaml:"itemPath" description:"The 1Password item path of the secret data."`
 }
 
 func (s *Secret) UnmarshalJSON(data []byte) error {
@@ -48,8 +107,8 @@ func (s *Secret) UnmarshalJSON(data []byte) error {
 }
 
 type Storage struct {
-	Size         string  `json:"size" yaml:"size"`
-	StorageClass *string `json:"storageClass,omitempty" yaml:"storageClass,omitempty"`
+	Size         string  `json:"size" yaml:"size" description:"How much storage to allocate." example:"4Gi"`
+	StorageClass *string `json:"storageClass,omitempty" yaml:"storageClass,omitempty" description:"The Kubernetes storage class to use for the storage."`
 }
 
 func (s *Storage) UnmarshalJSON(data []byte) error {
@@ -93,6 +152,23 @@ func (v *Postgres) UnmarshalJSON(data []byte) error {
 	if alt.Kind != KindApp {
 		return fmt.Errorf("unexpected kind: expected %s but got %s", KindApp, alt.Kind)
 	}
+	if alt.Spec.Version == 0 {
+		alt.Spec.Version = 16
+	}
+	if alt.Spec.Version < 13 {
+		return fmt.Errorf("unsupported postgres version %d, must be 13 or later", alt.Spec.Version)
+	}
+	if alt.Spec.Logging != nil && alt.Spec.Logging.JSONLogs && alt.Spec.Version < 15 {
+		return fmt.Errorf("logging.jsonLogs requires postgres 15 or later, got %d", alt.Spec.Version)
+	}
+	if err := podmeta.Validate(alt.Spec.PodAnnotations, alt.Spec.PodLabels, alt.Spec.CommonLabels); err != nil {
+		return err
+	}
+	for _, format := range alt.Spec.SecretFormat {
+		if !slices.Contains(conninfo.Formats, format) {
+			return fmt.Errorf("unknown secretFormat %q, must be one of %s", format, strings.Join(conninfo.Formats, ", "))
+		}
+	}
 	*v = Postgres(alt)
 	return nil
 }
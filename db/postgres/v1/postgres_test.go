@@ -0,0 +1,51 @@
+package v1
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+// unmarshal decodes a Postgres with the given spec JSON.
+func unmarshal(spec string) (Postgres, error) {
+	var v Postgres
+	err := json.Unmarshal([]byte(`{"apiVersion": "db.x.within.website/v1", "kind": "Postgres", "metadata": {"name": "foo"}, "spec": `+spec+`}`), &v)
+	return v, err
+}
+
+func TestSecretFormat(t *testing.T) {
+	v, err := unmarshal(`{"storage": {"size": "1Gi"}, "secretFormat": ["url", "discrete", "jdbc"]}`)
+	if err != nil {
+		t.Fatalf("every known secretFormat was rejected: %v", err)
+	}
+	if want := []string{"url", "discrete", "jdbc"}; !slices.Equal(v.Spec.SecretFormat, want) {
+		t.Errorf("secretFormat = %v, want %v", v.Spec.SecretFormat, want)
+	}
+
+	_, err = unmarshal(`{"storage": {"size": "1Gi"}, "secretFormat": ["discrete", "yaml"]}`)
+	if want := `unknown secretFormat "yaml", must be one of url, discrete, jdbc`; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestMaintenanceMethod(t *testing.T) {
+	v, err := unmarshal(`{"storage": {"size": "1Gi"}, "maintenance": {"readOnly": true}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Spec.Maintenance.Method != "restart" {
+		t.Errorf("maintenance.method defaulted to %q, want restart", v.Spec.Maintenance.Method)
+	}
+
+	_, err = unmarshal(`{"storage": {"size": "1Gi"}, "maintenance": {"readOnly": true, "method": "both"}}`)
+	if want := `unknown maintenance method "both", must be one of restart or online`; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestCredentialsMinLength(t *testing.T) {
+	_, err := unmarshal(`{"storage": {"size": "1Gi"}, "credentials": {"minLength": -1}}`)
+	if want := "credentials.minLength can't be negative, got -1"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"maps"
 	"os"
 
 	appsv1 "k8s.io/api/apps/v1"
@@ -17,6 +16,7 @@ import (
 	"k8s.io/utils/ptr"
 
 	v1 "github.com/Xe/yoke-stuff/db/valkey/v1"
+	"github.com/Xe/yoke-stuff/internal/podmeta"
 
 	onepasswordv1 "github.com/1Password/onepassword-operator/api/v1"
 )
@@ -36,11 +36,7 @@ func run() error {
 		return err
 	}
 
-	// Make sure that our labels include our custom selector.
-	if app.Labels == nil {
-		app.Labels = map[string]string{}
-	}
-	maps.Copy(app.Labels, selector(app))
+	app.Labels = objectLabels(app)
 
 	var result []any
 
@@ -48,7 +44,19 @@ func run() error {
 		result = append(result, createOnepasswordSecret(app, sec))
 	}
 
-	result = append(result, createDeployment(app))
+	deployment := createDeployment(app)
+
+	var storage storagePlan
+	if app.Spec.Storage != nil && app.Spec.Storage.Enabled {
+		var err error
+		storage, err = planStorage(app)
+		if err != nil {
+			return fmt.Errorf("failed to plan storage: %w", err)
+		}
+		storage.apply(deployment)
+	}
+
+	result = append(result, deployment)
 	result = append(result, createService(app))
 
 	slog.Info("creating deployment and service for", "valkey", app.Name)
@@ -56,8 +64,13 @@ func run() error {
 	result = append(result, createServiceAccount(app))
 
 	if app.Spec.Storage != nil && app.Spec.Storage.Enabled {
-		slog.Info("creating storage for", "app", app.Name)
-		result = append(result, createStorage(app))
+		slog.Info("creating storage for", "app", app.Name, "claim", storage.Claim, "migration", storage.State)
+		for _, pvc := range storage.PVCs {
+			result = append(result, pvc)
+		}
+		if storage.Job != nil {
+			result = append(result, storage.Job)
+		}
 	}
 
 	// Create our resources (Deployment and Service) and encode them back out via Stdout.
@@ -83,7 +96,10 @@ func createDeployment(backend v1.Valkey) *appsv1.Deployment {
 			},
 			Selector: &metav1.LabelSelector{MatchLabels: selector(backend)},
 			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{Labels: backend.Labels},
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      podmeta.Merge(backend.Labels, backend.Spec.PodLabels, selector(backend)),
+					Annotations: podmeta.Merge(backend.Spec.PodAnnotations),
+				},
 				Spec: corev1.PodSpec{
 					SecurityContext: &corev1.PodSecurityContext{
 						FSGroup: ptr.To[int64](1000),
@@ -238,7 +254,7 @@ func createOnepasswordSecret(app v1.Valkey, sec v1.Secret) *onepasswordv1.OnePas
 	return result
 }
 
-func createStorage(app v1.Valkey) *corev1.PersistentVolumeClaim {
+func createStorage(app v1.Valkey, name string, storageClass *string) *corev1.PersistentVolumeClaim {
 	size, err := resource.ParseQuantity(app.Spec.Storage.Size)
 	if err != nil {
 		panic(err)
@@ -250,7 +266,7 @@ func createStorage(app v1.Valkey) *corev1.PersistentVolumeClaim {
 			Kind:       "PersistentVolumeClaim",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      app.Name + "-valkey-storage",
+			Name:      name,
 			Namespace: app.Namespace,
 			Labels:    app.Labels,
 		},
@@ -263,7 +279,7 @@ func createStorage(app v1.Valkey) *corev1.PersistentVolumeClaim {
 					corev1.ResourceStorage: size,
 				},
 			},
-			StorageClassName: app.Spec.Storage.StorageClass,
+			StorageClassName: storageClass,
 			VolumeMode:       &[]corev1.PersistentVolumeMode{corev1.PersistentVolumeFilesystem}[0],
 		},
 	}
@@ -287,6 +303,12 @@ func createServiceAccount(app v1.Valkey) *corev1.ServiceAccount {
 }
 
 // Our selector for our backend application. Independent from the regular labels passed in the backend spec.
+// objectLabels are the labels of every generated object. They include our custom selector, see package podmeta for
+// the precedence order.
+func objectLabels(app v1.Valkey) map[string]string {
+	return podmeta.Merge(app.Labels, app.Spec.CommonLabels, selector(app))
+}
+
 func selector(backend v1.Valkey) map[string]string {
 	return map[string]string{"app.kubernetes.io/name": backend.Name}
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"maps"
+	"testing"
+
+	v1 "github.com/Xe/yoke-stuff/db/valkey/v1"
+)
+
+func TestSelectorStability(t *testing.T) {
+	want := map[string]string{"app.kubernetes.io/name": "foo"}
+
+	var app v1.Valkey
+	app.Name = "foo"
+	app.Namespace = "default"
+	app.Labels = map[string]string{"app.kubernetes.io/name": "resource"}
+	app.Spec.CommonLabels = map[string]string{"app.kubernetes.io/name": "common", "env": "prod"}
+	app.Spec.PodLabels = map[string]string{"app.kubernetes.io/name": "pod", "cache": "sessions"}
+	app.Labels = objectLabels(app)
+
+	deployment := createDeployment(app)
+	if got := deployment.Spec.Selector.MatchLabels; !maps.Equal(got, want) {
+		t.Errorf("deployment selector is %v, want %v", got, want)
+	}
+	if got := createService(app).Spec.Selector; !maps.Equal(got, want) {
+		t.Errorf("service selector is %v, want %v", got, want)
+	}
+
+	pod := deployment.Spec.Template.Labels
+	if pod["app.kubernetes.io/name"] != "foo" || pod["env"] != "prod" || pod["cache"] != "sessions" {
+		t.Errorf("pod has labels %v, want the selector, commonLabels, and podLabels", pod)
+	}
+	if got := deployment.Labels; got["app.kubernetes.io/name"] != "foo" || got["env"] != "prod" || got["cache"] != "" {
+		t.Errorf("deployment has labels %v, want the selector and commonLabels only", got)
+	}
+}
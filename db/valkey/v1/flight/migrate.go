@@ -0,0 +1,286 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	v1 "github.com/Xe/yoke-stuff/db/valkey/v1"
+	"github.com/yokecd/yoke/pkg/flight/wasi/k8s"
+)
+
+const (
+	// storageClaimAnnotation is set on the Deployment and names the PVC it mounts, so that the PVC a finished
+	// migration switched to is still used on later renders.
+	storageClaimAnnotation = "db.x.within.website/storage-claim"
+	// storageMigrationAnnotation is set on the Deployment while storage.migrateTo is set and records how far the
+	// migration got: stopping, copying, failed, or complete.
+	storageMigrationAnnotation = "db.x.within.website/storage-migration"
+	// migratedFromAnnotation is set on the PVC a migration copies to and names the PVC it copies from.
+	migratedFromAnnotation = "db.x.within.website/migrated-from"
+)
+
+// lookupDeployment, lookupPVC, and lookupJob fetch objects from the cluster. They are variables so they can be
+// swapped out when the flight runs without a cluster.
+var (
+	lookupDeployment = func(namespace, name string) (*appsv1.Deployment, error) {
+		return k8s.Lookup[appsv1.Deployment](k8s.ResourceIdentifier{
+			ApiVersion: appsv1.SchemeGroupVersion.Identifier(),
+			Kind:       "Deployment",
+			Name:       name,
+			Namespace:  namespace,
+		})
+	}
+	lookupPVC = func(namespace, name string) (*corev1.PersistentVolumeClaim, error) {
+		return k8s.Lookup[corev1.PersistentVolumeClaim](k8s.ResourceIdentifier{
+			ApiVersion: "v1",
+			Kind:       "PersistentVolumeClaim",
+			Name:       name,
+			Namespace:  namespace,
+		})
+	}
+	lookupJob = func(namespace, name string) (*batchv1.Job, error) {
+		return k8s.Lookup[batchv1.Job](k8s.ResourceIdentifier{
+			ApiVersion: batchv1.SchemeGroupVersion.Identifier(),
+			Kind:       "Job",
+			Name:       name,
+			Namespace:  namespace,
+		})
+	}
+)
+
+// isLookupDenied reports if a lookup failed because the flight may not read the object, as opposed to
+// the object not existing.
+func isLookupDenied(err error) bool {
+	return k8s.IsErrForbidden(err) || k8s.IsErrUnauthenticated(err) || errors.Is(err, k8s.ErrorClusterAccessNotGranted)
+}
+
+// storagePlan is how the Valkey data volume is rendered.
+type storagePlan struct {
+	// Claim is the PVC the Deployment mounts.
+	Claim string
+	// Replicas is zero while the data is being copied, so that nothing writes to it.
+	Replicas int32
+	// State is the value of storageMigrationAnnotation, empty when nothing is being migrated.
+	State string
+	PVCs  []*corev1.PersistentVolumeClaim
+	Job   *batchv1.Job
+}
+
+// migrating reports if storage.migrateTo asks for a storage class other than the current one.
+func migrating(app v1.Valkey) bool {
+	storage := app.Spec.Storage
+	return storage.MigrateTo != "" && storage.MigrateTo != ptr.Deref(storage.StorageClass, "")
+}
+
+// planStorage works out which PVCs to render and which one Valkey mounts.
+//
+// A migration to another storage class goes through these renders:
+//
+//  1. The Deployment is scaled to zero and the target PVC is created.
+//  2. Once no valkey pod is left, not even a terminating one, a Job copies the data over.
+//  3. Once the Job has completed, the Deployment mounts the target PVC and is scaled back up. The Job is
+//     dropped, and the claim is recorded in storageClaimAnnotation.
+//  4. While migrateTo is still set, the source PVC is kept. Setting storageClass to the new class and removing
+//     migrateTo drops it.
+//
+// If the Job fails, Valkey is scaled back up on the source PVC and the Job is kept so its logs can be read.
+func planStorage(app v1.Valkey) (storagePlan, error) {
+	storage := app.Spec.Storage
+	plan := storagePlan{
+		Claim:    app.Name + "-valkey-storage",
+		Replicas: 1,
+	}
+
+	deployment, err := lookupDeployment(app.Namespace, app.Name+"-valkey")
+	switch {
+	case err == nil:
+		plan.Claim = cmp.Or(deployment.Annotations[storageClaimAnnotation], plan.Claim)
+	case k8s.IsErrNotFound(err):
+		// First deploy.
+	case isLookupDenied(err):
+		if migrating(app) {
+			return plan, fmt.Errorf("storage.migrateTo needs to look up the deployment: %w", err)
+		}
+		slog.Warn("can't look up deployment, assuming it mounts the default storage claim", "valkey", app.Name, "claim", plan.Claim, "err", err)
+	default:
+		return plan, fmt.Errorf("failed to look up deployment: %w", err)
+	}
+
+	if !migrating(app) {
+		plan.PVCs = append(plan.PVCs, createStorage(app, plan.Claim, storage.StorageClass))
+		return plan, nil
+	}
+
+	target := fmt.Sprintf("%s-valkey-storage-%s", app.Name, storage.MigrateTo)
+	source := plan.Claim
+
+	if plan.Claim == target {
+		plan.State = "complete"
+
+		// The source isn't recorded on the Deployment anymore, the target PVC remembers it.
+		pvc, err := lookupPVC(app.Namespace, target)
+		if err != nil {
+			return plan, fmt.Errorf("failed to look up storage claim %s: %w", target, err)
+		}
+		source = pvc.Annotations[migratedFromAnnotation]
+	} else {
+		job := createMigrationJob(app, source, target)
+
+		existing, err := lookupJob(app.Namespace, job.Name)
+		switch {
+		case err == nil && jobFinished(existing, batchv1.JobComplete):
+			slog.Info("storage migration finished, switching claims", "valkey", app.Name, "from", source, "to", target)
+			plan.Claim = target
+			plan.State = "complete"
+		case err == nil && jobFinished(existing, batchv1.JobFailed):
+			slog.Warn("storage migration failed, staying on the old claim", "valkey", app.Name, "job", job.Name)
+			plan.State = "failed"
+			plan.Job = job
+		case err == nil:
+			plan.State = "copying"
+			plan.Replicas = 0
+			plan.Job = job
+		case k8s.IsErrNotFound(err) && !stopped(deployment):
+			// Valkey writes its dump and AOF on the way out, so the copy waits for the pod to be gone.
+			slog.Info("waiting for valkey to stop before copying", "valkey", app.Name)
+			plan.State = "stopping"
+			plan.Replicas = 0
+		case k8s.IsErrNotFound(err):
+			plan.State = "copying"
+			plan.Replicas = 0
+			plan.Job = job
+		default:
+			return plan, fmt.Errorf("failed to look up storage migration job %s: %w", job.Name, err)
+		}
+	}
+
+	if source != "" {
+		plan.PVCs = append(plan.PVCs, createStorage(app, source, storage.StorageClass))
+	}
+
+	pvc := createStorage(app, target, &storage.MigrateTo)
+	pvc.Annotations = map[string]string{migratedFromAnnotation: source}
+	plan.PVCs = append(plan.PVCs, pvc)
+
+	return plan, nil
+}
+
+// apply points the Deployment at the planned claim.
+func (plan storagePlan) apply(result *appsv1.Deployment) {
+	result.Spec.Replicas = ptr.To(plan.Replicas)
+	result.Annotations[storageClaimAnnotation] = plan.Claim
+	if plan.State != "" {
+		result.Annotations[storageMigrationAnnotation] = plan.State
+	}
+
+	for i, volume := range result.Spec.Template.Spec.Volumes {
+		if volume.Name == "storage" {
+			result.Spec.Template.Spec.Volumes[i].PersistentVolumeClaim.ClaimName = plan.Claim
+		}
+	}
+}
+
+// stopped reports if deployment has been scaled to zero and none of its pods are left. A Deployment that doesn't
+// exist has no pods either.
+func stopped(deployment *appsv1.Deployment) bool {
+	if deployment == nil {
+		return true
+	}
+	return ptr.Deref(deployment.Spec.Replicas, 1) == 0 &&
+		deployment.Status.Replicas == 0 &&
+		ptr.Deref(deployment.Status.TerminatingReplicas, 0) == 0
+}
+
+func jobFinished(job *batchv1.Job, conditionType batchv1.JobConditionType) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// createMigrationJob copies the data directory, including the RDB dump and the AOF directory, from one PVC to
+// the other.
+func createMigrationJob(app v1.Valkey, source, target string) *batchv1.Job {
+	return &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: batchv1.SchemeGroupVersion.Identifier(),
+			Kind:       "Job",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-valkey-migrate-%s", app.Name, app.Spec.Storage.MigrateTo),
+			Namespace: app.Namespace,
+			Labels:    app.Labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: ptr.To[int32](3),
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyOnFailure,
+					SecurityContext: &corev1.PodSecurityContext{
+						FSGroup: ptr.To[int64](1000),
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "source",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+									ClaimName: source,
+									ReadOnly:  true,
+								},
+							},
+						},
+						{
+							Name: "target",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+									ClaimName: target,
+								},
+							},
+						},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:            "migrate",
+							Image:           "docker.io/bitnami/valkey:latest",
+							ImagePullPolicy: corev1.PullAlways,
+							SecurityContext: &corev1.SecurityContext{
+								RunAsUser:                ptr.To[int64](1000),
+								RunAsGroup:               ptr.To[int64](1000),
+								RunAsNonRoot:             ptr.To(true),
+								AllowPrivilegeEscalation: ptr.To(false),
+								Capabilities: &corev1.Capabilities{
+									Drop: []corev1.Capability{"ALL"},
+								},
+								SeccompProfile: &corev1.SeccompProfile{
+									Type: corev1.SeccompProfileTypeRuntimeDefault,
+								},
+							},
+							Command: []string{"cp", "-a", "/source/.", "/target/"},
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "source",
+									MountPath: "/source",
+									ReadOnly:  true,
+								},
+								{
+									Name:      "target",
+									MountPath: "/target",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	v1 "github.com/Xe/yoke-stuff/db/valkey/v1"
+	"github.com/Xe/yoke-stuff/internal/stub"
+	"github.com/yokecd/yoke/pkg/flight/wasi/k8s"
+)
+
+const (
+	sourceClaim = "foo-valkey-storage"
+	targetClaim = "foo-valkey-storage-fast-ssd"
+	migrateJob  = "foo-valkey-migrate-fast-ssd"
+)
+
+// valkey is a Valkey named foo with 1Gi of storage of storageClass, migrating to migrateTo if it's set.
+func valkey(storageClass, migrateTo string) v1.Valkey {
+	var app v1.Valkey
+	app.Name = "foo"
+	app.Namespace = "default"
+	app.Spec.Storage = &v1.Storage{
+		Enabled:   true,
+		Size:      "1Gi",
+		MigrateTo: migrateTo,
+	}
+	if storageClass != "" {
+		app.Spec.Storage.StorageClass = &storageClass
+	}
+	return app
+}
+
+// cluster is what the stubbed lookups find. Nil objects aren't found.
+type cluster struct {
+	deployment *appsv1.Deployment
+	// deploymentErr replaces the deployment lookup's result when set.
+	deploymentErr error
+	job           *batchv1.Job
+	target        *corev1.PersistentVolumeClaim
+}
+
+func (c cluster) install(t *testing.T) {
+	t.Helper()
+
+	notFound := k8s.ErrorNotFound("not found")
+	stub.Set(t, &lookupDeployment, func(namespace, name string) (*appsv1.Deployment, error) {
+		if name != "foo-valkey" {
+			t.Errorf("looked up deployment %s, want foo-valkey", name)
+		}
+		switch {
+		case c.deploymentErr != nil:
+			return nil, c.deploymentErr
+		case c.deployment == nil:
+			return nil, notFound
+		}
+		return c.deployment, nil
+	})
+	stub.Set(t, &lookupJob, func(namespace, name string) (*batchv1.Job, error) {
+		if name != migrateJob {
+			t.Errorf("looked up job %s, want %s", name, migrateJob)
+		}
+		if c.job == nil {
+			return nil, notFound
+		}
+		return c.job, nil
+	})
+	stub.Set(t, &lookupPVC, func(namespace, name string) (*corev1.PersistentVolumeClaim, error) {
+		if name != targetClaim {
+			t.Errorf("looked up storage claim %s, want %s", name, targetClaim)
+		}
+		if c.target == nil {
+			return nil, notFound
+		}
+		return c.target, nil
+	})
+}
+
+// mounting is an existing Deployment with a running pod that mounts claim.
+func mounting(claim string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{storageClaimAnnotation: claim},
+		},
+		Status: appsv1.DeploymentStatus{Replicas: 1},
+	}
+}
+
+// scaledDown is an existing Deployment that mounts claim, was scaled to zero, and still has terminating pods.
+func scaledDown(claim string, terminating int32) *appsv1.Deployment {
+	deployment := mounting(claim)
+	deployment.Spec.Replicas = ptr.To[int32](0)
+	deployment.Status.Replicas = 0
+	deployment.Status.TerminatingReplicas = ptr.To(terminating)
+	return deployment
+}
+
+// job is an existing migration Job with the given finished condition, if any.
+func job(condition batchv1.JobConditionType) *batchv1.Job {
+	result := &batchv1.Job{}
+	if condition != "" {
+		result.Status.Conditions = []batchv1.JobCondition{{Type: condition, Status: corev1.ConditionTrue}}
+	}
+	return result
+}
+
+func TestPlanStorage(t *testing.T) {
+	migrate := valkey("standard", "fast-ssd")
+
+	for _, tt := range []struct {
+		name    string
+		app     v1.Valkey
+		cluster cluster
+
+		wantClaim    string
+		wantReplicas int32
+		wantState    string
+		// wantPVCs are the names and storage classes of the rendered PVCs.
+		wantPVCs map[string]string
+		wantJob  bool
+	}{
+		{
+			name:         "first deploy",
+			app:          valkey("standard", ""),
+			wantClaim:    sourceClaim,
+			wantReplicas: 1,
+			wantPVCs:     map[string]string{sourceClaim: "standard"},
+		},
+		{
+			name:         "migrateTo is the current class",
+			app:          valkey("fast-ssd", "fast-ssd"),
+			cluster:      cluster{deployment: mounting(sourceClaim)},
+			wantClaim:    sourceClaim,
+			wantReplicas: 1,
+			wantPVCs:     map[string]string{sourceClaim: "fast-ssd"},
+		},
+		{
+			name:         "not yet migrated",
+			app:          migrate,
+			cluster:      cluster{deployment: mounting(sourceClaim)},
+			wantClaim:    sourceClaim,
+			wantReplicas: 0,
+			wantState:    "stopping",
+			wantPVCs:     map[string]string{sourceClaim: "standard", targetClaim: "fast-ssd"},
+		},
+		{
+			name:         "stopping, pod still terminating",
+			app:          migrate,
+			cluster:      cluster{deployment: scaledDown(sourceClaim, 1)},
+			wantClaim:    sourceClaim,
+			wantReplicas: 0,
+			wantState:    "stopping",
+			wantPVCs:     map[string]string{sourceClaim: "standard", targetClaim: "fast-ssd"},
+		},
+		{
+			name:         "stopped",
+			app:          migrate,
+			cluster:      cluster{deployment: scaledDown(sourceClaim, 0)},
+			wantClaim:    sourceClaim,
+			wantReplicas: 0,
+			wantState:    "copying",
+			wantPVCs:     map[string]string{sourceClaim: "standard", targetClaim: "fast-ssd"},
+			wantJob:      true,
+		},
+		{
+			name:         "first deploy with migrateTo",
+			app:          migrate,
+			wantClaim:    sourceClaim,
+			wantReplicas: 0,
+			wantState:    "copying",
+			wantPVCs:     map[string]string{sourceClaim: "standard", targetClaim: "fast-ssd"},
+			wantJob:      true,
+		},
+		{
+			name:         "mid-migration",
+			app:          migrate,
+			cluster:      cluster{deployment: scaledDown(sourceClaim, 0), job: job("")},
+			wantClaim:    sourceClaim,
+			wantReplicas: 0,
+			wantState:    "copying",
+			wantPVCs:     map[string]string{sourceClaim: "standard", targetClaim: "fast-ssd"},
+			wantJob:      true,
+		},
+		{
+			name:         "failed",
+			app:          migrate,
+			cluster:      cluster{deployment: scaledDown(sourceClaim, 0), job: job(batchv1.JobFailed)},
+			wantClaim:    sourceClaim,
+			wantReplicas: 1,
+			wantState:    "failed",
+			wantPVCs:     map[string]string{sourceClaim: "standard", targetClaim: "fast-ssd"},
+			wantJob:      true,
+		},
+		{
+			name:         "just completed",
+			app:          migrate,
+			cluster:      cluster{deployment: scaledDown(sourceClaim, 0), job: job(batchv1.JobComplete)},
+			wantClaim:    targetClaim,
+			wantReplicas: 1,
+			wantState:    "complete",
+			wantPVCs:     map[string]string{sourceClaim: "standard", targetClaim: "fast-ssd"},
+		},
+		{
+			name: "completed",
+			app:  migrate,
+			cluster: cluster{
+				deployment: mounting(targetClaim),
+				target: &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{migratedFromAnnotation: sourceClaim},
+				}},
+			},
+			wantClaim:    targetClaim,
+			wantReplicas: 1,
+			wantState:    "complete",
+			wantPVCs:     map[string]string{sourceClaim: "standard", targetClaim: "fast-ssd"},
+		},
+		{
+			name:         "completed and cleaned up",
+			app:          valkey("fast-ssd", ""),
+			cluster:      cluster{deployment: mounting(targetClaim)},
+			wantClaim:    targetClaim,
+			wantReplicas: 1,
+			wantPVCs:     map[string]string{targetClaim: "fast-ssd"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cluster.install(t)
+
+			app := tt.app
+			plan, err := planStorage(app)
+			if err != nil {
+				t.Fatalf("planStorage() failed: %v", err)
+			}
+
+			if plan.Claim != tt.wantClaim || plan.Replicas != tt.wantReplicas || plan.State != tt.wantState {
+				t.Errorf("got claim %s, %d replicas and state %q, want claim %s, %d replicas and state %q", plan.Claim, plan.Replicas, plan.State, tt.wantClaim, tt.wantReplicas, tt.wantState)
+			}
+
+			pvcs := map[string]string{}
+			for _, pvc := range plan.PVCs {
+				pvcs[pvc.Name] = ptr.Deref(pvc.Spec.StorageClassName, "")
+				if pvc.Name == targetClaim && migrating(app) && pvc.Annotations[migratedFromAnnotation] != sourceClaim {
+					t.Errorf("target PVC has annotations %v, want it to remember %s", pvc.Annotations, sourceClaim)
+				}
+			}
+			if !maps.Equal(pvcs, tt.wantPVCs) {
+				t.Errorf("got PVCs %v, want %v", pvcs, tt.wantPVCs)
+			}
+
+			if got := plan.Job != nil; got != tt.wantJob {
+				t.Fatalf("got a migration job: %t, want %t", got, tt.wantJob)
+			}
+			if plan.Job != nil {
+				claims := map[string]string{}
+				for _, volume := range plan.Job.Spec.Template.Spec.Volumes {
+					claims[volume.Name] = volume.PersistentVolumeClaim.ClaimName
+				}
+				if claims["source"] != sourceClaim || claims["target"] != targetClaim {
+					t.Errorf("job copies %s to %s, want %s to %s", claims["source"], claims["target"], sourceClaim, targetClaim)
+				}
+			}
+
+			deployment := createDeployment(app)
+			plan.apply(deployment)
+			if got := *deployment.Spec.Replicas; got != tt.wantReplicas {
+				t.Errorf("deployment has %d replicas, want %d", got, tt.wantReplicas)
+			}
+			if got := deployment.Annotations[storageClaimAnnotation]; got != tt.wantClaim {
+				t.Errorf("deployment records claim %s, want %s", got, tt.wantClaim)
+			}
+			if got := deployment.Annotations[storageMigrationAnnotation]; got != tt.wantState {
+				t.Errorf("deployment records migration %q, want %q", got, tt.wantState)
+			}
+			for _, volume := range deployment.Spec.Template.Spec.Volumes {
+				if got := volume.PersistentVolumeClaim; volume.Name == "storage" && got.ClaimName != tt.wantClaim {
+					t.Errorf("deployment mounts %s, want %s", got.ClaimName, tt.wantClaim)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanStorageLookupErrors(t *testing.T) {
+	forbidden := k8s.ErrorForbidden("forbidden")
+
+	t.Run("denied without a migration", func(t *testing.T) {
+		cluster{deploymentErr: forbidden}.install(t)
+
+		plan, err := planStorage(valkey("", ""))
+		if err != nil {
+			t.Fatalf("planStorage() failed: %v", err)
+		}
+		if plan.Claim != sourceClaim {
+			t.Errorf("got claim %s, want the default %s", plan.Claim, sourceClaim)
+		}
+	})
+
+	for name, err := range map[string]error{
+		"denied during a migration": forbidden,
+		"lookup fails":              errors.New("connection refused"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			cluster{deploymentErr: err}.install(t)
+
+			if _, got := planStorage(valkey("", "fast-ssd")); !errors.Is(got, err) {
+				t.Errorf("got error %v, want %v", got, err)
+			}
+		})
+	}
+
+	t.Run("completed without the target PVC", func(t *testing.T) {
+		cluster{deployment: mounting(targetClaim)}.install(t)
+
+		if _, err := planStorage(valkey("", "fast-ssd")); err == nil || !k8s.IsErrNotFound(err) {
+			t.Errorf("got error %v, want the target PVC to be missing", err)
+		}
+	})
+}
@@ -3,10 +3,14 @@ package v1
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+
+	"github.com/Xe/yoke-stuff/internal/podmeta"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 const (
@@ -18,20 +22,24 @@ const (
 type Valkey struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
-	Spec              ValkeySpec `json:"spec"`
+	Spec              ValkeySpec `json:"spec" description:"The desired state of the Valkey instance."`
 }
 
 type ValkeySpec struct {
-	Env         []corev1.EnvVar `json:"env,omitempty" yaml:"env,omitempty"`
-	Healthcheck bool            `json:"healthcheck,omitempty" yaml:"healthcheck,omitempty"`
+	Env         []corev1.EnvVar `json:"env,omitempty" yaml:"env,omitempty" description:"Additional environment variables for the valkey container."`
+	Healthcheck bool            `json:"healthcheck,omitempty" yaml:"healthcheck,omitempty" description:"If true, configure a liveness probe."`
+
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty" yaml:"podAnnotations,omitempty" description:"Annotations added to the valkey pod."`
+	PodLabels      map[string]string `json:"podLabels,omitempty" yaml:"podLabels,omitempty" description:"Labels added to the valkey pod. They can't override the selector labels."`
+	CommonLabels   map[string]string `json:"commonLabels,omitempty" yaml:"commonLabels,omitempty" description:"Labels added to every object generated for this Valkey instance. They can't override the selector labels."`
 
-	Storage *Storage `json:"storage,omitempty" yaml:"storage,omitempty"`
-	Secrets []Secret `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+	Storage *Storage `json:"storage,omitempty" yaml:"storage,omitempty" description:"Persistent storage for the Valkey data directory."`
+	Secrets []Secret `json:"secrets,omitempty" yaml:"secrets,omitempty" description:"Secrets synced from 1Password and exposed as environment variables."`
 }
 
 type Secret struct {
-	Name     string `json:"name" yaml:"name"`
-	ItemPath string `json:"itemPath" yaml:"itemPath"`
+	Name     string `json:"name" yaml:"name" description:"The name of the secret in Kubernetes with <name>-valkey prepended."`
+	ItemPath string `json:"itemPath" yaml:"itemPath" description:"The 1Password item path of the secret data."`
 }
 
 func (s *Secret) UnmarshalJSON(data []byte) error {
@@ -46,9 +54,10 @@ func (s *Secret) UnmarshalJSON(data []byte) error {
 }
 
 type Storage struct {
-	Enabled      bool    `json:"enabled" yaml:"enabled"`
-	Size         string  `json:"size" yaml:"size"`
-	StorageClass *string `json:"storageClass,omitempty" yaml:"storageClass,omitempty"`
+	Enabled      bool    `json:"enabled" yaml:"enabled" description:"If true, create persistent storage for this Valkey instance."`
+	Size         string  `json:"size" yaml:"size" description:"How much storage to allocate." example:"4Gi"`
+	StorageClass *string `json:"storageClass,omitempty" yaml:"storageClass,omitempty" description:"The Kubernetes storage class to use for the storage."`
+	MigrateTo    string  `json:"migrateTo,omitempty" yaml:"migrateTo,omitempty" description:"If set to a storage class other than storageClass, copy the data to a new PVC of that class and switch Valkey over to it." example:"fast-ssd"`
 }
 
 func (s *Storage) UnmarshalJSON(data []byte) error {
@@ -65,6 +74,16 @@ func (s *Storage) UnmarshalJSON(data []byte) error {
 		return fmt.Errorf("invalid size: %v", err)
 	}
 
+	if s.MigrateTo != "" {
+		// The migration Job and PVC are named after the storage class.
+		if errs := validation.IsDNS1123Label(s.MigrateTo); len(errs) != 0 {
+			return fmt.Errorf("invalid migrateTo %q: %s", s.MigrateTo, strings.Join(errs, ", "))
+		}
+		if !s.Enabled {
+			return fmt.Errorf("migrateTo requires storage to be enabled")
+		}
+	}
+
 	return nil
 }
 
@@ -89,5 +108,8 @@ func (v *Valkey) UnmarshalJSON(data []byte) error {
 	if v.Kind != KindApp {
 		return fmt.Errorf("unexpected kind: expected %s but got %s", KindApp, v.Kind)
 	}
+	if err := podmeta.Validate(v.Spec.PodAnnotations, v.Spec.PodLabels, v.Spec.CommonLabels); err != nil {
+		return err
+	}
 	return nil
 }
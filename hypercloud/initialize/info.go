@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/Xe/yoke-stuff/internal/schema"
+)
+
+const (
+	infoGroup   = "hypercloud.x.within.website"
+	infoVersion = "v1"
+	infoKind    = "HypercloudInfo"
+
+	// infoName is the name of the only HypercloudInfo object in the cluster.
+	infoName = "hypercloud"
+)
+
+// HypercloudInfo records what initialize last applied to the cluster, so that Apps and operators can
+// find out which platform they are running on and CI can detect drift between git and the cluster.
+type HypercloudInfo struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              HypercloudInfoSpec `json:"spec" description:"What initialize applied to the cluster."`
+}
+
+type HypercloudInfoSpec struct {
+	Components []Component `json:"components" description:"The platform components initialize installed and their versions."`
+	ConfigHash string      `json:"configHash" description:"The SHA-256 of the redacted initialize config." example:"sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+}
+
+type Component struct {
+	Name    string `json:"name" description:"The name of the component." example:"cert-manager"`
+	Version string `json:"version" description:"The version of the component, taken from its container image tag." example:"v1.17.0"`
+}
+
+// makeInfoCRD creates the cluster-scoped CustomResourceDefinition for HypercloudInfo.
+func makeInfoCRD() apiextv1.CustomResourceDefinition {
+	return apiextv1.CustomResourceDefinition{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: apiextv1.SchemeGroupVersion.Identifier(),
+			Kind:       "CustomResourceDefinition",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "hypercloudinfos." + infoGroup,
+		},
+		Spec: apiextv1.CustomResourceDefinitionSpec{
+			Group: infoGroup,
+			Names: apiextv1.CustomResourceDefinitionNames{
+				Plural:   "hypercloudinfos",
+				Singular: "hypercloudinfo",
+				Kind:     infoKind,
+			},
+			Scope: apiextv1.ClusterScoped,
+			Versions: []apiextv1.CustomResourceDefinitionVersion{
+				{
+					Name:    infoVersion,
+					Served:  true,
+					Storage: true,
+					Schema: &apiextv1.CustomResourceValidation{
+						OpenAPIV3Schema: schema.SchemaFrom(reflect.TypeFor[HypercloudInfo]()),
+					},
+					AdditionalPrinterColumns: []apiextv1.CustomResourceColumnDefinition{
+						{
+							Name:     "Config Hash",
+							Type:     "string",
+							JSONPath: ".spec.configHash",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// makeInfo creates the HypercloudInfo object. It is applied on every run, so it always reflects the
+// last time initialize ran.
+func makeInfo(components []Component, configHash string) HypercloudInfo {
+	return HypercloudInfo{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: infoGroup + "/" + infoVersion,
+			Kind:       infoKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: infoName,
+		},
+		Spec: HypercloudInfoSpec{
+			Components: components,
+			ConfigHash: configHash,
+		},
+	}
+}
+
+// componentVersion finds the version of a component from the image tag of its first Deployment, ignoring any
+// digest. The manifests are vendored from upstream, so this stays correct when they are regenerated.
+func componentVersion(objs []unstructured.Unstructured) string {
+	for _, obj := range objs {
+		if obj.GetKind() != "Deployment" {
+			continue
+		}
+
+		containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+		for _, container := range containers {
+			container, ok := container.(map[string]any)
+			if !ok {
+				continue
+			}
+			image, _ := container["image"].(string)
+			// A digest isn't a version, and its sha256: would otherwise look like a tag.
+			image, _, _ = strings.Cut(image, "@")
+			if _, tag, ok := strings.Cut(image[strings.LastIndex(image, "/")+1:], ":"); ok {
+				return tag
+			}
+		}
+	}
+
+	return "unknown"
+}
+
+// hashConfig hashes the config with any inline environment variable values in the external-dns
+// settings redacted, as those are usually DNS provider credentials. The redacted config is encoded
+// with encoding/json, which sorts map keys, so the hash only changes when the config does.
+func hashConfig(cfg Config) (string, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	var redacted map[string]any
+	if err := json.Unmarshal(data, &redacted); err != nil {
+		return "", fmt.Errorf("failed to decode config: %w", err)
+	}
+
+	if externalDNS, ok := redacted["externalDNS"].(map[string]any); ok {
+		env, _ := externalDNS["env"].([]any)
+		for _, item := range env {
+			if item, ok := item.(map[string]any); ok {
+				if _, ok := item["value"]; ok {
+					item["value"] = "REDACTED"
+				}
+			}
+		}
+	}
+
+	data, err = json.Marshal(redacted)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode redacted config: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+
+	externaldns "github.com/Xe/yoke-stuff/helm/external-dns"
+)
+
+func TestInfoCRD(t *testing.T) {
+	crd := makeInfoCRD()
+
+	if want := crd.Spec.Names.Plural + "." + crd.Spec.Group; crd.Name != want {
+		t.Errorf("CRD is named %s, want %s", crd.Name, want)
+	}
+	if crd.Spec.Scope != apiextv1.ClusterScoped {
+		t.Errorf("CRD scope is %s, want Cluster", crd.Spec.Scope)
+	}
+	if len(crd.Spec.Versions) != 1 {
+		t.Fatalf("CRD has %d versions, want 1", len(crd.Spec.Versions))
+	}
+
+	version := crd.Spec.Versions[0]
+	if version.Name != infoVersion || !version.Served || !version.Storage {
+		t.Errorf("CRD version is %s served=%t storage=%t, want a served storage version %s", version.Name, version.Served, version.Storage, infoVersion)
+	}
+
+	spec := version.Schema.OpenAPIV3Schema.Properties["spec"]
+	for _, field := range []string{"components", "configHash"} {
+		if _, ok := spec.Properties[field]; !ok {
+			t.Errorf("CRD schema has no spec.%s, got %v", field, spec.Properties)
+		}
+	}
+	component := spec.Properties["components"].Items.Schema
+	for _, field := range []string{"name", "version"} {
+		if _, ok := component.Properties[field]; !ok {
+			t.Errorf("CRD schema has no spec.components[].%s, got %v", field, component.Properties)
+		}
+	}
+}
+
+func TestMakeInfo(t *testing.T) {
+	components := []Component{{Name: "cert-manager", Version: "v1.17.0"}}
+	info := makeInfo(components, "sha256:abc")
+
+	crd := makeInfoCRD()
+	if want := crd.Spec.Group + "/" + crd.Spec.Versions[0].Name; info.APIVersion != want || info.Kind != crd.Spec.Names.Kind {
+		t.Errorf("info is a %s %s, want a %s %s", info.APIVersion, info.Kind, want, crd.Spec.Names.Kind)
+	}
+	if info.Name != infoName || info.Namespace != "" {
+		t.Errorf("info is named %s/%s, want the cluster-scoped %s", info.Namespace, info.Name, infoName)
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Spec struct {
+			Components []Component `json:"components"`
+			ConfigHash string      `json:"configHash"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Spec.Components) != 1 || got.Spec.Components[0] != components[0] || got.Spec.ConfigHash != "sha256:abc" {
+		t.Errorf("info encodes as %s", data)
+	}
+}
+
+func TestComponentVersion(t *testing.T) {
+	deployment := func(images ...string) unstructured.Unstructured {
+		var containers []any
+		for _, image := range images {
+			containers = append(containers, map[string]any{"name": "main", "image": image})
+		}
+		return unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"spec": map[string]any{
+				"template": map[string]any{
+					"spec": map[string]any{"containers": containers},
+				},
+			},
+		}}
+	}
+
+	for _, tt := range []struct {
+		name string
+		objs []unstructured.Unstructured
+		want string
+	}{
+		{
+			name: "tag",
+			objs: []unstructured.Unstructured{deployment("quay.io/jetstack/cert-manager-controller:v1.17.0")},
+			want: "v1.17.0",
+		},
+		{
+			name: "registry with a port",
+			objs: []unstructured.Unstructured{deployment("localhost:5000/external-dns:v0.16.1")},
+			want: "v0.16.1",
+		},
+		{
+			name: "tag and digest",
+			objs: []unstructured.Unstructured{deployment("quay.io/bugfest/tor-controller:0.10.0@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")},
+			want: "0.10.0",
+		},
+		{
+			name: "only a digest",
+			objs: []unstructured.Unstructured{deployment("quay.io/bugfest/tor-controller@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")},
+			want: "unknown",
+		},
+		{
+			name: "first container with a tag",
+			objs: []unstructured.Unstructured{deployment("busybox", "quay.io/jetstack/cert-manager-cainjector:v1.17.0")},
+			want: "v1.17.0",
+		},
+		{
+			name: "other kinds are skipped",
+			objs: []unstructured.Unstructured{
+				{Object: map[string]any{"apiVersion": "v1", "kind": "ServiceAccount"}},
+				deployment("quay.io/jetstack/cert-manager-controller:v1.17.0"),
+			},
+			want: "v1.17.0",
+		},
+		{
+			name: "no Deployment",
+			objs: []unstructured.Unstructured{{Object: map[string]any{"apiVersion": "v1", "kind": "ServiceAccount"}}},
+			want: "unknown",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := componentVersion(tt.objs); got != tt.want {
+				t.Errorf("componentVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashConfig(t *testing.T) {
+	config := func(env ...any) Config {
+		return Config{
+			ACME: &ACME{
+				Email:       "me@xeiaso.net",
+				Directories: []ACMEDirectory{{Name: "letsencrypt-prod", URL: "https://acme-v02.api.letsencrypt.org/directory"}},
+			},
+			ExternalDNS: &externaldns.Values{Env: env},
+			ExternalIP:  IP{IPv4: ptr.To("203.0.113.1")},
+		}
+	}
+	hash := func(cfg Config) string {
+		t.Helper()
+		h, err := hashConfig(cfg)
+		if err != nil {
+			t.Fatalf("hashConfig() failed: %v", err)
+		}
+		return h
+	}
+
+	token := func(value string) any {
+		return map[string]any{"name": "CF_API_TOKEN", "value": value}
+	}
+
+	base := hash(config(token("hunter2")))
+	if !regexp.MustCompile(`^sha256:[0-9a-f]{64}$`).MatchString(base) {
+		t.Errorf("hashConfig() = %q, want sha256: and 64 hex characters", base)
+	}
+	if again := hash(config(token("hunter2"))); again != base {
+		t.Errorf("the same config hashed to %s and %s", base, again)
+	}
+
+	// The credentials are redacted, so rotating them isn't drift.
+	if rotated := hash(config(token("hunter3"))); rotated != base {
+		t.Errorf("changing an env value changed the hash from %s to %s", base, rotated)
+	}
+
+	for name, cfg := range map[string]Config{
+		"env name": config(map[string]any{"name": "CF_API_KEY", "value": "hunter2"}),
+		"env valueFrom": config(map[string]any{"name": "CF_API_TOKEN", "valueFrom": map[string]any{
+			"secretKeyRef": map[string]any{"name": "cloudflare", "key": "token"},
+		}}),
+		"external IP": func() Config {
+			cfg := config(token("hunter2"))
+			cfg.ExternalIP.IPv4 = ptr.To("203.0.113.2")
+			return cfg
+		}(),
+	} {
+		if got := hash(cfg); got == base {
+			t.Errorf("changing the %s didn't change the hash", name)
+		}
+	}
+
+	cfg := config(token("hunter2"))
+	hash(cfg)
+	if value := cfg.ExternalDNS.Env[0].(map[string]any)["value"]; value != "hunter2" {
+		t.Errorf("hashConfig() changed the config's env value to %v", value)
+	}
+}
@@ -26,6 +26,7 @@ type Config struct {
 	ACME        *ACME               `json:"acme"`
 	ExternalDNS *externaldns.Values `json:"externalDNS"`
 	ExternalIP  IP                  `json:"externalIP"`
+	ImageMirror *ImageMirror        `json:"imageMirror,omitempty"`
 }
 
 type IP struct {
@@ -60,6 +61,11 @@ func (c Config) Valid() error {
 	if err := c.ExternalIP.Valid(); err != nil {
 		errs = append(errs, fmt.Errorf("externalIP is invalid: %w", err))
 	}
+	if c.ImageMirror != nil {
+		if err := c.ImageMirror.Valid(); err != nil {
+			errs = append(errs, fmt.Errorf("imageMirror is invalid: %w", err))
+		}
+	}
 	if len(errs) > 0 {
 		return fmt.Errorf("config is invalid: %v", errors.Join(errs...))
 	}
@@ -144,7 +150,14 @@ func run() error {
 		return fmt.Errorf("config is invalid: %w", err)
 	}
 
+	// Hash the config before it is modified below.
+	configHash, err := hashConfig(cfg)
+	if err != nil {
+		return err
+	}
+
 	var result []any
+	var components []Component
 
 	result = append(result, []any{corev1.Namespace{
 		TypeMeta: metav1.TypeMeta{
@@ -168,6 +181,7 @@ func run() error {
 	}
 
 	result = append(result, torController)
+	components = append(components, Component{Name: "tor-controller", Version: componentVersion(torController)})
 
 	result = append(result, []any{corev1.Namespace{
 		TypeMeta: metav1.TypeMeta{
@@ -191,6 +205,7 @@ func run() error {
 	}
 
 	result = append(result, certManager)
+	components = append(components, Component{Name: "cert-manager", Version: componentVersion(certManager)})
 
 	var directories []any
 
@@ -241,15 +256,30 @@ func run() error {
 
 	// Filter out PodDisruptionBudgets from externalDNS
 	var filteredExternalDNS []*unstructured.Unstructured
+	var externalDNSObjects []unstructured.Unstructured
 	for _, obj := range externalDNS {
 		if obj.GetKind() == "PodDisruptionBudget" {
 			// Skip PodDisruptionBudgets
 			continue
 		}
 		filteredExternalDNS = append(filteredExternalDNS, obj)
+		externalDNSObjects = append(externalDNSObjects, *obj)
 	}
 
 	result = append(result, filteredExternalDNS)
+	components = append(components, Component{Name: "external-dns", Version: componentVersion(externalDNSObjects)})
+
+	// The CRD goes in its own stage so that it exists before the HypercloudInfo object is created.
+	result = append(result, []any{makeInfoCRD()})
+	result = append(result, []any{makeInfo(components, configHash)})
+
+	if cfg.ImageMirror != nil {
+		mirrored, err := mirrorImages(result, *cfg.ImageMirror)
+		if err != nil {
+			return fmt.Errorf("failed to mirror images: %w", err)
+		}
+		return json.NewEncoder(os.Stdout).Encode(mirrored)
+	}
 
 	return json.NewEncoder(os.Stdout).Encode(result)
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ImageMirror rewrites container images to pull from private registries, for clusters that can't reach the
+// public ones.
+type ImageMirror struct {
+	// Registries maps a registry (and optionally a repository path in it) to its replacement, such as
+	// "quay.io" to "registry.internal/quay". The longest matching prefix wins.
+	Registries map[string]string `json:"registries,omitempty"`
+	// Prefix is put in front of every image that no entry in Registries matches, such as
+	// "registry.internal/mirror" turning "quay.io/jetstack/cert-manager-controller:v1.17.0" into
+	// "registry.internal/mirror/quay.io/jetstack/cert-manager-controller:v1.17.0".
+	Prefix string `json:"prefix,omitempty"`
+}
+
+func (im ImageMirror) Valid() error {
+	var errs []error
+	for from, to := range im.Registries {
+		if from == "" {
+			errs = append(errs, fmt.Errorf("registries can't have an empty prefix"))
+		}
+		if to == "" {
+			errs = append(errs, fmt.Errorf("registry %s has an empty replacement", from))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("image mirror is invalid: %v", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// Rewrite returns the mirrored reference for image. Only the repository is changed, so tags and digests are
+// kept as they are.
+func (im ImageMirror) Rewrite(image string) string {
+	ref := normalizeImage(image)
+
+	var match, to string
+	for from, replacement := range im.Registries {
+		from = strings.TrimSuffix(from, "/")
+		if (ref == from || strings.HasPrefix(ref, from+"/")) && len(from) > len(match) {
+			match, to = from, replacement
+		}
+	}
+
+	switch {
+	case match != "":
+		return strings.TrimSuffix(to, "/") + strings.TrimPrefix(ref, match)
+	case im.Prefix != "":
+		return strings.TrimSuffix(im.Prefix, "/") + "/" + ref
+	default:
+		return image
+	}
+}
+
+// normalizeImage spells out the registry of image the way the container runtime resolves it, so that
+// "nginx:1.27" becomes "docker.io/library/nginx:1.27" and matches a "docker.io" mirror.
+func normalizeImage(image string) string {
+	first, rest, ok := strings.Cut(image, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return image
+	}
+	if !ok {
+		return "docker.io/library/" + first
+	}
+	return "docker.io/" + first + "/" + rest
+}
+
+// mirrorImages rewrites the image of every container in objs, which can be any mix of typed and unstructured
+// objects. The objects are turned into plain JSON first so that every pod template is found the same way,
+// however deeply it is nested (a Deployment's, a CronJob's job template's, or one inside a custom resource).
+func mirrorImages(objs any, mirror ImageMirror) (any, error) {
+	data, err := json.Marshal(objs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode objects: %w", err)
+	}
+
+	var result any
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode objects: %w", err)
+	}
+
+	walkContainers(result, func(container map[string]any) {
+		if image, ok := container["image"].(string); ok && image != "" {
+			container["image"] = mirror.Rewrite(image)
+		}
+	})
+
+	walkConfigMaps(result, func(data map[string]any) {
+		for key, value := range data {
+			if value, ok := value.(string); ok {
+				data[key] = configImageLine.ReplaceAllStringFunc(value, func(line string) string {
+					m := configImageLine.FindStringSubmatch(line)
+					return m[1] + mirror.Rewrite(m[2])
+				})
+			}
+		}
+	})
+
+	return result, nil
+}
+
+// configImageLine matches "image: <ref>" lines in config files kept in ConfigMaps, such as the images the
+// tor-controller starts for each OnionService.
+var configImageLine = regexp.MustCompile(`(?m)^(\s*image:\s*)(\S+)\s*$`)
+
+// walkConfigMaps calls fn for the data of every ConfigMap in v.
+func walkConfigMaps(v any, fn func(map[string]any)) {
+	switch v := v.(type) {
+	case map[string]any:
+		if v["kind"] == "ConfigMap" && v["apiVersion"] == "v1" {
+			if data, ok := v["data"].(map[string]any); ok {
+				fn(data)
+			}
+			return
+		}
+		for _, value := range v {
+			walkConfigMaps(value, fn)
+		}
+	case []any:
+		for _, item := range v {
+			walkConfigMaps(item, fn)
+		}
+	}
+}
+
+// walkContainers calls fn for every entry of a containers, initContainers, or ephemeralContainers list in v.
+func walkContainers(v any, fn func(map[string]any)) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			switch key {
+			case "containers", "initContainers", "ephemeralContainers":
+				if list, ok := value.([]any); ok {
+					for _, item := range list {
+						if container, ok := item.(map[string]any); ok {
+							fn(container)
+						}
+					}
+				}
+			}
+			walkContainers(value, fn)
+		}
+	case []any:
+		for _, item := range v {
+			walkContainers(item, fn)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var testMirror = ImageMirror{
+	Registries: map[string]string{
+		"quay.io":          "registry.internal/quay",
+		"quay.io/jetstack": "registry.internal/jetstack/",
+		"docker.io":        "registry.internal/docker",
+		"registry.k8s.io/": "registry.internal/k8s",
+	},
+}
+
+func TestRewrite(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	for _, tt := range []struct {
+		name   string
+		mirror ImageMirror
+		image  string
+		want   string
+	}{
+		{
+			name:   "tag",
+			mirror: testMirror,
+			image:  "quay.io/prometheus/node-exporter:v1.9.1",
+			want:   "registry.internal/quay/prometheus/node-exporter:v1.9.1",
+		},
+		{
+			name:   "digest",
+			mirror: testMirror,
+			image:  "quay.io/prometheus/node-exporter@" + digest,
+			want:   "registry.internal/quay/prometheus/node-exporter@" + digest,
+		},
+		{
+			name:   "tag and digest",
+			mirror: testMirror,
+			image:  "quay.io/prometheus/node-exporter:v1.9.1@" + digest,
+			want:   "registry.internal/quay/prometheus/node-exporter:v1.9.1@" + digest,
+		},
+		{
+			name:   "longest prefix wins",
+			mirror: testMirror,
+			image:  "quay.io/jetstack/cert-manager-controller:v1.17.0",
+			want:   "registry.internal/jetstack/cert-manager-controller:v1.17.0",
+		},
+		{
+			name:   "prefix only matches whole path segments",
+			mirror: ImageMirror{Registries: map[string]string{"quay.io/jet": "registry.internal/jet"}},
+			image:  "quay.io/jetstack/cert-manager-controller:v1.17.0",
+			want:   "quay.io/jetstack/cert-manager-controller:v1.17.0",
+		},
+		{
+			name:   "docker hub official image",
+			mirror: testMirror,
+			image:  "nginx:1.27",
+			want:   "registry.internal/docker/library/nginx:1.27",
+		},
+		{
+			name:   "docker hub user image",
+			mirror: testMirror,
+			image:  "bitnami/kubectl:latest",
+			want:   "registry.internal/docker/bitnami/kubectl:latest",
+		},
+		{
+			name:   "registry with a trailing slash",
+			mirror: testMirror,
+			image:  "registry.k8s.io/external-dns/external-dns:v0.16.1",
+			want:   "registry.internal/k8s/external-dns/external-dns:v0.16.1",
+		},
+		{
+			name:   "registry with a port",
+			mirror: ImageMirror{Prefix: "registry.internal/mirror"},
+			image:  "localhost:5000/stickers:latest",
+			want:   "registry.internal/mirror/localhost:5000/stickers:latest",
+		},
+		{
+			name:   "prefix for unmatched images",
+			mirror: ImageMirror{Registries: testMirror.Registries, Prefix: "registry.internal/mirror/"},
+			image:  "ghcr.io/xe/x/stickers:latest",
+			want:   "registry.internal/mirror/ghcr.io/xe/x/stickers:latest",
+		},
+		{
+			name:   "unmatched without a prefix",
+			mirror: testMirror,
+			image:  "ghcr.io/xe/x/stickers:latest",
+			want:   "ghcr.io/xe/x/stickers:latest",
+		},
+		{
+			name:  "no mirror",
+			image: "nginx:1.27",
+			want:  "nginx:1.27",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.mirror.Rewrite(tt.image); got != tt.want {
+				t.Errorf("Rewrite(%q) = %q, want %q", tt.image, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageMirrorValid(t *testing.T) {
+	if err := testMirror.Valid(); err != nil {
+		t.Errorf("Valid() = %v", err)
+	}
+
+	err := ImageMirror{Registries: map[string]string{"": "registry.internal", "quay.io": ""}}.Valid()
+	if err == nil {
+		t.Fatal("Valid() accepted an empty prefix and replacement")
+	}
+	for _, want := range []string{"registries can't have an empty prefix", "registry quay.io has an empty replacement"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %q, want it to contain %q", err, want)
+		}
+	}
+}
+
+// images collects the image of every container in the mirrored objs, sorted.
+func images(t *testing.T, objs []any) []string {
+	t.Helper()
+
+	mirrored, err := mirrorImages(objs, testMirror)
+	if err != nil {
+		t.Fatalf("mirrorImages() failed: %v", err)
+	}
+
+	var result []string
+	walkContainers(mirrored, func(container map[string]any) {
+		result = append(result, container["image"].(string))
+	})
+	slices.Sort(result)
+	return result
+}
+
+func TestMirrorImages(t *testing.T) {
+	podSpec := corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "init", Image: "busybox:1.37"}},
+		Containers:     []corev1.Container{{Name: "main", Image: "quay.io/jetstack/cert-manager-controller:v1.17.0"}},
+	}
+
+	for _, tt := range []struct {
+		name string
+		obj  any
+		want []string
+	}{
+		{
+			name: "typed Deployment",
+			obj: &appsv1.Deployment{
+				TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+				Spec: appsv1.DeploymentSpec{
+					Template: corev1.PodTemplateSpec{Spec: podSpec},
+				},
+			},
+			want: []string{
+				"registry.internal/docker/library/busybox:1.37",
+				"registry.internal/jetstack/cert-manager-controller:v1.17.0",
+			},
+		},
+		{
+			name: "unstructured Deployment",
+			obj: &unstructured.Unstructured{Object: map[string]any{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"spec": map[string]any{
+					"template": map[string]any{
+						"spec": map[string]any{
+							"initContainers": []any{map[string]any{"name": "init", "image": "busybox:1.37"}},
+							"containers":     []any{map[string]any{"name": "main", "image": "registry.k8s.io/external-dns/external-dns:v0.16.1"}},
+						},
+					},
+				},
+			}},
+			want: []string{
+				"registry.internal/docker/library/busybox:1.37",
+				"registry.internal/k8s/external-dns/external-dns:v0.16.1",
+			},
+		},
+		{
+			name: "CronJob job template",
+			obj: &batchv1.CronJob{
+				TypeMeta: metav1.TypeMeta{APIVersion: "batch/v1", Kind: "CronJob"},
+				Spec: batchv1.CronJobSpec{
+					JobTemplate: batchv1.JobTemplateSpec{
+						Spec: batchv1.JobSpec{
+							Template: corev1.PodTemplateSpec{Spec: podSpec},
+						},
+					},
+				},
+			},
+			want: []string{
+				"registry.internal/docker/library/busybox:1.37",
+				"registry.internal/jetstack/cert-manager-controller:v1.17.0",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := images(t, []any{tt.obj})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got images %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMirrorImagesLeavesInputAlone(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "main", Image: "nginx:1.27"}},
+			}},
+		},
+	}
+
+	images(t, []any{deployment})
+	if got := deployment.Spec.Template.Spec.Containers[0].Image; got != "nginx:1.27" {
+		t.Errorf("mirrorImages() changed its input to %s", got)
+	}
+}
+
+func TestMirrorImagesConfigMaps(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		Data: map[string]string{
+			"config.yaml": "tor:\n  image: quay.io/bugfest/tor:0.4.8.16\nmanager:\n  image: quay.io/bugfest/tor-onionbalance-manager:0.2.2\nname: image: not-an-image\n",
+		},
+	}
+
+	mirrored, err := mirrorImages([]any{configMap}, testMirror)
+	if err != nil {
+		t.Fatalf("mirrorImages() failed: %v", err)
+	}
+
+	got := mirrored.([]any)[0].(map[string]any)["data"].(map[string]any)["config.yaml"]
+	want := "tor:\n  image: registry.internal/quay/bugfest/tor:0.4.8.16\nmanager:\n  image: registry.internal/quay/bugfest/tor-onionbalance-manager:0.2.2\nname: image: not-an-image\n"
+	if got != want {
+		t.Errorf("got config %q, want %q", got, want)
+	}
+}
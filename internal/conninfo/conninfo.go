@@ -0,0 +1,79 @@
+// Package conninfo renders database connection details into the environment
+// variable names that common frameworks expect.
+package conninfo
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// Formats that can be requested with Postgres.Spec.SecretFormat.
+const (
+	// FormatURL is DATABASE_URL, as used by Rails, Heroku-style buildpacks, and most Go programs.
+	FormatURL = "url"
+	// FormatDiscrete is one key per connection parameter, as used by Django settings files.
+	FormatDiscrete = "discrete"
+	// FormatJDBC is JDBC_DATABASE_URL, as used by Spring.
+	FormatJDBC = "jdbc"
+)
+
+// Formats is every supported format in the order they are documented.
+var Formats = []string{FormatURL, FormatDiscrete, FormatJDBC}
+
+// Postgres is everything needed to connect to a postgres database.
+type Postgres struct {
+	Host     string
+	Port     int
+	Database string
+	User     string
+	Password string
+}
+
+// URL returns a postgres:// connection URL.
+//
+// The user and password are percent-encoded, so passwords with characters
+// like @, /, or : still parse. Generated passwords are hex and come out
+// unchanged, so the DATABASE_URL of existing secrets stays the same.
+func (p Postgres) URL() string {
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(p.User, p.Password),
+		Host:   net.JoinHostPort(p.Host, strconv.Itoa(p.Port)),
+		Path:   "/" + p.Database,
+	}
+	return u.String()
+}
+
+// JDBCURL returns a jdbc:postgresql:// connection URL with the credentials
+// passed as query parameters.
+func (p Postgres) JDBCURL() string {
+	q := url.Values{}
+	q.Set("user", p.User)
+	q.Set("password", p.Password)
+	return fmt.Sprintf("jdbc:postgresql://%s:%d/%s?%s", p.Host, p.Port, p.Database, q.Encode())
+}
+
+// Keys returns the secret keys for each of the requested formats. Unknown
+// formats are ignored; callers are expected to have validated them.
+func (p Postgres) Keys(formats []string) map[string]string {
+	result := map[string]string{}
+
+	for _, format := range formats {
+		switch format {
+		case FormatURL:
+			result["DATABASE_URL"] = p.URL()
+		case FormatDiscrete:
+			result["DB_HOST"] = p.Host
+			result["DB_PORT"] = fmt.Sprint(p.Port)
+			result["DB_NAME"] = p.Database
+			result["DB_USER"] = p.User
+			result["DB_PASSWORD"] = p.Password
+		case FormatJDBC:
+			result["JDBC_DATABASE_URL"] = p.JDBCURL()
+		}
+	}
+
+	return result
+}
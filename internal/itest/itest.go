@@ -0,0 +1,211 @@
+// Package itest runs the flights in this repo against a real Kubernetes API
+// server, to catch the mistakes that only the server's validation finds, such
+// as an empty VolumeSource or two mounts with the same mountPath.
+//
+// For each custom resource, the harness installs the CRD that its airway main
+// renders, checks a sample with a server-side dry run, then builds the
+// flight's Wasm module and runs it with `yoke takeoff --dry --cluster-access`,
+// so that the flight's lookups and every object it renders go through the API
+// server without changing anything.
+//
+// The tests only build with the itest tag and only run when ITEST_KUBECONTEXT
+// names the kube context to use, so that they never touch a cluster by
+// accident. They need kubectl and yoke in $PATH. With kind:
+//
+//	kind create cluster --name itest
+//	ITEST_KUBECONTEXT=kind-itest go test -tags itest ./internal/itest
+//
+// Each test works in a namespace of its own, which is deleted afterwards. The
+// CRDs are left installed.
+package itest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/yokecd/yoke/pkg/apis/airway/v1alpha1"
+)
+
+// ContextEnv is the environment variable that names the kube context the tests run against.
+const ContextEnv = "ITEST_KUBECONTEXT"
+
+// Cluster is a namespace in the cluster the tests run against.
+type Cluster struct {
+	// Context is the kube context passed to kubectl and yoke.
+	Context string
+	// Namespace is where the samples are dry-run and the flights are run.
+	Namespace string
+	// root is the directory of the module, where go commands run.
+	root string
+}
+
+// New returns a Cluster with a new namespace, which is deleted when the test ends. It skips the test unless
+// ContextEnv is set.
+func New(t testing.TB) *Cluster {
+	t.Helper()
+
+	kubeContext := os.Getenv(ContextEnv)
+	if kubeContext == "" {
+		t.Skipf("%s isn't set, not running integration tests", ContextEnv)
+	}
+	for _, tool := range []string{"kubectl", "yoke"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Fatalf("integration tests need %s: %v", tool, err)
+		}
+	}
+
+	root, err := run(nil, "", "go", "list", "-m", "-f", "{{.Dir}}")
+	if err != nil {
+		t.Fatalf("can't find the module's directory: %v", err)
+	}
+
+	c := &Cluster{
+		Context:   kubeContext,
+		Namespace: "itest-" + strings.ToLower(rand.Text()[:8]),
+		root:      strings.TrimSpace(string(root)),
+	}
+	c.Kubectl(t, nil, "create", "namespace", c.Namespace)
+	t.Cleanup(func() {
+		if _, err := c.kubectl(nil, "delete", "namespace", c.Namespace, "--wait=false"); err != nil {
+			t.Errorf("failed to delete namespace %s: %v", c.Namespace, err)
+		}
+	})
+
+	return c
+}
+
+// Kubectl runs kubectl with args against the cluster, with stdin as its input, and returns what it printed.
+func (c *Cluster) Kubectl(t testing.TB, stdin []byte, args ...string) []byte {
+	t.Helper()
+
+	out, err := c.kubectl(stdin, args...)
+	if err != nil {
+		t.Fatalf("kubectl %s: %v", strings.Join(args, " "), err)
+	}
+	return out
+}
+
+func (c *Cluster) kubectl(stdin []byte, args ...string) ([]byte, error) {
+	return run(stdin, "", "kubectl", append([]string{"--context", c.Context}, args...)...)
+}
+
+// InstallAirway renders the Airway of the airway main in pkg, such as ./app/v1/airway, and applies its template
+// as a CRD, the way yoke's ATC would.
+func (c *Cluster) InstallAirway(t testing.TB, pkg string) {
+	t.Helper()
+
+	out, err := run(nil, c.root, "go", "run", pkg)
+	if err != nil {
+		t.Fatalf("failed to render the Airway of %s: %v", pkg, err)
+	}
+
+	var airway v1alpha1.Airway
+	if err := json.Unmarshal(out, &airway); err != nil {
+		t.Fatalf("%s didn't render an Airway: %v", pkg, err)
+	}
+
+	crd, err := json.Marshal(apiextv1.CustomResourceDefinition{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: apiextv1.SchemeGroupVersion.Identifier(),
+			Kind:       "CustomResourceDefinition",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: airway.Spec.Template.Names.Plural + "." + airway.Spec.Template.Group,
+		},
+		Spec: airway.Spec.Template,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Kubectl(t, crd, "apply", "--server-side", "--force-conflicts", "-f", "-")
+	c.Kubectl(t, nil, "wait", "--for=condition=Established", "--timeout=60s", "crd/"+airway.Spec.Template.Names.Plural+"."+airway.Spec.Template.Group)
+}
+
+// DryRun checks the custom resource in the YAML file sample against the installed CRD with a server-side dry run.
+func (c *Cluster) DryRun(t testing.TB, sample string) {
+	t.Helper()
+
+	c.Kubectl(t, c.manifest(t, sample), "apply", "--dry-run=server", "--namespace", c.Namespace, "-f", "-")
+}
+
+// Takeoff builds the flight in pkg, such as ./app/v1/flight, for Wasm and runs it with sample as its input. yoke
+// applies what it renders as a dry run, so the API server validates every object without creating any.
+func (c *Cluster) Takeoff(t testing.TB, pkg, sample string) {
+	t.Helper()
+
+	wasm := filepath.Join(t.TempDir(), "flight.wasm")
+	build := exec.Command("go", "build", "-o", wasm, pkg)
+	build.Dir = c.root
+	build.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build %s: %v\n%s", pkg, err, out)
+	}
+
+	release := strings.TrimSuffix(filepath.Base(sample), filepath.Ext(sample))
+	if _, err := run(c.manifest(t, sample), "", "yoke", "takeoff",
+		"--kube-context", c.Context,
+		"--namespace", c.Namespace,
+		"--cluster-access",
+		"--dry",
+		release, wasm,
+	); err != nil {
+		t.Fatalf("yoke takeoff of %s with %s: %v", pkg, sample, err)
+	}
+}
+
+// manifest reads the custom resource in the YAML file sample and puts it in the Cluster's namespace. The ATC
+// always passes a namespace, and flights use it for their lookups.
+func (c *Cluster) manifest(t testing.TB, sample string) []byte {
+	t.Helper()
+
+	f, err := os.Open(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var obj map[string]any
+	if err := yaml.NewYAMLToJSONDecoder(f).Decode(&obj); err != nil {
+		t.Fatalf("failed to decode %s: %v", sample, err)
+	}
+
+	metadata, _ := obj["metadata"].(map[string]any)
+	if metadata == nil {
+		metadata = map[string]any{}
+		obj["metadata"] = metadata
+	}
+	metadata["namespace"] = c.Namespace
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// run runs name with args in dir and returns its standard output. Errors include what it printed to standard
+// error.
+func run(stdin []byte, dir, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w\n%s", err, stderr.Bytes())
+	}
+	return stdout.Bytes(), nil
+}
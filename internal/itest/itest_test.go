@@ -0,0 +1,37 @@
+//go:build itest
+
+package itest
+
+import "testing"
+
+func TestHappyPaths(t *testing.T) {
+	for _, tt := range []struct {
+		name, airway, flight, sample string
+	}{
+		{
+			name:   "App",
+			airway: "./app/v1/airway",
+			flight: "./app/v1/flight",
+			sample: "testdata/app.yaml",
+		},
+		{
+			name:   "Postgres",
+			airway: "./db/postgres/v1/airway",
+			flight: "./db/postgres/v1/flight",
+			sample: "testdata/postgres.yaml",
+		},
+		{
+			name:   "Valkey",
+			airway: "./db/valkey/v1/airway",
+			flight: "./db/valkey/v1/flight",
+			sample: "testdata/valkey.yaml",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(t)
+			c.InstallAirway(t, tt.airway)
+			c.DryRun(t, tt.sample)
+			c.Takeoff(t, tt.flight, tt.sample)
+		})
+	}
+}
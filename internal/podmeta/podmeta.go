@@ -0,0 +1,41 @@
+// Package podmeta merges user-supplied labels and annotations with the ones a
+// flight sets itself, so that every flight applies them in the same order.
+//
+// The order, from lowest to highest precedence, is:
+//
+//   - the labels on the custom resource itself
+//   - commonLabels, which go on every generated object
+//   - podLabels, which only go on the pod template
+//   - the flight's selector labels, which always win so that the Deployment
+//     selector keeps matching its pods
+//
+// The selector is never built from these maps, so user labels can't change it.
+package podmeta
+
+import (
+	"maps"
+
+	"k8s.io/apimachinery/pkg/api/validation"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Merge copies each of layers into a new map in order, so that later layers
+// win. It never returns nil.
+func Merge(layers ...map[string]string) map[string]string {
+	result := map[string]string{}
+	for _, layer := range layers {
+		maps.Copy(result, layer)
+	}
+	return result
+}
+
+// Validate checks that podAnnotations, podLabels, and commonLabels are valid
+// Kubernetes annotations and labels.
+func Validate(podAnnotations, podLabels, commonLabels map[string]string) error {
+	var errs field.ErrorList
+	errs = append(errs, validation.ValidateAnnotations(podAnnotations, field.NewPath("podAnnotations"))...)
+	errs = append(errs, metav1validation.ValidateLabels(podLabels, field.NewPath("podLabels"))...)
+	errs = append(errs, metav1validation.ValidateLabels(commonLabels, field.NewPath("commonLabels"))...)
+	return errs.ToAggregate()
+}
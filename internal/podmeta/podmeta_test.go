@@ -0,0 +1,55 @@
+package podmeta
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	resource := map[string]string{"team": "resource", "tier": "resource", "env": "resource", "app.kubernetes.io/name": "resource"}
+	common := map[string]string{"tier": "common", "env": "common", "app.kubernetes.io/name": "common"}
+	pod := map[string]string{"env": "pod", "app.kubernetes.io/name": "pod"}
+	selector := map[string]string{"app.kubernetes.io/name": "foo"}
+
+	got := Merge(resource, common, pod, selector)
+	want := map[string]string{"team": "resource", "tier": "common", "env": "pod", "app.kubernetes.io/name": "foo"}
+	if !maps.Equal(got, want) {
+		t.Errorf("Merge() = %v, want %v", got, want)
+	}
+
+	if resource["tier"] != "resource" || common["env"] != "common" {
+		t.Error("Merge() changed its layers")
+	}
+
+	if got := Merge(); got == nil {
+		t.Error("Merge() = nil, want an empty map")
+	}
+	if got := Merge(nil, nil); got == nil || len(got) != 0 {
+		t.Errorf("Merge(nil, nil) = %v, want an empty map", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(
+		map[string]string{"prometheus.io/scrape": "true"},
+		map[string]string{"team": "storage"},
+		map[string]string{"env": "prod"},
+	); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+
+	err := Validate(
+		map[string]string{"Not An Annotation": "x"},
+		map[string]string{"team": "not a label value"},
+		map[string]string{"-env": "prod"},
+	)
+	if err == nil {
+		t.Fatal("Validate() accepted invalid labels and annotations")
+	}
+	for _, want := range []string{"podAnnotations", "podLabels", "commonLabels"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %q, want it to mention %s", err, want)
+		}
+	}
+}
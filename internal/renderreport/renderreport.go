@@ -0,0 +1,150 @@
+// Package renderreport records the notable decisions a flight makes while
+// rendering, such as reusing an existing password or skipping a check it
+// isn't allowed to make, and writes them as an annotation on the primary
+// workload so that `kubectl describe` shows them.
+//
+// yoke's ATC owns the status subresource of airway custom resources, so the
+// annotation is the only place a flight can leave these for later. Each
+// render replaces the report of the previous one.
+package renderreport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+// Annotation is the annotation the report is written to.
+const Annotation = "x.within.website/render-report"
+
+// MaxSize is the most bytes of JSON written to Annotation. Kubernetes limits
+// all of an object's annotations to 256 KiB together, and the report should
+// only ever be a small part of that.
+const MaxSize = 4096
+
+// redacted replaces sensitive values.
+const redacted = "xxxxx"
+
+// Decision is one notable thing a render did.
+type Decision struct {
+	// Reason is a short CamelCase identifier, like the reason of an Event.
+	Reason string `json:"reason"`
+	// Message says what happened in a sentence.
+	Message string `json:"message"`
+	// Details are the key-value pairs that go with the message, such as the
+	// name of the object involved.
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Report is the list of decisions made during one render. The zero value is
+// ready to use, and a nil Report only logs the decisions.
+type Report struct {
+	Decisions []Decision
+}
+
+// Info records a decision and logs it at the info level. args are key-value
+// pairs like the ones log/slog takes.
+func (r *Report) Info(reason, message string, args ...any) {
+	r.add(slog.LevelInfo, reason, message, args)
+}
+
+// Warn records a decision and logs it at the warning level. args are
+// key-value pairs like the ones log/slog takes.
+func (r *Report) Warn(reason, message string, args ...any) {
+	r.add(slog.LevelWarn, reason, message, args)
+}
+
+func (r *Report) add(level slog.Level, reason, message string, args []any) {
+	details := map[string]string{}
+	logArgs := []any{"reason", reason}
+	for i := 0; i+1 < len(args); i += 2 {
+		key := fmt.Sprint(args[i])
+		details[key] = redact(key, fmt.Sprint(args[i+1]))
+		logArgs = append(logArgs, key, details[key])
+	}
+	if len(details) == 0 {
+		details = nil
+	}
+
+	if r != nil {
+		r.Decisions = append(r.Decisions, Decision{
+			Reason:  reason,
+			Message: message,
+			Details: details,
+		})
+	}
+
+	slog.Log(context.Background(), level, message, logArgs...)
+}
+
+// Annotate writes the report to annotations. A render without any decisions
+// leaves no annotation behind, so yoke drops the one from an earlier render.
+//
+// When the report is larger than MaxSize, decisions are dropped from the end
+// and replaced with one that says how many are missing. The logs of the render
+// still have all of them.
+func (r Report) Annotate(annotations map[string]string) {
+	if len(r.Decisions) == 0 {
+		return
+	}
+
+	decisions := r.Decisions
+	for dropped := 0; ; dropped++ {
+		kept := decisions[:len(decisions)-dropped]
+		if dropped != 0 {
+			kept = append(kept[:len(kept):len(kept)], Decision{
+				Reason:  "Truncated",
+				Message: fmt.Sprintf("%d more decisions did not fit in the annotation, see the flight's logs", dropped),
+			})
+		}
+
+		// Connection URLs are easier to read in kubectl describe without & escaped as \u0026.
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(kept); err != nil {
+			// Decisions only hold strings, so this can't happen.
+			panic(fmt.Errorf("failed to encode render report: %w", err))
+		}
+		data := strings.TrimSuffix(buf.String(), "\n")
+		if len(data) <= MaxSize || dropped == len(decisions) {
+			annotations[Annotation] = data
+			return
+		}
+	}
+}
+
+// sensitiveKeys are the detail keys whose values are never written out.
+var sensitiveKeys = []string{"password", "token", "credential"}
+
+// redact hides the value of sensitive keys and the passwords in connection
+// URLs, such as a DATABASE_URL or JDBC_DATABASE_URL.
+func redact(key, value string) string {
+	lower := strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(lower, sensitive) {
+			return redacted
+		}
+	}
+
+	prefix, rest := "", value
+	if strings.HasPrefix(rest, "jdbc:") {
+		prefix, rest = "jdbc:", strings.TrimPrefix(rest, "jdbc:")
+	}
+
+	u, err := url.Parse(rest)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return value
+	}
+
+	q := u.Query()
+	if q.Has("password") {
+		q.Set("password", redacted)
+		u.RawQuery = q.Encode()
+	}
+	return prefix + u.Redacted()
+}
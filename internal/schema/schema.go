@@ -0,0 +1,143 @@
+// Package schema wraps yoke's OpenAPI schema generation with support for
+// documenting CustomResourceDefinition fields from Go struct tags.
+package schema
+
+import (
+	"encoding/json"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/yokecd/yoke/pkg/openapi"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SchemaFrom builds an OpenAPI schema for typ with openapi.SchemaFrom and then
+// copies the `description` and `example` struct tags of every field into the
+// matching schema properties, so that `kubectl explain` has something to say.
+//
+// Example tags are used verbatim when they are valid JSON and are treated as a
+// string otherwise, so `example:"4Gi"` and `example:"[\"a\", \"b\"]"` both work.
+func SchemaFrom(typ reflect.Type) *apiextv1.JSONSchemaProps {
+	result := openapi.SchemaFrom(typ)
+	annotate(typ, result, map[reflect.Type]bool{})
+	return result
+}
+
+// opaqueTypes are the schemas of types that marshal to JSON as something other than their Go fields.
+var opaqueTypes = map[reflect.Type]*apiextv1.JSONSchemaProps{
+	// Quantities are written as strings like "4Gi" or as plain numbers.
+	reflect.TypeFor[resource.Quantity](): {
+		XIntOrString: true,
+		AnyOf: []apiextv1.JSONSchemaProps{
+			{Type: "integer"},
+			{Type: "string"},
+		},
+	},
+	reflect.TypeFor[metav1.Time](): {Type: "string", Format: "date-time"},
+}
+
+func annotate(typ reflect.Type, schema *apiextv1.JSONSchemaProps, seen map[reflect.Type]bool) {
+	if schema == nil {
+		return
+	}
+
+	if opaque, ok := opaqueTypes[typ]; ok {
+		description := schema.Description
+		// openapi.SchemaFrom describes the types it has already seen with their name, which says nothing here.
+		if collapsed(typ, schema) {
+			description = ""
+		}
+		*schema = *opaque.DeepCopy()
+		schema.Description = description
+		return
+	}
+
+	// openapi.SchemaFrom only expands a struct the first time it meets it and leaves every later pointer, slice or
+	// map of it as an object with unknown fields. Only recursion needs that, so expand the others again.
+	if typ.Kind() == reflect.Struct && !seen[typ] && collapsed(typ, schema) {
+		*schema = *openapi.SchemaFrom(typ)
+	}
+
+	switch typ.Kind() {
+	case reflect.Pointer:
+		annotate(typ.Elem(), schema, seen)
+	case reflect.Slice:
+		if schema.Items != nil {
+			annotate(typ.Elem(), schema.Items.Schema, seen)
+		}
+	case reflect.Map:
+		if schema.AdditionalProperties != nil {
+			annotate(typ.Elem(), schema.AdditionalProperties.Schema, seen)
+		}
+	case reflect.Struct:
+		// Recursive types are cut short by openapi.SchemaFrom, do the same here.
+		if seen[typ] || len(schema.Properties) == 0 {
+			return
+		}
+		seen[typ] = true
+		defer delete(seen, typ)
+
+		for i := range typ.NumField() {
+			f := typ.Field(i)
+			jTag := f.Tag.Get("json")
+
+			if f.Anonymous && jTag == "" {
+				annotate(f.Type, schema, seen)
+				continue
+			}
+
+			// openapi.SchemaFrom only inlines embedded structs without a json tag. The Kubernetes API types tag
+			// theirs with ",inline", such as the VolumeSource of a Volume, and end up under their Go name.
+			if f.Anonymous && jTag == ",inline" {
+				if prop, ok := schema.Properties[f.Name]; ok {
+					delete(schema.Properties, f.Name)
+					schema.Required = slices.DeleteFunc(schema.Required, func(key string) bool { return key == f.Name })
+					maps.Copy(schema.Properties, prop.Properties)
+					schema.Required = append(schema.Required, prop.Required...)
+				}
+				annotate(f.Type, schema, seen)
+				continue
+			}
+
+			key, _, _ := strings.Cut(jTag, ",")
+			if key == "" {
+				key = f.Name
+			}
+
+			prop, ok := schema.Properties[key]
+			if !ok {
+				continue
+			}
+
+			// The field's own schema goes first, it needs the description openapi.SchemaFrom left on it.
+			annotate(f.Type, &prop, seen)
+
+			if description, ok := f.Tag.Lookup("description"); ok {
+				prop.Description = description
+			}
+
+			if example, ok := f.Tag.Lookup("example"); ok {
+				prop.Example = exampleJSON(example)
+			}
+
+			schema.Properties[key] = prop
+		}
+	}
+}
+
+// collapsed reports whether schema is the placeholder openapi.SchemaFrom uses for a type it has already seen.
+func collapsed(typ reflect.Type, schema *apiextv1.JSONSchemaProps) bool {
+	return schema.Description == typ.PkgPath()+":"+typ.Name() && len(schema.Properties) == 0
+}
+
+func exampleJSON(example string) *apiextv1.JSON {
+	if json.Valid([]byte(example)) {
+		return &apiextv1.JSON{Raw: []byte(example)}
+	}
+	data, _ := json.Marshal(example)
+	return &apiextv1.JSON{Raw: data}
+}
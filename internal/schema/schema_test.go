@@ -0,0 +1,144 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+type testInner struct {
+	Size string `json:"size" description:"How much to allocate." example:"4Gi"`
+}
+
+type testEmbedded struct {
+	Embedded string `json:"embedded,omitempty" description:"An embedded field."`
+}
+
+type testInline struct {
+	Inline string `json:"inline,omitempty" description:"A field of an inlined struct."`
+}
+
+type testNode struct {
+	Name  string    `json:"name" description:"The name of the node."`
+	Child *testNode `json:"child,omitempty" description:"The next node."`
+}
+
+type testSpec struct {
+	testEmbedded
+	testInline `json:",inline"`
+
+	Nested   testInner            `json:"nested" description:"A nested struct."`
+	Pointer  *testInner           `json:"pointer,omitempty" description:"A pointer to a struct."`
+	Slice    []testInner          `json:"slice,omitempty" description:"A slice of structs."`
+	Pointers []*testInner         `json:"pointers,omitempty" description:"A slice of pointers to structs."`
+	Map      map[string]testInner `json:"map,omitempty" description:"A map of structs."`
+	List     []string             `json:"list,omitempty" description:"A list of strings." example:"[\"a\", \"b\"]"`
+	Node     testNode             `json:"node" description:"A recursive struct."`
+	Quantity resource.Quantity    `json:"quantity" description:"A quantity."`
+	Untagged string               `json:"untagged"`
+}
+
+// prop follows path through the properties, items and additional properties of schema.
+func prop(t *testing.T, schema *apiextv1.JSONSchemaProps, path ...string) *apiextv1.JSONSchemaProps {
+	t.Helper()
+
+	for _, key := range path {
+		switch {
+		case key == "[]" && schema.Items != nil && schema.Items.Schema != nil:
+			schema = schema.Items.Schema
+		case key == "{}" && schema.AdditionalProperties != nil && schema.AdditionalProperties.Schema != nil:
+			schema = schema.AdditionalProperties.Schema
+		default:
+			p, ok := schema.Properties[key]
+			if !ok {
+				t.Fatalf("schema has no %s in %v", key, path)
+			}
+			schema = &p
+		}
+	}
+	return schema
+}
+
+func TestSchemaFromDescriptions(t *testing.T) {
+	schema := SchemaFrom(reflect.TypeFor[testSpec]())
+
+	for _, tt := range []struct {
+		path []string
+		want string
+	}{
+		{[]string{"nested"}, "A nested struct."},
+		{[]string{"nested", "size"}, "How much to allocate."},
+		{[]string{"pointer"}, "A pointer to a struct."},
+		{[]string{"pointer", "size"}, "How much to allocate."},
+		{[]string{"slice"}, "A slice of structs."},
+		{[]string{"slice", "[]", "size"}, "How much to allocate."},
+		{[]string{"pointers", "[]", "size"}, "How much to allocate."},
+		{[]string{"map", "{}", "size"}, "How much to allocate."},
+		{[]string{"embedded"}, "An embedded field."},
+		{[]string{"inline"}, "A field of an inlined struct."},
+		{[]string{"node", "name"}, "The name of the node."},
+		{[]string{"node", "child"}, "The next node."},
+		{[]string{"quantity"}, "A quantity."},
+		{[]string{"untagged"}, ""},
+	} {
+		if got := prop(t, schema, tt.path...).Description; got != tt.want {
+			t.Errorf("%v has description %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	if _, ok := schema.Properties["testInline"]; ok {
+		t.Error("the ,inline struct is a property instead of being inlined")
+	}
+}
+
+func TestSchemaFromRepeatedTypes(t *testing.T) {
+	schema := SchemaFrom(reflect.TypeFor[testSpec]())
+
+	// testInner is met first through nested, the later uses still get its fields.
+	for _, path := range [][]string{{"pointer"}, {"slice", "[]"}, {"pointers", "[]"}, {"map", "{}"}} {
+		p := prop(t, schema, path...)
+		if p.XPreserveUnknownFields != nil || len(p.Properties) == 0 {
+			t.Errorf("%v has schema %+v, want the fields of testInner", path, p)
+		}
+	}
+
+	// Recursion is still cut short.
+	child := prop(t, schema, "node", "child")
+	if child.XPreserveUnknownFields == nil || !*child.XPreserveUnknownFields || len(child.Properties) != 0 {
+		t.Errorf("node.child has schema %+v, want an object with unknown fields", child)
+	}
+}
+
+func TestSchemaFromExamples(t *testing.T) {
+	schema := SchemaFrom(reflect.TypeFor[testSpec]())
+
+	for _, tt := range []struct {
+		path []string
+		want string
+	}{
+		{[]string{"nested", "size"}, `"4Gi"`},
+		{[]string{"pointer", "size"}, `"4Gi"`},
+		{[]string{"slice", "[]", "size"}, `"4Gi"`},
+		{[]string{"pointers", "[]", "size"}, `"4Gi"`},
+		{[]string{"map", "{}", "size"}, `"4Gi"`},
+		{[]string{"list"}, `["a", "b"]`},
+	} {
+		example := prop(t, schema, tt.path...).Example
+		if example == nil || string(example.Raw) != tt.want {
+			t.Errorf("%v has example %v, want %s", tt.path, example, tt.want)
+		}
+	}
+
+	if example := prop(t, schema, "nested").Example; example != nil {
+		t.Errorf("nested has example %s, want none", example.Raw)
+	}
+}
+
+func TestSchemaFromQuantity(t *testing.T) {
+	quantity := prop(t, SchemaFrom(reflect.TypeFor[testSpec]()), "quantity")
+	if !quantity.XIntOrString || len(quantity.AnyOf) != 2 || quantity.Type != "" {
+		t.Errorf("quantity has schema %+v, want an int-or-string", quantity)
+	}
+}
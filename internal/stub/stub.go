@@ -0,0 +1,14 @@
+// Package stub swaps out the lookup variables flights use to read the cluster, so their tests can render without
+// one.
+package stub
+
+import "testing"
+
+// Set replaces the value of v with fn until the test ends.
+func Set[T any](t testing.TB, v *T, fn T) {
+	t.Helper()
+
+	orig := *v
+	*v = fn
+	t.Cleanup(func() { *v = orig })
+}